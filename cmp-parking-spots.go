@@ -28,15 +28,25 @@ func main() {
 		}
 		return false
 	}
-	rows := db.Query("SELECT id FROM sequences WHERE dataframe = 'parked_cars'")
+	rows, err := db.Query("SELECT id FROM sequences WHERE dataframe = 'parked_cars'")
+	if err != nil {
+		panic(err)
+	}
 	for rows.Next() {
 		var id int
-		rows.Scan(&id)
+		if err := rows.Scan(&id); err != nil {
+			panic(err)
+		}
 		var xlist, ylist []int
-		detectionRows := db.Query("SELECT d.polygon FROM detections AS d, sequence_members AS sm WHERE sm.sequence_id = ? AND sm.detection_id = d.id", id)
+		detectionRows, err := db.Query("SELECT d.polygon FROM detections AS d, sequence_members AS sm WHERE sm.sequence_id = ? AND sm.detection_id = d.id", id)
+		if err != nil {
+			panic(err)
+		}
 		for detectionRows.Next() {
 			var s string
-			detectionRows.Scan(&s)
+			if err := detectionRows.Scan(&s); err != nil {
+				panic(err)
+			}
 			polygon := pipeline.ParsePolygon(s)
 			var sum common.Point
 			for _, p := range polygon {
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"./pipeline"
+
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Compile a query file and print the changes to the dataframes table.
+// Pass "apply" as the second argument to write the changes, or "explain" to
+// show what the next pipeline run would recompute if they were applied.
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println("usage: go run compile-query.go QUERY_FILE [apply|explain]")
+		os.Exit(1)
+	}
+	bytes, err := ioutil.ReadFile(os.Args[1])
+	if err != nil {
+		panic(err)
+	}
+	existing, err := pipeline.GetDataframeSpecs()
+	if err != nil {
+		panic(err)
+	}
+	specs, err := pipeline.CompileQuery(string(bytes), existing)
+	if err != nil {
+		fmt.Printf("%s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+	changes := pipeline.DiffDataframes(existing, specs)
+	if len(changes) == 0 {
+		fmt.Println("dataframes are up to date")
+		return
+	}
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	if len(os.Args) >= 3 && os.Args[2] == "explain" {
+		// ApplyDataframeChanges resets the rerun time of changed dataframes
+		specs := make(map[string]pipeline.DataframeSpec)
+		for name, spec := range existing {
+			specs[name] = spec
+		}
+		for _, change := range changes {
+			spec := change.New
+			spec.RerunTime = pipeline.DefaultRerunTime
+			specs[spec.Name] = spec
+		}
+		ops, err := pipeline.BuildPipeline(specs)
+		if err != nil {
+			panic(err)
+		}
+		plan, err := ops.Explain()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(plan)
+	} else if len(os.Args) >= 3 && os.Args[2] == "apply" {
+		if err := pipeline.ApplyDataframeChanges(changes); err != nil {
+			panic(err)
+		}
+		fmt.Printf("applied %d changes\n", len(changes))
+	}
+}
@@ -0,0 +1,158 @@
+package detector
+
+import (
+	"github.com/mitroadmaps/gomapinfer/common"
+
+	"bufio"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Runs the darknet binary in detect mode, which reads image paths from stdin.
+// This requires the darknet fork at https://github.com/uakfdotb/darknet that
+// prints the bounding box of each detection to stdout.
+type DarknetDetector struct {
+	cmd *exec.Cmd
+	stdin io.WriteCloser
+	stdout *bufio.Reader
+}
+
+// Start darknet in dir with the model configuration and weights, which are
+// relative to dir, and the detection threshold.
+func NewDarknetDetector(dir string, cfg string, weights string, threshold float64) (*DarknetDetector, error) {
+	c := exec.Command("./darknet", "detect", cfg, weights, "-thresh", fmt.Sprintf("%v", threshold))
+	c.Dir = dir
+	stdin, err := c.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := c.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		r := bufio.NewReader(stderr)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fmt.Println("[darknet] [stderr] " + strings.TrimSpace(line))
+		}
+	}()
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+	d := &DarknetDetector{
+		cmd: c,
+		stdin: stdin,
+		stdout: bufio.NewReader(stdout),
+	}
+	// wait for the model to load
+	if _, err := d.readUntilPrompt(); err != nil {
+		d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// Read output lines until darknet prompts for the next image path.
+func (d *DarknetDetector) readUntilPrompt() ([]string, error) {
+	var output string
+	for {
+		line, err := d.stdout.ReadString(':')
+		if err != nil {
+			return nil, err
+		}
+		fmt.Println("[darknet] [stdout] " + strings.TrimSpace(line))
+		output += line
+		if strings.Contains(line, "Enter") {
+			break
+		}
+	}
+	return strings.Split(output, "\n"), nil
+}
+
+func (d *DarknetDetector) Detect(framePath string) ([]Box, error) {
+	// darknet runs in its own directory
+	framePath, err := filepath.Abs(framePath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := d.stdin.Write([]byte(framePath + "\n")); err != nil {
+		return nil, err
+	}
+	lines, err := d.readUntilPrompt()
+	if err != nil {
+		return nil, err
+	}
+	return parseDarknetOutput(lines)
+}
+
+// Parse detections like:
+//  car: 87%
+//  Bounding Box: Left=10, Top=20, Right=50, Bottom=40
+func parseDarknetOutput(lines []string) ([]Box, error) {
+	var boxes []Box
+	for i := 0; i < len(lines); i++ {
+		if !strings.Contains(lines[i], "%") {
+			continue
+		}
+		var box Box
+		label := strings.TrimSpace(lines[i])
+		labelParts := strings.Split(label, ": ")
+		box.Class = labelParts[0]
+		if len(labelParts) >= 2 {
+			confidence, _ := strconv.Atoi(strings.Trim(labelParts[1], "%"))
+			box.Confidence = float64(confidence) / 100
+		}
+		for i < len(lines) && !strings.Contains(lines[i], "Bounding Box:") {
+			i++
+		}
+		if i == len(lines) {
+			return nil, fmt.Errorf("missing bbox line after %s", label)
+		}
+		parts := strings.Split(strings.Split(lines[i], ": ")[1], ", ")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("bad bbox line %s", lines[i])
+		}
+		var left, top, right, bottom int
+		for _, part := range parts {
+			kvsplit := strings.Split(part, "=")
+			k := kvsplit[0]
+			v, _ := strconv.Atoi(kvsplit[1])
+			if k == "Left" {
+				left = v
+			} else if k == "Top" {
+				top = v
+			} else if k == "Right" {
+				right = v
+			} else if k == "Bottom" {
+				bottom = v
+			}
+		}
+		box.Rect = common.Rectangle{
+			common.Point{float64(left), float64(top)},
+			common.Point{float64(right), float64(bottom)},
+		}
+		boxes = append(boxes, box)
+	}
+	return boxes, nil
+}
+
+func (d *DarknetDetector) Close() error {
+	d.stdin.Close()
+	if err := d.cmd.Process.Kill(); err != nil {
+		return err
+	}
+	d.cmd.Wait()
+	return nil
+}
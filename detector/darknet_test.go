@@ -0,0 +1,40 @@
+package detector
+
+import (
+	"github.com/mitroadmaps/gomapinfer/common"
+
+	"testing"
+)
+
+func TestParseDarknetOutput(t *testing.T) {
+	lines := []string{
+		"/data/frames/1/000042.jpg: Predicted in 0.031 seconds.",
+		"car: 87%",
+		"Bounding Box: Left=10, Top=20, Right=50, Bottom=40",
+		"bus: 5%",
+		"Bounding Box: Left=100, Top=120, Right=250, Bottom=180",
+		"Enter Image Path:",
+	}
+	boxes, err := parseDarknetOutput(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Box{
+		{Rect: common.Rectangle{common.Point{10, 20}, common.Point{50, 40}}, Class: "car", Confidence: 0.87},
+		{Rect: common.Rectangle{common.Point{100, 120}, common.Point{250, 180}}, Class: "bus", Confidence: 0.05},
+	}
+	if len(boxes) != len(expected) {
+		t.Fatalf("expected %d boxes, got %v", len(expected), boxes)
+	}
+	for i := range expected {
+		if boxes[i] != expected[i] {
+			t.Errorf("box %d: expected %v, got %v", i, expected[i], boxes[i])
+		}
+	}
+}
+
+func TestParseDarknetOutputMissingBox(t *testing.T) {
+	if _, err := parseDarknetOutput([]string{"car: 87%", "Enter Image Path:"}); err == nil {
+		t.Fatal("expected error for label without bounding box")
+	}
+}
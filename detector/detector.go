@@ -0,0 +1,145 @@
+package detector
+
+import (
+	"../pipeline"
+
+	"github.com/mitroadmaps/gomapinfer/common"
+
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An object detected in a video frame, in frame pixels.
+type Box struct {
+	Rect common.Rectangle
+	Class string
+	// Confidence in [0, 1].
+	Confidence float64
+	// Track of the object in imported tracks, or 0 if unknown.
+	TrackID int
+}
+
+// Detects objects in video frames stored as image files.
+type Detector interface {
+	Detect(framePath string) ([]Box, error)
+	Close() error
+}
+
+// JSON encoding of a Box used by the HTTP and precomputed detectors, e.g.
+//  {"class": "car", "confidence": 0.87, "left": 10, "top": 20, "right": 50, "bottom": 40}
+// The confidence is 1 if it is left out.
+type jsonBox struct {
+	Class string `json:"class"`
+	Confidence *float64 `json:"confidence"`
+	Left float64 `json:"left"`
+	Top float64 `json:"top"`
+	Right float64 `json:"right"`
+	Bottom float64 `json:"bottom"`
+}
+
+func (box jsonBox) toBox() Box {
+	b := Box{
+		Rect: common.Rectangle{
+			common.Point{box.Left, box.Top},
+			common.Point{box.Right, box.Bottom},
+		},
+		Class: box.Class,
+		Confidence: 1,
+	}
+	if box.Confidence != nil {
+		b.Confidence = *box.Confidence
+	}
+	return b
+}
+
+// Returns the index of a frame extracted by ffmpeg, e.g. 000042.jpg.
+func getFrameIdx(fname string) (int, error) {
+	return strconv.Atoi(strings.TrimSuffix(fname, filepath.Ext(fname)))
+}
+
+// Run the detector on each frame of the video in frameDir, which were
+// sampled at fps frames per second, and add the frames and the boxes under the
+// dataframe to the context's driver. The video is read from the context's
+// database.
+func Ingest(ctx *pipeline.Context, videoID int, dataframe string, frameDir string, fps float64, d Detector) error {
+	var startTime time.Time
+	if err := ctx.DB.QueryRow("SELECT start_time FROM videos WHERE id = ?", videoID).Scan(&startTime); err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(frameDir)
+	if err != nil {
+		return err
+	}
+	frameIdxs := make(map[string]int)
+	var fnames []string
+	for _, fi := range files {
+		// skip precomputed detections or other files next to the frames
+		ext := strings.ToLower(filepath.Ext(fi.Name()))
+		if ext != ".jpg" && ext != ".jpeg" && ext != ".png" {
+			continue
+		}
+		frameIdx, err := getFrameIdx(fi.Name())
+		if err != nil {
+			return fmt.Errorf("bad frame filename %s: %v", fi.Name(), err)
+		}
+		frameIdxs[fi.Name()] = frameIdx
+		fnames = append(fnames, fi.Name())
+	}
+	sort.Slice(fnames, func(i, j int) bool {
+		return frameIdxs[fnames[i]] < frameIdxs[fnames[j]]
+	})
+
+	for _, fname := range fnames {
+		frameIdx := frameIdxs[fname]
+		fmt.Printf("[detector] processing %s (%d)\n", fname, frameIdx)
+		boxes, err := d.Detect(filepath.Join(frameDir, fname))
+		if err != nil {
+			return fmt.Errorf("detect %s: %v", fname, err)
+		}
+		frame, err := ctx.Driver.AddFrame(videoID, frameIdx, getFrameTime(startTime, frameIdx, fps), nil)
+		if err != nil {
+			return err
+		}
+		if _, err := saveBoxes(ctx, dataframe, frame, boxes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getFrameTime(startTime time.Time, frameIdx int, fps float64) time.Time {
+	return startTime.Add(time.Duration(float64(frameIdx) / fps * float64(time.Second)))
+}
+
+// Add boxes in a frame as detections in the dataframe, and return the
+// detections. Coordinates are rounded to integer pixels, since match-sift.py
+// parses the frame polygons as integers. The detections have no polygon in the
+// orthoimage until the frame is georeferenced.
+func saveBoxes(ctx *pipeline.Context, dataframe string, frame *pipeline.Frame, boxes []Box) ([]*pipeline.Detection, error) {
+	var detections []*pipeline.Detection
+	for _, box := range boxes {
+		var polygon common.Polygon
+		for _, p := range box.Rect.ToPolygon() {
+			polygon = append(polygon, common.Point{math.Round(p.X), math.Round(p.Y)})
+		}
+		detection := &pipeline.Detection{
+			Time: frame.Time,
+			FrameID: frame.ID,
+			Class: box.Class,
+			Confidence: box.Confidence,
+			FramePolygon: polygon,
+		}
+		if err := ctx.Driver.AddDetection(dataframe, detection); err != nil {
+			return nil, err
+		}
+		detections = append(detections, detection)
+	}
+	return detections, nil
+}
@@ -0,0 +1,55 @@
+package detector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// Client of an inference server that accepts a POST of the image bytes and
+// responds with a JSON list of boxes in the jsonBox format.
+type HTTPDetector struct {
+	URL string
+	Client *http.Client
+}
+
+func NewHTTPDetector(url string) *HTTPDetector {
+	return &HTTPDetector{
+		URL: url,
+		Client: http.DefaultClient,
+	}
+}
+
+func (d *HTTPDetector) Detect(framePath string) ([]Box, error) {
+	image, err := ioutil.ReadFile(framePath)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.Client.Post(d.URL, "image/jpeg", bytes.NewReader(image))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("inference server returned %s: %s", resp.Status, string(body))
+	}
+	var jsonBoxes []jsonBox
+	if err := json.Unmarshal(body, &jsonBoxes); err != nil {
+		return nil, fmt.Errorf("bad response from inference server: %v", err)
+	}
+	boxes := make([]Box, len(jsonBoxes))
+	for i, box := range jsonBoxes {
+		boxes[i] = box.toBox()
+	}
+	return boxes, nil
+}
+
+func (d *HTTPDetector) Close() error {
+	return nil
+}
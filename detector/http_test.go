@@ -0,0 +1,55 @@
+package detector
+
+import (
+	"github.com/mitroadmaps/gomapinfer/common"
+
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFrame(t *testing.T) string {
+	fname := filepath.Join(t.TempDir(), "000042.jpg")
+	if err := ioutil.WriteFile(fname, []byte("jpeg bytes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
+
+func TestHTTPDetector(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method != "POST" || string(body) != "jpeg bytes" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`[{"class": "car", "confidence": 0.87, "left": 10.4, "top": 20, "right": 50, "bottom": 40.6}]`))
+	}))
+	defer server.Close()
+
+	boxes, err := NewHTTPDetector(server.URL).Detect(writeTestFrame(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Box{
+		Rect: common.Rectangle{common.Point{10.4, 20}, common.Point{50, 40.6}},
+		Class: "car",
+		Confidence: 0.87,
+	}
+	if len(boxes) != 1 || boxes[0] != expected {
+		t.Fatalf("expected %v, got %v", expected, boxes)
+	}
+}
+
+func TestHTTPDetectorError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model not loaded", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	if _, err := NewHTTPDetector(server.URL).Detect(writeTestFrame(t)); err == nil {
+		t.Fatal("expected error for 503 response")
+	}
+}
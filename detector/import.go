@@ -0,0 +1,284 @@
+package detector
+
+import (
+	"../pipeline"
+
+	"github.com/mitroadmaps/gomapinfer/common"
+
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Boxes of each frame, by frame index.
+type FrameBoxes map[int][]Box
+
+func xywhToRect(x, y, w, h float64) common.Rectangle {
+	return common.Rectangle{
+		common.Point{x, y},
+		common.Point{x + w, y + h},
+	}
+}
+
+// Returns a detection score as a confidence in [0, 1]. Scores of -1 mean that
+// there is no score, as in MOTChallenge files, and are treated as 1.
+func scoreToConfidence(score float64) float64 {
+	if score == -1 {
+		return 1
+	}
+	return math.Max(0, math.Min(score, 1))
+}
+
+// Read detections in COCO format. Frame indices are parsed from the image
+// file names, e.g. 000042.jpg, and classes are the category names. Images
+// without annotations are included with no boxes.
+func ReadCOCO(r io.Reader) (FrameBoxes, error) {
+	var coco struct {
+		Images []struct {
+			ID int `json:"id"`
+			FileName string `json:"file_name"`
+		} `json:"images"`
+		Annotations []struct {
+			ImageID int `json:"image_id"`
+			CategoryID int `json:"category_id"`
+			BBox []float64 `json:"bbox"`
+			Score *float64 `json:"score"`
+			TrackID int `json:"track_id"`
+		} `json:"annotations"`
+		Categories []struct {
+			ID int `json:"id"`
+			Name string `json:"name"`
+		} `json:"categories"`
+	}
+	if err := json.NewDecoder(r).Decode(&coco); err != nil {
+		return nil, err
+	}
+	categories := make(map[int]string)
+	for _, category := range coco.Categories {
+		categories[category.ID] = category.Name
+	}
+	frames := make(FrameBoxes)
+	imageFrames := make(map[int]int)
+	for _, image := range coco.Images {
+		frameIdx, err := getFrameIdx(filepath.Base(image.FileName))
+		if err != nil {
+			return nil, fmt.Errorf("image %d: bad file name %s", image.ID, image.FileName)
+		}
+		imageFrames[image.ID] = frameIdx
+		frames[frameIdx] = nil
+	}
+	for i, annotation := range coco.Annotations {
+		frameIdx, ok := imageFrames[annotation.ImageID]
+		if !ok {
+			return nil, fmt.Errorf("annotation %d: unknown image %d", i, annotation.ImageID)
+		} else if len(annotation.BBox) != 4 {
+			return nil, fmt.Errorf("annotation %d: expected bbox [x, y, w, h]", i)
+		}
+		box := Box{
+			Rect: xywhToRect(annotation.BBox[0], annotation.BBox[1], annotation.BBox[2], annotation.BBox[3]),
+			Class: categories[annotation.CategoryID],
+			Confidence: 1,
+			TrackID: annotation.TrackID,
+		}
+		if annotation.Score != nil {
+			box.Confidence = scoreToConfidence(*annotation.Score)
+		}
+		frames[frameIdx] = append(frames[frameIdx], box)
+	}
+	return frames, nil
+}
+
+// Read detections or tracks in MOTChallenge format, with lines like:
+//  frame,id,x,y,w,h,conf[,...]
+// The id is -1 for detections that are not part of a track. The boxes have
+// the specified class, since MOT files only have one class.
+// In detection files, conf is the detector score, and is clamped to [0, 1],
+// or -1 if there is no score. In ground truth files, conf is a 0/1 flag for
+// whether the box is considered, so boxes with conf 0 are skipped rather than
+// imported with confidence 0, which det_filter and obj_track would ignore.
+func ReadMOT(r io.Reader, class string) (FrameBoxes, error) {
+	frames := make(FrameBoxes)
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		parts := strings.Split(line, ",")
+		if len(parts) < 7 {
+			return nil, fmt.Errorf("line %d: expected frame,id,x,y,w,h,conf", lineNum)
+		}
+		var vals [7]float64
+		for i := range vals {
+			var err error
+			vals[i], err = strconv.ParseFloat(strings.TrimSpace(parts[i]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNum, err)
+			}
+		}
+		if vals[6] == 0 {
+			continue
+		}
+		frameIdx := int(vals[0])
+		box := Box{
+			Rect: xywhToRect(vals[2], vals[3], vals[4], vals[5]),
+			Class: class,
+			Confidence: scoreToConfidence(vals[6]),
+		}
+		if vals[1] > 0 {
+			box.TrackID = int(vals[1])
+		}
+		frames[frameIdx] = append(frames[frameIdx], box)
+	}
+	return frames, scanner.Err()
+}
+
+// Read detections from CSV with a header row. The frame, x, y, w and h
+// columns are required, and class, confidence and track_id are optional.
+func ReadCSV(r io.Reader) (FrameBoxes, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	} else if len(records) == 0 {
+		return nil, fmt.Errorf("missing header row")
+	}
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"frame", "x", "y", "w", "h"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing column %s", name)
+		}
+	}
+
+	frames := make(FrameBoxes)
+	for lineIdx, record := range records[1:] {
+		lineNum := lineIdx + 2
+		get := func(name string) (float64, error) {
+			v, err := strconv.ParseFloat(strings.TrimSpace(record[columns[name]]), 64)
+			if err != nil {
+				return 0, fmt.Errorf("line %d: column %s: %v", lineNum, name, err)
+			}
+			return v, nil
+		}
+		var vals [5]float64
+		for i, name := range []string{"frame", "x", "y", "w", "h"} {
+			vals[i], err = get(name)
+			if err != nil {
+				return nil, err
+			}
+		}
+		box := Box{
+			Rect: xywhToRect(vals[1], vals[2], vals[3], vals[4]),
+			Confidence: 1,
+		}
+		if _, ok := columns["class"]; ok {
+			box.Class = strings.TrimSpace(record[columns["class"]])
+		}
+		if _, ok := columns["confidence"]; ok {
+			score, err := get("confidence")
+			if err != nil {
+				return nil, err
+			}
+			box.Confidence = scoreToConfidence(score)
+		}
+		if _, ok := columns["track_id"]; ok {
+			trackID, err := get("track_id")
+			if err != nil {
+				return nil, err
+			}
+			if trackID > 0 {
+				box.TrackID = int(trackID)
+			}
+		}
+		frameIdx := int(vals[0])
+		frames[frameIdx] = append(frames[frameIdx], box)
+	}
+	return frames, nil
+}
+
+// Add imported boxes as detections in the dataframe, through the context's
+// driver. Boxes are matched to existing frames of the video by index, and
+// frames that don't exist yet are added at fps frames per second from the
+// video's start time. If seqDataframe is set, boxes with a TrackID are also
+// added as sequences in that dataframe, one per track, terminated at their
+// last detection.
+func Import(ctx *pipeline.Context, videoID int, dataframe string, seqDataframe string, fps float64, frames FrameBoxes) error {
+	var startTime time.Time
+	if err := ctx.DB.QueryRow("SELECT start_time FROM videos WHERE id = ?", videoID).Scan(&startTime); err != nil {
+		return err
+	}
+	var frameIdxs []int
+	for frameIdx := range frames {
+		frameIdxs = append(frameIdxs, frameIdx)
+	}
+	sort.Ints(frameIdxs)
+	videoFrames := make(map[int]*pipeline.Frame)
+	existingFrames, err := ctx.Driver.GetVideoFrames(videoID)
+	if err != nil {
+		return err
+	}
+	for _, frame := range existingFrames {
+		videoFrames[frame.Idx] = frame
+	}
+
+	tracks := make(map[int][]*pipeline.Detection)
+	var trackIDs []int
+	var count int
+	for _, frameIdx := range frameIdxs {
+		frame := videoFrames[frameIdx]
+		if frame == nil {
+			frame, err = ctx.Driver.AddFrame(videoID, frameIdx, getFrameTime(startTime, frameIdx, fps), nil)
+			if err != nil {
+				return err
+			}
+		}
+		detections, err := saveBoxes(ctx, dataframe, frame, frames[frameIdx])
+		if err != nil {
+			return err
+		}
+		count += len(detections)
+		for i, box := range frames[frameIdx] {
+			if box.TrackID == 0 {
+				continue
+			}
+			if tracks[box.TrackID] == nil {
+				trackIDs = append(trackIDs, box.TrackID)
+			}
+			tracks[box.TrackID] = append(tracks[box.TrackID], detections[i])
+		}
+	}
+	fmt.Printf("[import] imported %d detections in %d frames\n", count, len(frameIdxs))
+	if seqDataframe == "" {
+		return nil
+	}
+
+	for _, trackID := range trackIDs {
+		detections := tracks[trackID]
+		seq, err := ctx.Driver.AddSequence(seqDataframe, detections[0].Time)
+		if err != nil {
+			return err
+		}
+		for _, detection := range detections {
+			if err := seq.AddMember(detection, detection.Time); err != nil {
+				return err
+			}
+		}
+		if err := seq.Terminate(detections[len(detections)-1].Time); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("[import] imported %d tracks\n", len(trackIDs))
+	return nil
+}
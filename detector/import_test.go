@@ -0,0 +1,270 @@
+package detector
+
+import (
+	"../pipeline"
+
+	"github.com/mitroadmaps/gomapinfer/common"
+
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns a context with a new SQLite database that has video 1, and an
+// InMemoryDriver.
+func newTestContext(t *testing.T, start time.Time) (*pipeline.Context, *pipeline.InMemoryDriver) {
+	schema, err := ioutil.ReadFile("../schema-sqlite.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := pipeline.OpenDatabase(pipeline.SQLiteDialect{}, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO videos (id, filename, start_location, start_time) VALUES (1, 'test.mp4', '', ?)", start); err != nil {
+		t.Fatal(err)
+	}
+	driver := pipeline.NewInMemoryDriver()
+	return pipeline.NewContext(db, driver), driver
+}
+
+func TestImport(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ctx, driver := newTestContext(t, start)
+	// frame 5 was already ingested, at a different time than fps would give
+	existing, err := driver.AddFrame(1, 5, start.Add(time.Minute), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rect := common.Rectangle{common.Point{10.4, 20}, common.Point{50, 40.6}}
+	frames := FrameBoxes{
+		5: {{Rect: rect, Class: "car", Confidence: 1, TrackID: 3}},
+		10: {{Rect: rect, Class: "car", Confidence: 1, TrackID: 3}, {Rect: rect, Class: "car", Confidence: 0.5}},
+	}
+	if err := Import(ctx, 1, "gt", "gt_tracks", 5, frames); err != nil {
+		t.Fatal(err)
+	}
+
+	videoFrames, err := driver.GetVideoFrames(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(videoFrames) != 2 || videoFrames[0] != existing {
+		t.Fatalf("expected the existing frame and one new frame, got %v", videoFrames)
+	} else if !videoFrames[1].Time.Equal(start.Add(2 * time.Second)) {
+		t.Fatalf("expected frame 10 at 2s, got %v", videoFrames[1].Time)
+	}
+	detections := driver.DFs["gt"].Detections
+	if len(detections) != 3 {
+		t.Fatalf("expected 3 detections, got %d", len(detections))
+	}
+	for _, detection := range detections {
+		if detection.FramePolygon[0] != (common.Point{10, 20}) {
+			t.Errorf("expected frame polygon rounded to integer pixels, got %v", detection.FramePolygon)
+		}
+	}
+
+	seqs, err := driver.GetSequences("gt_tracks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seqs) != 1 {
+		t.Fatalf("expected 1 track, got %d", len(seqs))
+	}
+	for _, seq := range seqs {
+		if len(seq.Members) != 2 || seq.Members[0].Detection.FrameID != existing.ID {
+			t.Fatalf("expected track with 2 members starting at the existing frame, got %v", seq.Members)
+		} else if seq.Terminated == nil || !seq.Terminated.Equal(videoFrames[1].Time) {
+			t.Fatalf("expected track terminated at its last detection, got %v", seq.Terminated)
+		}
+	}
+}
+
+func checkFrameBoxes(t *testing.T, name string, expected FrameBoxes, frames FrameBoxes) {
+	t.Helper()
+	if len(frames) != len(expected) {
+		t.Errorf("%s: expected %d frames, got %v", name, len(expected), frames)
+		return
+	}
+	for frameIdx, boxes := range expected {
+		got, ok := frames[frameIdx]
+		if !ok || len(got) != len(boxes) {
+			t.Errorf("%s: frame %d: expected %v, got %v", name, frameIdx, boxes, got)
+			continue
+		}
+		for i := range boxes {
+			if got[i] != boxes[i] {
+				t.Errorf("%s: frame %d box %d: expected %v, got %v", name, frameIdx, i, boxes[i], got[i])
+			}
+		}
+	}
+}
+
+func TestReadCOCO(t *testing.T) {
+	tests := []struct {
+		name string
+		input string
+		expected FrameBoxes
+		err bool
+	}{
+		{
+			name: "score and track",
+			input: `{"images": [{"id": 1, "file_name": "frames/000042.jpg"}, {"id": 2, "file_name": "000043.jpg"}],
+				"annotations": [{"image_id": 1, "category_id": 3, "bbox": [10, 20, 40, 20], "score": 0.5, "track_id": 7}],
+				"categories": [{"id": 3, "name": "car"}]}`,
+			expected: FrameBoxes{
+				42: {{Rect: common.Rectangle{common.Point{10, 20}, common.Point{50, 40}}, Class: "car", Confidence: 0.5, TrackID: 7}},
+				43: nil,
+			},
+		},
+		{
+			name: "no score",
+			input: `{"images": [{"id": 1, "file_name": "000001.jpg"}],
+				"annotations": [{"image_id": 1, "category_id": 3, "bbox": [0, 0, 1, 1]}],
+				"categories": [{"id": 3, "name": "car"}]}`,
+			expected: FrameBoxes{
+				1: {{Rect: common.Rectangle{common.Point{0, 0}, common.Point{1, 1}}, Class: "car", Confidence: 1}},
+			},
+		},
+		{
+			name: "unknown image",
+			input: `{"images": [], "annotations": [{"image_id": 1, "category_id": 3, "bbox": [0, 0, 1, 1]}]}`,
+			err: true,
+		},
+		{
+			name: "bad bbox",
+			input: `{"images": [{"id": 1, "file_name": "000001.jpg"}], "annotations": [{"image_id": 1, "bbox": [0, 0, 1]}]}`,
+			err: true,
+		},
+		{
+			name: "bad file name",
+			input: `{"images": [{"id": 1, "file_name": "frame.jpg"}]}`,
+			err: true,
+		},
+	}
+	for _, test := range tests {
+		frames, err := ReadCOCO(strings.NewReader(test.input))
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected error", test.name)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		checkFrameBoxes(t, test.name, test.expected, frames)
+	}
+}
+
+func TestReadMOT(t *testing.T) {
+	rect := common.Rectangle{common.Point{10, 20}, common.Point{50, 40}}
+	tests := []struct {
+		name string
+		input string
+		expected FrameBoxes
+		err bool
+	}{
+		{
+			name: "detections",
+			input: "1,-1,10,20,40,20,0.75\n2,-1,10,20,40,20,-1,-1,-1,-1\n\n3,-1,10,20,40,20,1.5\n",
+			expected: FrameBoxes{
+				1: {{Rect: rect, Class: "car", Confidence: 0.75}},
+				2: {{Rect: rect, Class: "car", Confidence: 1}},
+				3: {{Rect: rect, Class: "car", Confidence: 1}},
+			},
+		},
+		{
+			name: "ground truth",
+			input: "1,3,10,20,40,20,1,1,1\n1,4,10,20,40,20,0,1,1\n",
+			expected: FrameBoxes{
+				1: {{Rect: rect, Class: "car", Confidence: 1, TrackID: 3}},
+			},
+		},
+		{
+			name: "too few columns",
+			input: "1,-1,10,20,40,20\n",
+			err: true,
+		},
+		{
+			name: "bad number",
+			input: "1,-1,10,20,forty,20,1\n",
+			err: true,
+		},
+	}
+	for _, test := range tests {
+		frames, err := ReadMOT(strings.NewReader(test.input), "car")
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected error", test.name)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		checkFrameBoxes(t, test.name, test.expected, frames)
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	rect := common.Rectangle{common.Point{10, 20}, common.Point{50, 40}}
+	tests := []struct {
+		name string
+		input string
+		expected FrameBoxes
+		err bool
+	}{
+		{
+			name: "required columns",
+			input: "frame,x,y,w,h\n5,10,20,40,20\n",
+			expected: FrameBoxes{
+				5: {{Rect: rect, Confidence: 1}},
+			},
+		},
+		{
+			name: "optional columns",
+			input: "frame, x, y, w, h, class, confidence, track_id\n5,10,20,40,20,bus,0.25,2\n5,10,20,40,20,car,-1,-1\n",
+			expected: FrameBoxes{
+				5: {
+					{Rect: rect, Class: "bus", Confidence: 0.25, TrackID: 2},
+					{Rect: rect, Class: "car", Confidence: 1},
+				},
+			},
+		},
+		{
+			name: "missing column",
+			input: "frame,x,y,w\n5,10,20,40\n",
+			err: true,
+		},
+		{
+			name: "bad confidence",
+			input: "frame,x,y,w,h,confidence\n5,10,20,40,20,high\n",
+			err: true,
+		},
+		{
+			name: "empty",
+			input: "",
+			err: true,
+		},
+	}
+	for _, test := range tests {
+		frames, err := ReadCSV(strings.NewReader(test.input))
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected error", test.name)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		checkFrameBoxes(t, test.name, test.expected, frames)
+	}
+}
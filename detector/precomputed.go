@@ -0,0 +1,41 @@
+package detector
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Reads detections computed ahead of time, from a JSON file per frame with a
+// list of boxes in the jsonBox format. The file for frames/1/000042.jpg is
+// 000042.json in Dir, or in the frame's directory if Dir is empty.
+type PrecomputedDetector struct {
+	Dir string
+}
+
+func (d PrecomputedDetector) Detect(framePath string) ([]Box, error) {
+	dir := d.Dir
+	if dir == "" {
+		dir = filepath.Dir(framePath)
+	}
+	fname := filepath.Base(framePath)
+	fname = strings.TrimSuffix(fname, filepath.Ext(fname)) + ".json"
+	bytes, err := ioutil.ReadFile(filepath.Join(dir, fname))
+	if err != nil {
+		return nil, err
+	}
+	var jsonBoxes []jsonBox
+	if err := json.Unmarshal(bytes, &jsonBoxes); err != nil {
+		return nil, err
+	}
+	boxes := make([]Box, len(jsonBoxes))
+	for i, box := range jsonBoxes {
+		boxes[i] = box.toBox()
+	}
+	return boxes, nil
+}
+
+func (d PrecomputedDetector) Close() error {
+	return nil
+}
@@ -0,0 +1,43 @@
+package detector
+
+import (
+	"github.com/mitroadmaps/gomapinfer/common"
+
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrecomputedDetector(t *testing.T) {
+	dir := t.TempDir()
+	boxesJSON := `[
+		{"class": "car", "confidence": 0.5, "left": 10, "top": 20, "right": 50, "bottom": 40},
+		{"class": "bus", "left": 100, "top": 120, "right": 250, "bottom": 180}
+	]`
+	if err := ioutil.WriteFile(filepath.Join(dir, "000042.json"), []byte(boxesJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+	boxes, err := PrecomputedDetector{Dir: dir}.Detect(filepath.Join("frames", "000042.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the bus has no confidence, so it defaults to 1
+	expected := []Box{
+		{Rect: common.Rectangle{common.Point{10, 20}, common.Point{50, 40}}, Class: "car", Confidence: 0.5},
+		{Rect: common.Rectangle{common.Point{100, 120}, common.Point{250, 180}}, Class: "bus", Confidence: 1},
+	}
+	if len(boxes) != len(expected) {
+		t.Fatalf("expected %d boxes, got %v", len(expected), boxes)
+	}
+	for i := range expected {
+		if boxes[i] != expected[i] {
+			t.Errorf("box %d: expected %v, got %v", i, expected[i], boxes[i])
+		}
+	}
+}
+
+func TestPrecomputedDetectorMissingFile(t *testing.T) {
+	if _, err := (PrecomputedDetector{Dir: t.TempDir()}).Detect("000042.jpg"); err == nil {
+		t.Fatal("expected error for missing detections file")
+	}
+}
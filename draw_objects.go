@@ -27,7 +27,10 @@ func main() {
 	}*/
 
 	// draw trajectories
-	sequences := pipeline.GetSequences("hazards")
+	sequences, err := pipeline.GetSequences("hazards")
+	if err != nil {
+		panic(err)
+	}
 	for _, seq := range sequences {
 		prevCenter := seq.Members[0].Detection.Polygon.Bounds().Center()
 		for _, member := range seq.Members[1:] {
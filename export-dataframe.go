@@ -0,0 +1,69 @@
+package main
+
+import (
+	"./pipeline"
+
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+const timeFormat = "2006-01-02 15:04:05"
+
+func main() {
+	format := flag.String("format", "", "geojson or mot for sequences, geojson or csv for matrices (default geojson)")
+	start := flag.String("start", "", "only export data at or after this time, e.g. \"2019-01-01 00:00:00\"")
+	end := flag.String("end", "", "only export data before this time")
+	bounds := flag.String("bounds", "", "only export data in this orthoimage region, e.g. \"0 0 2048 2048\"")
+	coords := flag.String("coords", "pixel", "GeoJSON coordinates: pixel, wgs84 or utm")
+	georefName := flag.String("georef", pipeline.DefaultGeorefName, "georef of the orthoimage for wgs84 and utm coordinates")
+	videoID := flag.Int("video", 0, "only export sequence members from this video, required for mot when sequences span videos")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] DATAFRAME\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	filter := pipeline.ExportFilter{VideoID: *videoID}
+	var err error
+	if *start != "" {
+		filter.Start, err = time.Parse(timeFormat, *start)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if *end != "" {
+		filter.End, err = time.Parse(timeFormat, *end)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if *bounds != "" {
+		rect, err := pipeline.ParseRectangle(*bounds)
+		if err != nil {
+			panic(err)
+		}
+		filter.Bounds = &rect
+	}
+	proj := pipeline.PixelProjection
+	if *coords != "pixel" {
+		georef, err := pipeline.GetGeoref(*georefName)
+		if err != nil {
+			panic(err)
+		} else if georef == nil {
+			panic(fmt.Errorf("no georef %s, see set-georef.go", *georefName))
+		}
+		proj, err = georef.Projection(*coords)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if err := pipeline.ExportDataframe(os.Stdout, flag.Arg(0), *format, filter, proj); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"./pipeline"
+
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Print the operator graph with rerun times, row counts and last execution
+// durations, in DOT format (the default) or as JSON.
+func main() {
+	format := "dot"
+	if len(os.Args) >= 2 {
+		format = os.Args[1]
+	}
+	if format != "dot" && format != "json" {
+		fmt.Println("usage: go run export-graph.go [dot|json]")
+		os.Exit(1)
+	}
+	ops, err := pipeline.GetPipeline()
+	if err != nil {
+		panic(err)
+	}
+	graph, err := ops.GetGraph()
+	if err != nil {
+		panic(err)
+	}
+	if format == "dot" {
+		fmt.Println(graph.DOT())
+	} else {
+		bytes, err := json.MarshalIndent(graph, "", "\t")
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(string(bytes))
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"./detector"
+	"./pipeline"
+
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+func main() {
+	class := flag.String("class", "", "class of the boxes in MOT files")
+	fps := flag.Float64("fps", 5, "frame rate of frames that are not in video_frames yet")
+	seqDataframe := flag.String("tracks", "", "also add tracks as sequences in this dataframe")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] coco|mot|csv FILE VIDEO_ID DATAFRAME\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 4 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	format := flag.Arg(0)
+	videoID, err := strconv.Atoi(flag.Arg(2))
+	if err != nil {
+		panic(err)
+	}
+	dataframe := flag.Arg(3)
+
+	file, err := os.Open(flag.Arg(1))
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+	var frames detector.FrameBoxes
+	if format == "coco" {
+		frames, err = detector.ReadCOCO(file)
+	} else if format == "mot" {
+		frames, err = detector.ReadMOT(file, *class)
+	} else if format == "csv" {
+		frames, err = detector.ReadCSV(file)
+	} else {
+		err = fmt.Errorf("unknown format %s", format)
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	ctx := pipeline.NewDatabaseContext(pipeline.NewDatabase())
+	if err := detector.Import(ctx, videoID, dataframe, *seqDataframe, *fps, frames); err != nil {
+		panic(err)
+	}
+}
@@ -183,7 +183,8 @@ for row in db.fetchall():
 		poly_points = []
 		for part in poly_parts:
 			point_parts = part.split(',')
-			poly_points.append((int(point_parts[0])/2, int(point_parts[1])/2))
+			# detections from some detector backends were saved with float coordinates
+			poly_points.append((int(float(point_parts[0]))/2, int(float(point_parts[1]))/2))
 		detections.append((int(row[0]), len(poly_points)))
 		points.extend(poly_points)
 
@@ -3,19 +3,31 @@ package main
 import (
 	"./pipeline"
 
+	"fmt"
 	"time"
 )
 
 func main() {
 	db := pipeline.NewDatabase()
 
-	db.Exec("UPDATE video_frames SET enabled = 0")
+	if _, err := db.Exec("UPDATE video_frames SET enabled = 0"); err != nil {
+		panic(err)
+	}
 	for batch := 0; batch <= 60; batch++ {
-		db.Exec("UPDATE video_frames SET enabled = 1 WHERE batch <= ?", batch)
+		if _, err := db.Exec("UPDATE video_frames SET enabled = 1 WHERE batch <= ?", batch); err != nil {
+			panic(err)
+		}
 		var t time.Time
-		db.QueryRow("SELECT MIN(time) FROM video_frames WHERE batch = ?", batch).Scan(&t)
-		db.Exec("UPDATE dataframes SET rerun_time = 0 WHERE name IN ('cars', 'pedestrians')")
+		if err := db.QueryRow("SELECT MIN(time) FROM video_frames WHERE batch = ?", batch).Scan(&t); err != nil {
+			panic(err)
+		}
+		if _, err := db.Exec("UPDATE dataframes SET rerun_time = 0 WHERE name IN ('cars', 'pedestrians')"); err != nil {
+			panic(err)
+		}
 		//db.Exec("UPDATE dataframes SET rerun_time = ? WHERE name IN ('cars', 'pedestrians')", t)
-		pipeline.GetPipeline().RunAll()
+		if err := pipeline.RunPipeline(); err != nil {
+			// failed operators are retried on the next batch
+			fmt.Println(err)
+		}
 	}
 }
@@ -0,0 +1,108 @@
+package pipeline
+
+import (
+	"time"
+)
+
+/*
+Operator state checkpoints.
+
+Operators that keep in-memory state across frames (e.g. the best frame of each
+cell in to_matrix) can set SaveState and LoadState. While executing, the state
+before a frame is saved in the operator_checkpoints table every
+CheckpointInterval, in the same transaction as the operator's output. When the
+operator is rerun, it starts from the latest checkpoint at or before its rerun
+time and restores the state after InitFunc, so that replaying the frames up to
+the rerun time reconstructs exactly the state of the previous run.
+
+If there is no such checkpoint, e.g. on the first run, the operator falls back
+to replaying its LookBehind. So checkpoints can be deleted at any time.
+
+Only the latest checkpoint at or before the rerun time is needed to resume, so
+older checkpoints are deleted whenever a checkpoint is saved. Checkpoints
+after the rerun time are kept, since a parent may lower the rerun time to
+somewhere in the frames that were just executed.
+*/
+
+// Frame time between checkpoints of an operator's state.
+var CheckpointInterval time.Duration = 5*time.Second
+
+type stateCheckpoint struct {
+	Time time.Time
+	State string
+}
+
+// Returns our latest checkpoint at or before t, or nil if there is none.
+func (op *Operator) getCheckpointBefore(t time.Time) (*stateCheckpoint, error) {
+	rows, err := op.Context.dbFor(op.Name).Query("SELECT time, state FROM operator_checkpoints WHERE dataframe = ? AND time <= ? ORDER BY time DESC LIMIT 1", op.Name, t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	var c stateCheckpoint
+	if err := rows.Scan(&c.Time, &c.State); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Delete checkpoints at or after t, since the state at those times may change
+// when we rerun from t.
+func (op *Operator) deleteCheckpointsAfter(t time.Time) error {
+	_, err := op.Context.dbFor(op.Name).Exec("DELETE FROM operator_checkpoints WHERE dataframe = ? AND time >= ?", op.Name, t)
+	return err
+}
+
+// Save our state before the frame at time t.
+func (op *Operator) saveCheckpoint(t time.Time) error {
+	state, err := op.SaveState()
+	if err != nil {
+		return err
+	}
+	if _, err := op.Context.dbFor(op.Name).Exec("INSERT INTO operator_checkpoints (dataframe, time, state) VALUES (?, ?, ?)", op.Name, t, state); err != nil {
+		return err
+	}
+	if err := op.pruneCheckpoints(); err != nil {
+		return err
+	}
+	op.checkpointTime = t
+	return nil
+}
+
+// Delete checkpoints older than the latest one at or before our rerun time,
+// since we would never resume from them.
+func (op *Operator) pruneCheckpoints() error {
+	db := op.Context.dbFor(op.Name)
+	rows, err := db.Query("SELECT time FROM operator_checkpoints WHERE dataframe = ? AND time <= ? ORDER BY time DESC LIMIT 1", op.Name, op.RerunTime)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return rows.Err()
+	}
+	var t time.Time
+	if err := rows.Scan(&t); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = db.Exec("DELETE FROM operator_checkpoints WHERE dataframe = ? AND time < ?", op.Name, t)
+	return err
+}
+
+// Returns the time of the first frame to execute when rerunning from the
+// specified time, and the checkpoint to restore at that frame if any.
+func (op *Operator) getStartTime(rerunTime time.Time) (time.Time, *stateCheckpoint, error) {
+	if op.LoadState != nil {
+		c, err := op.getCheckpointBefore(rerunTime)
+		if err != nil {
+			return time.Time{}, nil, err
+		} else if c != nil {
+			return c.Time, c, nil
+		}
+	}
+	return rerunTime.Add(-op.LookBehind), nil, nil
+}
@@ -0,0 +1,66 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPruneCheckpoints(t *testing.T) {
+	ctx, _ := newTestContext(t)
+	op := &Operator{
+		Name: "counts",
+		Context: ctx,
+		SaveState: func() (string, error) {
+			return "state", nil
+		},
+	}
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(i int) time.Time {
+		return base.Add(time.Duration(i) * CheckpointInterval)
+	}
+	checkTimes := func(expected ...int) {
+		t.Helper()
+		rows, err := ctx.DB.Query("SELECT time FROM operator_checkpoints WHERE dataframe = ? ORDER BY time", op.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var times []time.Time
+		for rows.Next() {
+			var ct time.Time
+			if err := rows.Scan(&ct); err != nil {
+				t.Fatal(err)
+			}
+			times = append(times, ct)
+		}
+		if len(times) != len(expected) {
+			t.Fatalf("expected %d checkpoints, got %v", len(expected), times)
+		}
+		for i, idx := range expected {
+			if !times[i].Equal(at(idx)) {
+				t.Fatalf("expected checkpoint %d at %v, got %v", i, at(idx), times[i])
+			}
+		}
+	}
+
+	// checkpoints after the rerun time are kept, along with the latest one
+	// at or before it
+	op.RerunTime = at(5)
+	for i := 0; i < 10; i++ {
+		if err := op.saveCheckpoint(at(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkTimes(5, 6, 7, 8, 9)
+
+	op.RerunTime = at(7).Add(time.Second)
+	if err := op.saveCheckpoint(at(10)); err != nil {
+		t.Fatal(err)
+	}
+	checkTimes(7, 8, 9, 10)
+	if c, err := op.getCheckpointBefore(op.RerunTime); err != nil {
+		t.Fatal(err)
+	} else if c == nil || !c.Time.Equal(at(7)) {
+		t.Fatalf("expected to resume from %v, got %v", at(7), c)
+	}
+}
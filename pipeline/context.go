@@ -0,0 +1,69 @@
+package pipeline
+
+/*
+Pipeline contexts.
+
+A Context owns the Database and Driver that a pipeline reads and writes. The
+Database holds the dataframes table and other pipeline metadata, and the
+Driver stores the dataframes, e.g. in the same database or in memory.
+The pipeline from a context's GetPipeline, its operators and their loaders
+all use that context, so one process can run several independent pipelines
+side by side, e.g. one InMemoryDriver per setting in a parameter sweep of the
+simulator.
+
+The package-level functions like GetPipeline and AddMatrixData use
+DefaultContext, which is the database selected by SKYQUERY_DB (see
+dialect.go).
+*/
+
+type Context struct {
+	DB *Database
+	Driver Driver
+
+	// Name of the georef (and GSD setting) of the orthoimage, see georef.go.
+	GeorefName string
+
+	// Ground sample distance of the orthoimage in meters per pixel, or 0 if
+	// unknown, see units.go. Set by GetPipeline from GeorefName.
+	GSD float64
+}
+
+func NewContext(db *Database, driver Driver) *Context {
+	return &Context{
+		DB: db,
+		Driver: driver,
+		GeorefName: DefaultGeorefName,
+	}
+}
+
+// Returns a context that stores dataframes in the database, with the driver
+// for the database's backend.
+func NewDatabaseContext(db *Database) *Context {
+	return NewContext(db, newDatabaseDriver(db))
+}
+
+var DefaultContext = NewDatabaseContext(NewDatabase())
+
+// Returns the number of operators that can execute at once, given the
+// requested number of workers. Operators can run in parallel when the driver
+// stores dataframes in memory, since they only write small rows to the
+// database outside of transactions.
+func (ctx *Context) maxWorkers(workers int) int {
+	if _, ok := ctx.Driver.(databaseDriver); !ok {
+		return workers
+	}
+	if max := ctx.DB.Dialect().MaxWriters(); max > 0 && workers > max {
+		return max
+	}
+	return workers
+}
+
+// Returns the database for updating the dataframe's row in the dataframes
+// table, so that rerun times are committed in the same transaction as the
+// dataframe's data.
+func (ctx *Context) dbFor(dataframe string) *Database {
+	if d, ok := ctx.Driver.(databaseDriver); ok {
+		return d.dbFor(dataframe)
+	}
+	return ctx.DB
+}
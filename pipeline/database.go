@@ -1,13 +1,11 @@
 package pipeline
 
 import (
-	_ "github.com/go-sql-driver/mysql"
-
 	"database/sql"
+	"fmt"
+	"os"
 )
 
-var db *Database = NewDatabase()
-
 func checkErr(err error) {
 	if err != nil {
 		panic(err)
@@ -16,85 +14,181 @@ func checkErr(err error) {
 
 type Database struct {
 	db *sql.DB
+	dialect Dialect
+
+	// Set if this Database runs queries in a transaction, see Begin.
+	tx *sql.Tx
 }
 
 var dbName string = "skyquery"
 
 func SetDBName(name string) {
 	dbName = name
-	db = NewDatabase()
+	SetDatabase(NewDatabase())
 }
 
+// Use the database for DefaultContext, e.g. a SQLite database in a test.
+func SetDatabase(db *Database) {
+	DefaultContext.DB = db
+	if _, ok := DefaultContext.Driver.(databaseDriver); ok {
+		DefaultContext.Driver = newDatabaseDriver(db)
+	}
+}
+
+// Open the database selected by SKYQUERY_DB (see dialect.go), which defaults
+// to the dbName database on the local MySQL server.
+// Opening the database only fails if the connection string is invalid, so we
+// panic in that case. Connection errors are returned by the query methods.
 func NewDatabase() *Database {
-	db := new(Database)
-	//sqlDB, err := sql.Open("mysql", "skyql:skyql@/skyql?charset=utf8&parseTime=true")
-	//sqlDB, err := sql.Open("mysql", "skyql:skyql@/sd_const_skyql?charset=utf8&parseTime=true")
-	//sqlDB, err := sql.Open("mysql", "skyql:skyql@/sd_ttl_skyql?charset=utf8&parseTime=true")
-	//sqlDB, err := sql.Open("mysql", "skyql:skyql@/sd_pattern_skyql?charset=utf8&parseTime=true")
-	//sqlDB, err := sql.Open("mysql", "skyql:skyql@/sd_pattern2_skyql?charset=utf8&parseTime=true")
-	//sqlDB, err := sql.Open("mysql", "skyql:skyql@/sd_pattern3_skyql?charset=utf8&parseTime=true")
-	sqlDB, err := sql.Open("mysql", "skyquery:skyquery@/" + dbName + "?charset=utf8&parseTime=true")
+	config := os.Getenv("SKYQUERY_DB")
+	if config == "" {
+		config = "mysql"
+	}
+	dialect, source, err := parseDatabaseConfig(config)
+	checkErr(err)
+	if source == "" {
+		source = dialect.DefaultSource(dbName)
+	}
+	db, err := OpenDatabase(dialect, source)
 	checkErr(err)
-	db.db = sqlDB
 	return db
 }
 
-func (db *Database) Query(q string, args ...interface{}) Rows {
-	rows, err := db.db.Query(q, args...)
-	checkErr(err)
-	return Rows{rows}
+func OpenDatabase(dialect Dialect, source string) (*Database, error) {
+	sqlDB, err := dialect.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	return &Database{db: sqlDB, dialect: dialect}, nil
+}
+
+func (db *Database) Dialect() Dialect {
+	return db.dialect
+}
+
+func (db *Database) args(args []interface{}) []interface{} {
+	converted := make([]interface{}, len(args))
+	for i, arg := range args {
+		converted[i] = db.dialect.Arg(arg)
+	}
+	return converted
+}
+
+type querier interface {
+	Query(q string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(q string, args ...interface{}) *sql.Row
+	Exec(q string, args ...interface{}) (sql.Result, error)
+}
+
+func (db *Database) querier() querier {
+	if db.tx != nil {
+		return db.tx
+	}
+	return db.db
+}
+
+// Start a transaction. Queries on the returned Database run in the
+// transaction until Commit or Rollback is called on it.
+func (db *Database) Begin() (*Database, error) {
+	if db.tx != nil {
+		return nil, fmt.Errorf("already in a transaction")
+	}
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Database{db: db.db, dialect: db.dialect, tx: tx}, nil
+}
+
+func (db *Database) Commit() error {
+	if db.tx == nil {
+		return fmt.Errorf("not in a transaction")
+	}
+	return db.tx.Commit()
+}
+
+func (db *Database) Rollback() error {
+	if db.tx == nil {
+		return fmt.Errorf("not in a transaction")
+	}
+	return db.tx.Rollback()
+}
+
+func (db *Database) Query(q string, args ...interface{}) (Rows, error) {
+	rows, err := db.querier().Query(db.dialect.Rebind(q), db.args(args)...)
+	if err != nil {
+		return Rows{}, err
+	}
+	return Rows{rows}, nil
 }
 
 func (db *Database) QueryRow(q string, args ...interface{}) Row {
-	row := db.db.QueryRow(q, args...)
+	row := db.querier().QueryRow(db.dialect.Rebind(q), db.args(args)...)
 	return Row{row}
 }
 
-func (db *Database) Exec(q string, args ...interface{}) Result {
-	result, err := db.db.Exec(q, args...)
-	checkErr(err)
-	return Result{result}
+func (db *Database) Exec(q string, args ...interface{}) (Result, error) {
+	result, err := db.querier().Exec(db.dialect.Rebind(q), db.args(args)...)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{result}, nil
+}
+
+// Execute an INSERT and return the ID of the new row.
+func (db *Database) ExecInsert(q string, args ...interface{}) (int, error) {
+	if db.dialect.ReturningID() {
+		var id int
+		err := db.QueryRow(q + " RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+	result, err := db.Exec(q, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
 }
 
 type Rows struct {
 	rows *sql.Rows
 }
 
-func (r Rows) Close() {
-	err := r.rows.Close()
-	checkErr(err)
+func (r Rows) Close() error {
+	return r.rows.Close()
 }
 
 func (r Rows) Next() bool {
 	return r.rows.Next()
 }
 
-func (r Rows) Scan(dest ...interface{}) {
-	err := r.rows.Scan(dest...)
-	checkErr(err)
+func (r Rows) Scan(dest ...interface{}) error {
+	return r.rows.Scan(dest...)
+}
+
+// Returns the error, if any, that stopped Next.
+func (r Rows) Err() error {
+	return r.rows.Err()
 }
 
 type Row struct {
 	row *sql.Row
 }
 
-func (r Row) Scan(dest ...interface{}) {
-	err := r.row.Scan(dest...)
-	checkErr(err)
+// Returns sql.ErrNoRows if the query did not match any row.
+func (r Row) Scan(dest ...interface{}) error {
+	return r.row.Scan(dest...)
 }
 
 type Result struct {
 	result sql.Result
 }
 
-func (r Result) LastInsertId() int {
+func (r Result) LastInsertId() (int, error) {
 	id, err := r.result.LastInsertId()
-	checkErr(err)
-	return int(id)
+	return int(id), err
 }
 
-func (r Result) RowsAffected() int {
+func (r Result) RowsAffected() (int, error) {
 	count, err := r.result.RowsAffected()
-	checkErr(err)
-	return int(count)
+	return int(count), err
 }
@@ -0,0 +1,156 @@
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Default rerun time in the dataframes table, so new dataframes are computed
+// from the beginning.
+var DefaultRerunTime = time.Date(1971, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// A row in the dataframes table.
+type DataframeSpec struct {
+	Name string
+	OpType string
+	Parents []string
+	Operands map[string]string
+	RerunTime time.Time
+}
+
+func ParseOperands(s string) map[string]string {
+	if s == "" {
+		return nil
+	}
+	operands := make(map[string]string)
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) == 1 {
+			operands[kv[0]] = ""
+		} else {
+			operands[kv[0]] = kv[1]
+		}
+	}
+	return operands
+}
+
+// Encode operands in the format expected by ParseOperands.
+// Keys are sorted so that the encoding is deterministic.
+func EncodeOperands(operands map[string]string) string {
+	var keys []string
+	for k := range operands {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		parts = append(parts, k + "=" + operands[k])
+	}
+	return strings.Join(parts, ",")
+}
+
+func (spec DataframeSpec) String() string {
+	return fmt.Sprintf("%s = %s(%s) [%s]", spec.Name, spec.OpType, strings.Join(spec.Parents, ","), EncodeOperands(spec.Operands))
+}
+
+// Returns whether the two specs define the same operator, ignoring rerun time.
+func (spec DataframeSpec) Equals(other DataframeSpec) bool {
+	return spec.Name == other.Name &&
+		spec.OpType == other.OpType &&
+		strings.Join(spec.Parents, ",") == strings.Join(other.Parents, ",") &&
+		EncodeOperands(spec.Operands) == EncodeOperands(other.Operands)
+}
+
+func GetDataframeSpecs() (map[string]DataframeSpec, error) {
+	return DefaultContext.GetDataframeSpecs()
+}
+
+func (ctx *Context) GetDataframeSpecs() (map[string]DataframeSpec, error) {
+	rows, err := ctx.DB.Query("SELECT name, parents, op_type, operands, rerun_time FROM dataframes")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	specs := make(map[string]DataframeSpec)
+	for rows.Next() {
+		var spec DataframeSpec
+		var parents, operands string
+		if err := rows.Scan(&spec.Name, &parents, &spec.OpType, &operands, &spec.RerunTime); err != nil {
+			return nil, err
+		}
+		if parents != "" {
+			spec.Parents = strings.Split(parents, ",")
+		}
+		spec.Operands = ParseOperands(operands)
+		specs[spec.Name] = spec
+	}
+	return specs, rows.Err()
+}
+
+type DataframeChange struct {
+	// One of "add" or "update".
+	Action string
+	Old *DataframeSpec
+	New DataframeSpec
+}
+
+func (change DataframeChange) String() string {
+	if change.Action == "update" {
+		return fmt.Sprintf("update %s\n  - %v\n  + %v", change.New.Name, *change.Old, change.New)
+	}
+	return fmt.Sprintf("%s %v", change.Action, change.New)
+}
+
+// Compare compiled specs against the existing dataframes.
+// Existing dataframes not mentioned in the specs are left alone.
+func DiffDataframes(existing map[string]DataframeSpec, specs []DataframeSpec) []DataframeChange {
+	var changes []DataframeChange
+	for _, spec := range specs {
+		old, ok := existing[spec.Name]
+		if !ok {
+			changes = append(changes, DataframeChange{
+				Action: "add",
+				New: spec,
+			})
+		} else if !old.Equals(spec) {
+			changes = append(changes, DataframeChange{
+				Action: "update",
+				Old: &old,
+				New: spec,
+			})
+		}
+	}
+	return changes
+}
+
+// Write changes to the dataframes table.
+// Updated dataframes are reset to DefaultRerunTime so they are recomputed.
+func ApplyDataframeChanges(changes []DataframeChange) error {
+	return DefaultContext.ApplyDataframeChanges(changes)
+}
+
+func (ctx *Context) ApplyDataframeChanges(changes []DataframeChange) error {
+	for _, change := range changes {
+		spec := change.New
+		parents := strings.Join(spec.Parents, ",")
+		operands := EncodeOperands(spec.Operands)
+		var err error
+		if change.Action == "add" {
+			_, err = ctx.DB.Exec(
+				"INSERT INTO dataframes (name, op_type, operands, parents) VALUES (?, ?, ?, ?)",
+				spec.Name, spec.OpType, operands, parents,
+			)
+		} else if change.Action == "update" {
+			_, err = ctx.DB.Exec(
+				"UPDATE dataframes SET op_type = ?, operands = ?, parents = ?, rerun_time = ? WHERE name = ?",
+				spec.OpType, operands, parents, DefaultRerunTime, spec.Name,
+			)
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s: %v", change.Action, spec.Name, err)
+		}
+	}
+	return nil
+}
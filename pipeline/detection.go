@@ -11,37 +11,57 @@ type Detection struct {
 	Time time.Time
 	Polygon common.Polygon
 	FrameID int
+
+	// Class label from the detector, or empty if it has no classes.
+	Class string
+	// Detector confidence in [0, 1], 1 if the detector has no scores.
+	Confidence float64
+
+	// Polygon in the video frame. This is only loaded by GetDetection.
+	FramePolygon common.Polygon
+}
+
+// Functions on the dataframes of DefaultContext. Detections are only returned
+// once they have a polygon in the orthoimage.
+
+func GetFrameDetections(dataframe string, frame *Frame) ([]*Detection, error) {
+	return DefaultContext.Driver.GetFrameDetections(dataframe, frame.ID)
+}
+
+func GetDetectionsAfter(dataframe string, t time.Time) ([]*Detection, error) {
+	return DefaultContext.Driver.GetDetectionsAfter(dataframe, t)
+}
+
+func GetDetections(dataframe string) ([]*Detection, error) {
+	return DefaultContext.Driver.GetDetections(dataframe)
+}
+
+// Returns nil if there is no detection with the ID.
+func GetDetection(id int) (*Detection, error) {
+	return DefaultContext.Driver.GetDetection(id)
+}
+
+// Adds a detection to the dataframe and sets its ID.
+func AddDetection(dataframe string, detection *Detection) error {
+	return DefaultContext.Driver.AddDetection(dataframe, detection)
 }
 
-func rowsToDetections(rows Rows) []*Detection {
-	var detections []*Detection
-	for rows.Next() {
-		var detection Detection
-		var polygonStr string
-		rows.Scan(&detection.ID, &detection.Time, &polygonStr, &detection.FrameID)
-		detection.Polygon = ParsePolygon(polygonStr)
-		detections = append(detections, &detection)
-	}
-	return detections
+// Counts all detections in the dataframe, including ones without a polygon.
+func CountDetections(dataframe string) (int, error) {
+	return DefaultContext.Driver.CountDetections(dataframe)
 }
 
-func GetFrameDetections(dataframe string, frame *Frame) []*Detection {
-	rows := db.Query("SELECT id, time, polygon, frame_id FROM detections WHERE dataframe = ? AND frame_id = ? AND polygon IS NOT NULL AND polygon != ''", dataframe, frame.ID)
-	return rowsToDetections(rows)
+// Counts detections in the dataframe at or after t.
+func CountDetectionsAfter(dataframe string, t time.Time) (int, error) {
+	return DefaultContext.Driver.CountDetectionsAfter(dataframe, t)
 }
 
-func GetDetectionsAfter(dataframe string, t time.Time) []*Detection {
-	rows := db.Query(
-		"SELECT id, time, polygon, frame_id FROM detections WHERE dataframe = ? AND polygon IS NOT NULL AND polygon != '' AND time >= ? AND (SELECT enabled FROM video_frames WHERE video_frames.id = frame_id) = 1 ORDER BY time",
-		dataframe, t,
-	)
-	return rowsToDetections(rows)
+// Adds a copy of the detection to the dataframe.
+func CopyDetection(dataframe string, detection *Detection) (*Detection, error) {
+	return DefaultContext.Driver.CopyDetection(dataframe, detection)
 }
 
-func GetDetections(dataframe string) []*Detection {
-	rows := db.Query(
-		"SELECT id, time, polygon, frame_id FROM detections WHERE dataframe = ? AND polygon IS NOT NULL AND polygon != '' ORDER BY time",
-		dataframe,
-	)
-	return rowsToDetections(rows)
+// Deletes detections in the dataframe at or after t.
+func UndoDetections(dataframe string, t time.Time) error {
+	return DefaultContext.Driver.UndoDetections(dataframe, t)
 }
@@ -7,30 +7,96 @@ import (
 )
 
 type Driver interface {
-	DeleteMatrixAfter(dataframe string, t time.Time)
-	LoadMatrixBefore(dataframe string, t time.Time) map[[2]int]*MatrixData
-	AddMatrixData(dataframe string, md *MatrixData)
-	GetLatestMatrixData(dataframe string, i int, j int) *MatrixData
-	GetMatrixDataBefore(dataframe string, i int, j int, t time.Time) *MatrixData
-	GetMatrixDatasAfter(dataframe string, t time.Time) []*MatrixData
-	GetPredecessorFrames(t time.Time, count int) []*Frame
-	AddFrame(idx int, t time.Time, bounds common.Polygon) *Frame
-	GetFramesStartingFrom(t time.Time) []*Frame
-	AddSequence(dataframe string, t time.Time) *Sequence
-	TerminateSequence(seq *Sequence, t time.Time)
-	AddSequenceMember(seq *Sequence, detection *Detection, t time.Time)
-	GetSequenceMetadata(seq *Sequence) []string
-	AddSequenceMetadata(seq *Sequence, metadata string, t time.Time)
-	GetUnterminatedSequences(dataframe string) map[int]*Sequence
-	GetSequencesAfter(dataframe string, t time.Time) map[int]*Sequence
-	GetSequences(dataframe string) map[int]*Sequence
-	UndoSequences(dataframe string, t time.Time)
+	DeleteMatrixAfter(dataframe string, t time.Time) error
+	LoadMatrixBefore(dataframe string, t time.Time) (map[[2]int]*MatrixData, error)
+	AddMatrixData(dataframe string, md *MatrixData) error
+	GetLatestMatrixData(dataframe string, i int, j int) (*MatrixData, error)
+	GetMatrixDataBefore(dataframe string, i int, j int, t time.Time) (*MatrixData, error)
+	GetMatrixDatasAfter(dataframe string, t time.Time) ([]*MatrixData, error)
+	GetPredecessorFrames(t time.Time, count int) ([]*Frame, error)
+	// The video ID is 0 for frames that are not from a video, e.g. in the
+	// simulator, and bounds may be nil until the frame is georeferenced.
+	AddFrame(videoID int, idx int, t time.Time, bounds common.Polygon) (*Frame, error)
+	GetFramesStartingFrom(t time.Time) ([]*Frame, error)
+	GetFrame(id int) (*Frame, error)
+	// Returns the frames of the video ordered by index, including disabled
+	// frames.
+	GetVideoFrames(videoID int) ([]*Frame, error)
+
+	// Detections, see detection.go.
+	GetFrameDetections(dataframe string, frameID int) ([]*Detection, error)
+	GetDetectionsAfter(dataframe string, t time.Time) ([]*Detection, error)
+	GetDetections(dataframe string) ([]*Detection, error)
+	GetDetection(id int) (*Detection, error)
+	AddDetection(dataframe string, detection *Detection) error
+	CopyDetection(dataframe string, detection *Detection) (*Detection, error)
+	UndoDetections(dataframe string, t time.Time) error
+	CountDetections(dataframe string) (int, error)
+	CountDetectionsAfter(dataframe string, t time.Time) (int, error)
+	AddSequence(dataframe string, t time.Time) (*Sequence, error)
+	TerminateSequence(seq *Sequence, t time.Time) error
+	AddSequenceMember(seq *Sequence, detection *Detection, t time.Time) error
+	GetSequenceMetadata(seq *Sequence) ([]string, error)
+	AddSequenceMetadata(seq *Sequence, metadata string, t time.Time) error
+	GetUnterminatedSequences(dataframe string) (map[int]*Sequence, error)
+	GetSequencesAfter(dataframe string, t time.Time) (map[int]*Sequence, error)
+	GetSequences(dataframe string) (map[int]*Sequence, error)
+	UndoSequences(dataframe string, t time.Time) error
+	CountSequences(dataframe string) (int, error)
+
+	// Count the rows that DeleteMatrixAfter and UndoSequences would discard.
+	CountMatrixAfter(dataframe string, t time.Time) (int, error)
+	CountUndoSequences(dataframe string, t time.Time) (UndoCounts, error)
+
+	// Start a transaction on the dataframe. Until Commit or Rollback, changes
+	// to the dataframe are not visible to other connections, and Rollback
+	// restores the dataframe to its state at Begin.
+	Begin(dataframe string) error
+	Commit(dataframe string) error
+	Rollback(dataframe string) error
 }
 
-var driver = NewDatabaseDriver(db)
-//var driver = NewInMemoryDriver()
-//var driver2 = NewDatabaseDriver(db)
+// Rows affected by UndoSequences.
+type UndoCounts struct {
+	Sequences int `json:"sequences"`
+	Members int `json:"members"`
+	Metadata int `json:"metadata"`
+	// Sequences that started before the time but are no longer terminated.
+	Reopened int `json:"reopened"`
+}
+
+// Drivers that can find data in a region of the orthoimage without loading
+// the whole dataframe, see PostGISDriver. Times are in [start, end).
+type SpatialDriver interface {
+	Driver
+
+	// Returns detections that intersect the polygon, ordered by time.
+	GetDetectionsInPolygon(dataframe string, poly common.Polygon, start time.Time, end time.Time) ([]*Detection, error)
+
+	// Returns enabled frames whose bounds intersect the polygon, ordered by
+	// time.
+	GetFramesInPolygon(poly common.Polygon, start time.Time, end time.Time) ([]*Frame, error)
 
+	// Returns sequences with a member that intersects the polygon, with all
+	// of their members.
+	GetSequencesInPolygon(dataframe string, poly common.Polygon, start time.Time, end time.Time) (map[int]*Sequence, error)
+}
+
+// Returns the driver of DefaultContext.
 func GetDriver() Driver {
-	return driver
+	return DefaultContext.Driver
+}
+
+// Drivers that store dataframes in a Database.
+type databaseDriver interface {
+	Driver
+	dbFor(dataframe string) *Database
+}
+
+// Returns the driver for the database's backend.
+func newDatabaseDriver(db *Database) Driver {
+	if _, ok := db.Dialect().(PostgresDialect); ok {
+		return NewPostGISDriver(db)
+	}
+	return NewDatabaseDriver(db)
 }
@@ -3,130 +3,431 @@ package pipeline
 import (
 	"github.com/mitroadmaps/gomapinfer/common"
 
+	"fmt"
+	"sync"
 	"time"
 )
 
 type DatabaseDriver struct {
 	db *Database
+
+	// Open transactions by dataframe.
+	mu sync.Mutex
+	txs map[string]*Database
 }
 
 func NewDatabaseDriver(db *Database) Driver {
-	return DatabaseDriver{db}
+	return &DatabaseDriver{
+		db: db,
+		txs: make(map[string]*Database),
+	}
+}
+
+// Returns the SQL expression that reads a polygon column as text, see
+// Dialect.PolygonColumn.
+func (d *DatabaseDriver) polygonColumn(column string) string {
+	return d.db.Dialect().PolygonColumn(column)
+}
+
+// Returns the database to use for queries on the dataframe, which is the
+// dataframe's transaction if it has one.
+func (d *DatabaseDriver) dbFor(dataframe string) *Database {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if tx := d.txs[dataframe]; tx != nil {
+		return tx
+	}
+	return d.db
+}
+
+func (d *DatabaseDriver) CountMatrixAfter(dataframe string, t time.Time) (int, error) {
+	var count int
+	err := d.dbFor(dataframe).QueryRow("SELECT COUNT(*) FROM matrix_data WHERE dataframe = ? AND time >= ?", dataframe, t).Scan(&count)
+	return count, err
+}
+
+func (d *DatabaseDriver) CountSequences(dataframe string) (int, error) {
+	var count int
+	err := d.dbFor(dataframe).QueryRow("SELECT COUNT(*) FROM sequences WHERE dataframe = ?", dataframe).Scan(&count)
+	return count, err
+}
+
+func (d *DatabaseDriver) CountUndoSequences(dataframe string, t time.Time) (UndoCounts, error) {
+	var counts UndoCounts
+	queries := []struct{
+		dst *int
+		q string
+		args []interface{}
+	}{
+		{&counts.Sequences, "SELECT COUNT(*) FROM sequences WHERE dataframe = ? AND time >= ?", []interface{}{dataframe, t}},
+		{
+			&counts.Members,
+			"SELECT COUNT(*) FROM sequence_members AS sm " +
+			"INNER JOIN sequences AS seqs ON seqs.id = sm.sequence_id " +
+			"WHERE seqs.dataframe = ? AND sm.time >= ?",
+			[]interface{}{dataframe, t},
+		},
+		{
+			&counts.Metadata,
+			"SELECT COUNT(*) FROM sequence_metadata AS smeta " +
+			"INNER JOIN sequences AS seqs ON seqs.id = smeta.sequence_id " +
+			"WHERE seqs.dataframe = ? AND smeta.time >= ?",
+			[]interface{}{dataframe, t},
+		},
+		{&counts.Reopened, "SELECT COUNT(*) FROM sequences WHERE dataframe = ? AND time < ? AND terminated_at >= ?", []interface{}{dataframe, t, t}},
+	}
+	for _, query := range queries {
+		if err := d.dbFor(dataframe).QueryRow(query.q, query.args...).Scan(query.dst); err != nil {
+			return counts, err
+		}
+	}
+	return counts, nil
+}
+
+func (d *DatabaseDriver) Begin(dataframe string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.txs[dataframe] != nil {
+		return fmt.Errorf("dataframe %s is already in a transaction", dataframe)
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	d.txs[dataframe] = tx
+	return nil
+}
+
+// Remove the dataframe's transaction from the driver so that we can end it.
+func (d *DatabaseDriver) popTx(dataframe string) (*Database, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	tx := d.txs[dataframe]
+	if tx == nil {
+		return nil, fmt.Errorf("dataframe %s is not in a transaction", dataframe)
+	}
+	delete(d.txs, dataframe)
+	return tx, nil
+}
+
+func (d *DatabaseDriver) Commit(dataframe string) error {
+	tx, err := d.popTx(dataframe)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (d DatabaseDriver) DeleteMatrixAfter(dataframe string, t time.Time) {
-	d.db.Exec(
+func (d *DatabaseDriver) Rollback(dataframe string) error {
+	tx, err := d.popTx(dataframe)
+	if err != nil {
+		return err
+	}
+	return tx.Rollback()
+}
+
+func (d *DatabaseDriver) DeleteMatrixAfter(dataframe string, t time.Time) error {
+	_, err := d.dbFor(dataframe).Exec(
 		"DELETE FROM matrix_data WHERE dataframe = ? AND time >= ?",
 		dataframe, t,
 	)
+	return err
 }
 
 // Load the latest matrix data for every cell that has had at least one observation.
-func (d DatabaseDriver) LoadMatrixBefore(dataframe string, t time.Time) map[[2]int]*MatrixData {
-	m := make(map[[2]int]*MatrixData)
-	rows := d.db.Query("SELECT DISTINCT i, j FROM matrix_data WHERE dataframe = ? AND time < ?", dataframe, t)
+func (d *DatabaseDriver) LoadMatrixBefore(dataframe string, t time.Time) (map[[2]int]*MatrixData, error) {
+	rows, err := d.dbFor(dataframe).Query("SELECT DISTINCT i, j FROM matrix_data WHERE dataframe = ? AND time < ?", dataframe, t)
+	if err != nil {
+		return nil, err
+	}
+	var cells [][2]int
 	for rows.Next() {
-		var md MatrixData
-		rows.Scan(&md.I, &md.J)
-		row := d.db.QueryRow(
+		var cell [2]int
+		if err := rows.Scan(&cell[0], &cell[1]); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		cells = append(cells, cell)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	m := make(map[[2]int]*MatrixData)
+	for _, cell := range cells {
+		md := MatrixData{I: cell[0], J: cell[1]}
+		row := d.dbFor(dataframe).QueryRow(
 			"SELECT val, metadata FROM matrix_data WHERE dataframe = ? AND i = ? AND j = ? AND time < ? ORDER BY time DESC LIMIT 1",
 			dataframe, md.I, md.J, t,
 		)
-		row.Scan(&md.Val, &md.Metadata)
-		m[[2]int{md.I, md.J}] = &md
+		if err := row.Scan(&md.Val, &md.Metadata); err != nil {
+			return nil, err
+		}
+		m[cell] = &md
 	}
-	return m
+	return m, nil
 }
 
-func (d DatabaseDriver) AddMatrixData(dataframe string, md *MatrixData) {
-	result := d.db.Exec(
+func (d *DatabaseDriver) AddMatrixData(dataframe string, md *MatrixData) error {
+	id, err := d.dbFor(dataframe).ExecInsert(
 		"INSERT INTO matrix_data (dataframe, i, j, val, metadata, time) VALUES (?, ?, ?, ?, ?, ?)",
 		dataframe, md.I, md.J, md.Val, md.Metadata, md.Time,
 	)
-	md.ID = result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	md.ID = id
+	return nil
 }
 
-func rowsToMatrixDatas(rows Rows) []*MatrixData {
+func rowsToMatrixDatas(rows Rows) ([]*MatrixData, error) {
+	defer rows.Close()
 	var datas []*MatrixData
 	for rows.Next() {
 		var data MatrixData
-		rows.Scan(&data.ID, &data.Time, &data.I, &data.J, &data.Val, &data.Metadata)
+		if err := rows.Scan(&data.ID, &data.Time, &data.I, &data.J, &data.Val, &data.Metadata); err != nil {
+			return nil, err
+		}
 		datas = append(datas, &data)
 	}
-	return datas
+	return datas, rows.Err()
+}
+
+// Returns the first matrix data matched by the query, or nil if there are none.
+func (d *DatabaseDriver) queryMatrixData(dataframe string, q string, args ...interface{}) (*MatrixData, error) {
+	rows, err := d.dbFor(dataframe).Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	datas, err := rowsToMatrixDatas(rows)
+	if err != nil || len(datas) == 0 {
+		return nil, err
+	}
+	return datas[0], nil
 }
 
-func (d DatabaseDriver) GetLatestMatrixData(dataframe string, i int, j int) *MatrixData {
-	rows := d.db.Query(
+func (d *DatabaseDriver) GetLatestMatrixData(dataframe string, i int, j int) (*MatrixData, error) {
+	return d.queryMatrixData(
+		dataframe,
 		"SELECT id, time, i, j, val, metadata FROM matrix_data WHERE dataframe = ? AND i = ? AND j = ? ORDER BY time DESC LIMIT 1",
 		dataframe, i, j,
 	)
-	datas := rowsToMatrixDatas(rows)
-	if len(datas) == 1 {
-		return datas[0]
-	} else {
-		return nil
-	}
 }
 
-func (d DatabaseDriver) GetMatrixDataBefore(dataframe string, i int, j int, t time.Time) *MatrixData {
-	rows := d.db.Query(
+func (d *DatabaseDriver) GetMatrixDataBefore(dataframe string, i int, j int, t time.Time) (*MatrixData, error) {
+	return d.queryMatrixData(
+		dataframe,
 		"SELECT id, time, i, j, val, metadata FROM matrix_data WHERE dataframe = ? AND i = ? AND j = ? AND time <= ? ORDER BY time DESC LIMIT 1",
 		dataframe, i, j, t,
 	)
-	datas := rowsToMatrixDatas(rows)
-	if len(datas) == 1 {
-		return datas[0]
-	} else {
-		return nil
-	}
 }
 
-func (d DatabaseDriver) GetMatrixDatasAfter(dataframe string, t time.Time) []*MatrixData {
-	rows := d.db.Query("SELECT id, time, i, j, val, metadata FROM matrix_data WHERE dataframe = ? AND time >= ? ORDER BY id", dataframe, t)
+func (d *DatabaseDriver) GetMatrixDatasAfter(dataframe string, t time.Time) ([]*MatrixData, error) {
+	rows, err := d.dbFor(dataframe).Query("SELECT id, time, i, j, val, metadata FROM matrix_data WHERE dataframe = ? AND time >= ? ORDER BY id", dataframe, t)
+	if err != nil {
+		return nil, err
+	}
 	return rowsToMatrixDatas(rows)
 }
 
-func rowsToFrames(rows Rows) []*Frame {
+func rowsToFrames(rows Rows) ([]*Frame, error) {
+	defer rows.Close()
 	var frames []*Frame
 	for rows.Next() {
 		var frame Frame
 		var polyStr *string
-		rows.Scan(&frame.ID, &frame.VideoID, &frame.Idx, &frame.Time, &polyStr)
+		if err := rows.Scan(&frame.ID, &frame.VideoID, &frame.Idx, &frame.Time, &polyStr); err != nil {
+			return nil, err
+		}
 		if polyStr != nil {
 			frame.Bounds = ParsePolygon(*polyStr)
 		}
 		frames = append(frames, &frame)
 	}
-	return frames
+	return frames, rows.Err()
 }
 
-func (d DatabaseDriver) GetPredecessorFrames(t time.Time, count int) []*Frame {
-	rows := d.db.Query("SELECT id, IFNULL(video_id, 0), idx, time, bounds FROM video_frames WHERE time < ? AND enabled = 1 ORDER BY time DESC LIMIT ?", t, count)
-	frames := rowsToFrames(rows)
+func (d *DatabaseDriver) GetPredecessorFrames(t time.Time, count int) ([]*Frame, error) {
+	rows, err := d.db.Query("SELECT id, COALESCE(video_id, 0), idx, time, " + d.polygonColumn("bounds") + " FROM video_frames WHERE time < ? AND enabled = 1 ORDER BY time DESC LIMIT ?", t, count)
+	if err != nil {
+		return nil, err
+	}
+	frames, err := rowsToFrames(rows)
+	if err != nil {
+		return nil, err
+	}
 	orderedFrames := make([]*Frame, len(frames))
 	for i := range orderedFrames {
 		orderedFrames[i] = frames[len(frames) - i - 1]
 	}
-	return orderedFrames
+	return orderedFrames, nil
+}
+
+// Returns the value of an optional polygon column, which is NULL until it is
+// set, e.g. match-sift.py only georeferences detections with NULL polygons.
+func polygonValue(poly common.Polygon) interface{} {
+	if len(poly) == 0 {
+		return nil
+	}
+	return EncodePolygon(poly)
 }
 
-func (d DatabaseDriver) AddFrame(idx int, t time.Time, bounds common.Polygon) *Frame {
-	result := d.db.Exec("INSERT INTO video_frames (idx, time, bounds) VALUES (?, ?, ?)", idx, t, EncodePolygon(bounds))
+func (d *DatabaseDriver) AddFrame(videoID int, idx int, t time.Time, bounds common.Polygon) (*Frame, error) {
+	var videoIDArg interface{}
+	if videoID != 0 {
+		videoIDArg = videoID
+	}
+	id, err := d.db.ExecInsert(
+		"INSERT INTO video_frames (video_id, idx, time, bounds) VALUES (?, ?, ?, " + d.db.Dialect().PolygonArg() + ")",
+		videoIDArg, idx, t, polygonValue(bounds),
+	)
+	if err != nil {
+		return nil, err
+	}
 	return &Frame{
-		ID: result.LastInsertId(),
+		ID: id,
+		VideoID: videoID,
 		Idx: idx,
 		Time: t,
 		Bounds: bounds,
+	}, nil
+}
+
+func (d *DatabaseDriver) GetFramesStartingFrom(t time.Time) ([]*Frame, error) {
+	rows, err := d.db.Query("SELECT id, COALESCE(video_id, 0), idx, time, " + d.polygonColumn("bounds") + " FROM video_frames WHERE time >= ? AND enabled = 1 ORDER BY time", t)
+	if err != nil {
+		return nil, err
 	}
+	return rowsToFrames(rows)
 }
 
-func (d DatabaseDriver) GetFramesStartingFrom(t time.Time) []*Frame {
-	rows := d.db.Query("SELECT id, IFNULL(video_id, 0), idx, time, bounds FROM video_frames WHERE time >= ? AND enabled = 1 ORDER BY time", t)
+func (d *DatabaseDriver) GetVideoFrames(videoID int) ([]*Frame, error) {
+	rows, err := d.db.Query("SELECT id, COALESCE(video_id, 0), idx, time, " + d.polygonColumn("bounds") + " FROM video_frames WHERE video_id = ? ORDER BY idx", videoID)
+	if err != nil {
+		return nil, err
+	}
 	return rowsToFrames(rows)
 }
 
-func rowsToSequences(rows Rows) map[int]*Sequence {
+func (d *DatabaseDriver) GetFrame(id int) (*Frame, error) {
+	rows, err := d.db.Query("SELECT id, COALESCE(video_id, 0), idx, time, " + d.polygonColumn("bounds") + " FROM video_frames WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	frames, err := rowsToFrames(rows)
+	if err != nil || len(frames) != 1 {
+		return nil, err
+	}
+	return frames[0], nil
+}
+
+func (d *DatabaseDriver) queryDetections(q string, args ...interface{}) ([]*Detection, error) {
+	rows, err := d.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var detections []*Detection
+	for rows.Next() {
+		var detection Detection
+		var polygonStr string
+		if err := rows.Scan(&detection.ID, &detection.Time, &polygonStr, &detection.FrameID, &detection.Class, &detection.Confidence); err != nil {
+			return nil, err
+		}
+		detection.Polygon = ParsePolygon(polygonStr)
+		detections = append(detections, &detection)
+	}
+	return detections, rows.Err()
+}
+
+func (d *DatabaseDriver) GetFrameDetections(dataframe string, frameID int) ([]*Detection, error) {
+	return d.queryDetections(
+		"SELECT id, time, " + d.polygonColumn("polygon") + ", frame_id, class, confidence FROM detections " +
+		"WHERE dataframe = ? AND frame_id = ? AND COALESCE(" + d.polygonColumn("polygon") + ", '') != ''",
+		dataframe, frameID,
+	)
+}
+
+func (d *DatabaseDriver) GetDetectionsAfter(dataframe string, t time.Time) ([]*Detection, error) {
+	return d.queryDetections(
+		"SELECT id, time, " + d.polygonColumn("polygon") + ", frame_id, class, confidence FROM detections " +
+		"WHERE dataframe = ? AND COALESCE(" + d.polygonColumn("polygon") + ", '') != '' AND time >= ? AND (SELECT enabled FROM video_frames WHERE video_frames.id = frame_id) = 1 ORDER BY time",
+		dataframe, t,
+	)
+}
+
+func (d *DatabaseDriver) GetDetections(dataframe string) ([]*Detection, error) {
+	return d.queryDetections(
+		"SELECT id, time, " + d.polygonColumn("polygon") + ", frame_id, class, confidence FROM detections " +
+		"WHERE dataframe = ? AND COALESCE(" + d.polygonColumn("polygon") + ", '') != '' ORDER BY time",
+		dataframe,
+	)
+}
+
+func (d *DatabaseDriver) GetDetection(id int) (*Detection, error) {
+	rows, err := d.db.Query("SELECT id, time, COALESCE(" + d.polygonColumn("polygon") + ", ''), frame_id, class, confidence, frame_polygon FROM detections WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	var detection Detection
+	var polygonStr, framePolygonStr string
+	if err := rows.Scan(&detection.ID, &detection.Time, &polygonStr, &detection.FrameID, &detection.Class, &detection.Confidence, &framePolygonStr); err != nil {
+		return nil, err
+	}
+	detection.Polygon = ParsePolygon(polygonStr)
+	detection.FramePolygon = ParsePolygon(framePolygonStr)
+	return &detection, nil
+}
+
+func (d *DatabaseDriver) AddDetection(dataframe string, detection *Detection) error {
+	id, err := d.dbFor(dataframe).ExecInsert(
+		"INSERT INTO detections (dataframe, time, frame_polygon, polygon, frame_id, class, confidence) VALUES (?, ?, ?, " + d.db.Dialect().PolygonArg() + ", ?, ?, ?)",
+		dataframe, detection.Time, EncodePolygon(detection.FramePolygon), polygonValue(detection.Polygon), detection.FrameID, detection.Class, detection.Confidence,
+	)
+	if err != nil {
+		return err
+	}
+	detection.ID = id
+	return nil
+}
+
+func (d *DatabaseDriver) CopyDetection(dataframe string, detection *Detection) (*Detection, error) {
+	id, err := d.dbFor(dataframe).ExecInsert(
+		"INSERT INTO detections (dataframe, time, frame_polygon, polygon, frame_id, class, confidence) " +
+		"SELECT ?, time, frame_polygon, polygon, frame_id, class, confidence FROM detections WHERE id = ?",
+		dataframe, detection.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	copied := *detection
+	copied.ID = id
+	return &copied, nil
+}
+
+func (d *DatabaseDriver) UndoDetections(dataframe string, t time.Time) error {
+	_, err := d.dbFor(dataframe).Exec("DELETE FROM detections WHERE dataframe = ? AND time >= ?", dataframe, t)
+	return err
+}
+
+func (d *DatabaseDriver) CountDetections(dataframe string) (int, error) {
+	var count int
+	err := d.dbFor(dataframe).QueryRow("SELECT COUNT(*) FROM detections WHERE dataframe = ?", dataframe).Scan(&count)
+	return count, err
+}
+
+func (d *DatabaseDriver) CountDetectionsAfter(dataframe string, t time.Time) (int, error) {
+	var count int
+	err := d.dbFor(dataframe).QueryRow("SELECT COUNT(*) FROM detections WHERE dataframe = ? AND time >= ?", dataframe, t).Scan(&count)
+	return count, err
+}
+
+func rowsToSequences(rows Rows) (map[int]*Sequence, error) {
+	defer rows.Close()
 	sequences := make(map[int]*Sequence)
 	for rows.Next() {
 		var sequenceID int
@@ -137,7 +438,9 @@ func rowsToSequences(rows Rows) map[int]*Sequence {
 		var seqTime time.Time
 		var seqTerminated *time.Time
 
-		rows.Scan(&member.ID, &sequenceID, &detection.ID, &detection.Time, &polygonStr, &detection.FrameID, &seqTime, &seqTerminated)
+		if err := rows.Scan(&member.ID, &sequenceID, &detection.ID, &detection.Time, &polygonStr, &detection.FrameID, &detection.Class, &detection.Confidence, &seqTime, &seqTerminated); err != nil {
+			return nil, err
+		}
 		detection.Polygon = ParsePolygon(polygonStr)
 
 		member.Detection = &detection
@@ -152,100 +455,145 @@ func rowsToSequences(rows Rows) map[int]*Sequence {
 			sequences[sequenceID].Members = append(sequences[sequenceID].Members, &member)
 		}
 	}
-	return sequences
+	return sequences, rows.Err()
 }
 
-func (d DatabaseDriver) AddSequence(dataframe string, t time.Time) *Sequence {
-	result := db.Exec("INSERT INTO sequences (dataframe, time) VALUES (?, ?)", dataframe, t)
+func (d *DatabaseDriver) querySequences(dataframe string, q string, args ...interface{}) (map[int]*Sequence, error) {
+	rows, err := d.dbFor(dataframe).Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	sequences, err := rowsToSequences(rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, seq := range sequences {
+		seq.dataframe = dataframe
+		seq.driver = d
+	}
+	return sequences, nil
+}
+
+func (d *DatabaseDriver) AddSequence(dataframe string, t time.Time) (*Sequence, error) {
+	id, err := d.dbFor(dataframe).ExecInsert("INSERT INTO sequences (dataframe, time) VALUES (?, ?)", dataframe, t)
+	if err != nil {
+		return nil, err
+	}
 	return &Sequence{
-		ID: result.LastInsertId(),
+		ID: id,
 		Time: t,
-	}
+		dataframe: dataframe,
+		driver: d,
+	}, nil
 }
 
-func (d DatabaseDriver) TerminateSequence(seq *Sequence, t time.Time) {
+func (d *DatabaseDriver) TerminateSequence(seq *Sequence, t time.Time) error {
+	if _, err := d.dbFor(seq.dataframe).Exec("UPDATE sequences SET terminated_at = ? WHERE id = ?", t, seq.ID); err != nil {
+		return err
+	}
 	seq.Terminated = new(time.Time)
 	*seq.Terminated = t
-	db.Exec("UPDATE sequences SET terminated_at = ? WHERE id = ?", t, seq.ID)
+	return nil
 }
 
-func (d DatabaseDriver) AddSequenceMember(seq *Sequence, detection *Detection, t time.Time) {
-	member := &SequenceMember{
-		Detection: detection,
-	}
-	seq.Members = append(seq.Members, member)
-	result := db.Exec(
+func (d *DatabaseDriver) AddSequenceMember(seq *Sequence, detection *Detection, t time.Time) error {
+	id, err := d.dbFor(seq.dataframe).ExecInsert(
 		"INSERT INTO sequence_members (sequence_id, detection_id, time) VALUES (?, ?, ?)",
-		seq.ID, member.Detection.ID, t,
+		seq.ID, detection.ID, t,
 	)
-	member.ID = result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	seq.Members = append(seq.Members, &SequenceMember{
+		ID: id,
+		Detection: detection,
+	})
+	return nil
 }
 
-func (d DatabaseDriver) GetSequenceMetadata(seq *Sequence) []string {
-	rows := db.Query("SELECT metadata FROM sequence_metadata WHERE sequence_id = ? ORDER BY time", seq.ID)
+func (d *DatabaseDriver) GetSequenceMetadata(seq *Sequence) ([]string, error) {
+	rows, err := d.dbFor(seq.dataframe).Query("SELECT metadata FROM sequence_metadata WHERE sequence_id = ? ORDER BY time", seq.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 	var metadata []string
 	for rows.Next() {
 		var s string
-		rows.Scan(&s)
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
 		metadata = append(metadata, s)
 	}
-	return metadata
+	return metadata, rows.Err()
 }
 
-func (d DatabaseDriver) AddSequenceMetadata(seq *Sequence, metadata string, t time.Time) {
-	seq.GetMetadata()
+func (d *DatabaseDriver) AddSequenceMetadata(seq *Sequence, metadata string, t time.Time) error {
+	if _, err := seq.GetMetadata(); err != nil {
+		return err
+	}
+	if _, err := d.dbFor(seq.dataframe).Exec("INSERT INTO sequence_metadata (sequence_id, metadata, time) VALUES (?, ?, ?)", seq.ID, metadata, t); err != nil {
+		return err
+	}
 	*seq.metadata = append(*seq.metadata, metadata)
-	db.Exec("INSERT INTO sequence_metadata (sequence_id, metadata, time) VALUES (?, ?, ?)", seq.ID, metadata, t)
+	return nil
 }
 
-func (d DatabaseDriver) GetUnterminatedSequences(dataframe string) map[int]*Sequence {
-	rows := db.Query(
-		"SELECT sm.id, sm.sequence_id, sm.detection_id, d.time, d.polygon, d.frame_id, seqs.time, seqs.terminated_at " +
+func (d *DatabaseDriver) GetUnterminatedSequences(dataframe string) (map[int]*Sequence, error) {
+	return d.querySequences(
+		dataframe,
+		"SELECT sm.id, sm.sequence_id, sm.detection_id, d.time, " + d.polygonColumn("d.polygon") + ", d.frame_id, d.class, d.confidence, seqs.time, seqs.terminated_at " +
 		"FROM sequences AS seqs, sequence_members AS sm, detections AS d " +
 		"WHERE seqs.id = sm.sequence_id AND d.id = sm.detection_id AND " +
 		"seqs.dataframe = ? AND seqs.terminated_at IS NULL " +
 		"ORDER BY sm.id",
 		dataframe,
 	)
-	return rowsToSequences(rows)
 }
 
-func (d DatabaseDriver) GetSequencesAfter(dataframe string, t time.Time) map[int]*Sequence {
-	rows := db.Query(
-		"SELECT sm.id, sm.sequence_id, sm.detection_id, d.time, d.polygon, d.frame_id, seqs.time, seqs.terminated_at " +
+func (d *DatabaseDriver) GetSequencesAfter(dataframe string, t time.Time) (map[int]*Sequence, error) {
+	return d.querySequences(
+		dataframe,
+		"SELECT sm.id, sm.sequence_id, sm.detection_id, d.time, " + d.polygonColumn("d.polygon") + ", d.frame_id, d.class, d.confidence, seqs.time, seqs.terminated_at " +
 		"FROM sequences AS seqs, sequence_members AS sm, detections AS d " +
 		"WHERE seqs.id = sm.sequence_id AND d.id = sm.detection_id AND " +
 		"sm.sequence_id IN (SELECT id FROM sequences AS subseqs WHERE subseqs.dataframe = ? AND (subseqs.terminated_at IS NULL OR subseqs.terminated_at >= ?))" +
 		"ORDER BY d.time",
 		dataframe, t,
 	)
-	return rowsToSequences(rows)
 }
 
-func (d DatabaseDriver) GetSequences(dataframe string) map[int]*Sequence {
-	rows := db.Query(
-		"SELECT sm.id, sm.sequence_id, sm.detection_id, d.time, d.polygon, d.frame_id, seqs.time, seqs.terminated_at " +
+func (d *DatabaseDriver) GetSequences(dataframe string) (map[int]*Sequence, error) {
+	return d.querySequences(
+		dataframe,
+		"SELECT sm.id, sm.sequence_id, sm.detection_id, d.time, " + d.polygonColumn("d.polygon") + ", d.frame_id, d.class, d.confidence, seqs.time, seqs.terminated_at " +
 		"FROM sequences AS seqs, sequence_members AS sm, detections AS d " +
 		"WHERE seqs.id = sm.sequence_id AND d.id = sm.detection_id AND seqs.dataframe = ? " +
 		"ORDER BY sm.id",
 		dataframe,
 	)
-	return rowsToSequences(rows)
 }
 
-func (d DatabaseDriver) UndoSequences(dataframe string, t time.Time) {
-	db.Exec(
-		"DELETE sm FROM sequence_members AS sm " +
-		"INNER JOIN sequences AS seqs ON seqs.id = sm.sequence_id " +
-		"WHERE seqs.dataframe = ? AND sm.time >= ?",
-		dataframe, t,
-	)
-	db.Exec(
-		"DELETE smeta FROM sequence_metadata AS smeta " +
-		"INNER JOIN sequences AS seqs ON seqs.id = smeta.sequence_id " +
-		"WHERE seqs.dataframe = ? AND smeta.time >= ?",
-		dataframe, t,
-	)
-	db.Exec("DELETE FROM sequences WHERE dataframe = ? AND time >= ?", dataframe, t)
-	db.Exec("UPDATE sequences SET terminated_at = NULL WHERE dataframe = ? AND terminated_at >= ?", dataframe, t)
+func (d *DatabaseDriver) UndoSequences(dataframe string, t time.Time) error {
+	dialect := d.db.Dialect()
+	queries := []string{
+		dialect.DeleteJoin(
+			"sequence_members", "sm",
+			"sequences AS seqs ON seqs.id = sm.sequence_id",
+			"seqs.dataframe = ? AND sm.time >= ?",
+		),
+		dialect.DeleteJoin(
+			"sequence_metadata", "smeta",
+			"sequences AS seqs ON seqs.id = smeta.sequence_id",
+			"seqs.dataframe = ? AND smeta.time >= ?",
+		),
+		"DELETE FROM sequences WHERE dataframe = ? AND time >= ?",
+		"UPDATE sequences SET terminated_at = NULL WHERE dataframe = ? AND terminated_at >= ?",
+	}
+	for _, q := range queries {
+		if _, err := d.dbFor(dataframe).Exec(q, dataframe, t); err != nil {
+			return err
+		}
+	}
+	return nil
 }
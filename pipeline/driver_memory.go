@@ -3,6 +3,9 @@ package pipeline
 import (
 	"github.com/mitroadmaps/gomapinfer/common"
 
+	"fmt"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -15,17 +18,98 @@ type InMemoryDF struct {
 	MatrixData map[int]*MatrixData
 	Sequences map[int]*Sequence
 	Metadata map[int][]inMemoryMetadata
+	Detections map[int]*Detection
 	Counter int
 }
 
 type InMemoryDriver struct {
+	// Guards DFs and Frames, since the pipeline may execute operators concurrently.
+	mu sync.Mutex
+
 	DFs map[string]*InMemoryDF
 
 	// ordered by time
 	Frames []*Frame
+
+	// Detection IDs are unique over all dataframes, like in the database,
+	// since sequence members refer to detections of other dataframes.
+	DetectionCounter int
+
+	// Undo logs of dataframes that are in a transaction.
+	undoLogs map[string]*inMemoryUndoLog
+}
+
+// Prior values of the rows of a dataframe that changed since Begin, recorded
+// the first time each row changes. A nil value means the row was added.
+type inMemoryUndoLog struct {
+	matrixData map[int]*MatrixData
+	detections map[int]*Detection
+	// Sequence objects are modified in place, so we save their values.
+	sequences map[int]*inMemorySavedSequence
+	metadata map[int]*[]inMemoryMetadata
+	counter int
+}
+
+type inMemorySavedSequence struct {
+	seq *Sequence
+	value Sequence
 }
 
-func NewInMemoryDriver() Driver {
+// The save functions do nothing on a nil undo log, i.e., outside a transaction.
+
+func (u *inMemoryUndoLog) saveMatrixData(df *InMemoryDF, id int) {
+	if u == nil {
+		return
+	}
+	if _, ok := u.matrixData[id]; !ok {
+		u.matrixData[id] = df.MatrixData[id]
+	}
+}
+
+func (u *inMemoryUndoLog) saveDetection(df *InMemoryDF, id int) {
+	if u == nil {
+		return
+	}
+	if _, ok := u.detections[id]; !ok {
+		u.detections[id] = df.Detections[id]
+	}
+}
+
+func (u *inMemoryUndoLog) saveSequence(df *InMemoryDF, id int) {
+	if u == nil {
+		return
+	}
+	if _, ok := u.sequences[id]; ok {
+		return
+	}
+	seq := df.Sequences[id]
+	if seq == nil {
+		u.sequences[id] = nil
+		return
+	}
+	// members may be truncated and appended to in place, so copy them
+	value := *seq
+	value.Members = append([]*SequenceMember(nil), seq.Members...)
+	u.sequences[id] = &inMemorySavedSequence{seq, value}
+}
+
+func (u *inMemoryUndoLog) saveMetadata(df *InMemoryDF, id int) {
+	if u == nil {
+		return
+	}
+	if _, ok := u.metadata[id]; ok {
+		return
+	}
+	metadata, ok := df.Metadata[id]
+	if !ok {
+		u.metadata[id] = nil
+		return
+	}
+	saved := append([]inMemoryMetadata(nil), metadata...)
+	u.metadata[id] = &saved
+}
+
+func NewInMemoryDriver() *InMemoryDriver {
 	return &InMemoryDriver{
 		DFs: make(map[string]*InMemoryDF),
 	}
@@ -37,31 +121,41 @@ func (d *InMemoryDriver) ensure(dataframe string) *InMemoryDF {
 			MatrixData: make(map[int]*MatrixData),
 			Sequences: make(map[int]*Sequence),
 			Metadata: make(map[int][]inMemoryMetadata),
+			Detections: make(map[int]*Detection),
 		}
 	}
 	return d.DFs[dataframe]
 }
 
-func (d *InMemoryDriver) DeleteMatrixAfter(dataframe string, t time.Time) {
+func (d *InMemoryDriver) DeleteMatrixAfter(dataframe string, t time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	df := d.ensure(dataframe)
 	for _, md := range df.MatrixData {
 		if !md.Time.Before(t) {
+			d.undoLogs[dataframe].saveMatrixData(df, md.ID)
 			delete(df.MatrixData, md.ID)
 		}
 	}
+	return nil
 }
 
 func (d *InMemoryDriver) DeleteMatrixSatisfying(dataframe string, f func(md *MatrixData) bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	df := d.ensure(dataframe)
 	for _, md := range df.MatrixData {
 		if f(md) {
+			d.undoLogs[dataframe].saveMatrixData(df, md.ID)
 			delete(df.MatrixData, md.ID)
 		}
 	}
 }
 
 // Load the latest matrix data for every cell that has had at least one observation.
-func (d *InMemoryDriver) LoadMatrixBefore(dataframe string, t time.Time) map[[2]int]*MatrixData {
+func (d *InMemoryDriver) LoadMatrixBefore(dataframe string, t time.Time) (map[[2]int]*MatrixData, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	df := d.ensure(dataframe)
 	m := make(map[[2]int]*MatrixData)
 	for _, md := range df.MatrixData {
@@ -73,17 +167,23 @@ func (d *InMemoryDriver) LoadMatrixBefore(dataframe string, t time.Time) map[[2]
 			m[cell] = md
 		}
 	}
-	return m
+	return m, nil
 }
 
-func (d *InMemoryDriver) AddMatrixData(dataframe string, md *MatrixData) {
+func (d *InMemoryDriver) AddMatrixData(dataframe string, md *MatrixData) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	df := d.ensure(dataframe)
+	d.undoLogs[dataframe].saveMatrixData(df, df.Counter)
 	df.MatrixData[df.Counter] = md
 	md.ID = df.Counter
 	df.Counter++
+	return nil
 }
 
-func (d *InMemoryDriver) GetLatestMatrixData(dataframe string, i int, j int) *MatrixData {
+func (d *InMemoryDriver) GetLatestMatrixData(dataframe string, i int, j int) (*MatrixData, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	df := d.ensure(dataframe)
 	var bestMD *MatrixData
 	for _, md := range df.MatrixData {
@@ -94,10 +194,12 @@ func (d *InMemoryDriver) GetLatestMatrixData(dataframe string, i int, j int) *Ma
 			bestMD = md
 		}
 	}
-	return bestMD
+	return bestMD, nil
 }
 
-func (d *InMemoryDriver) GetMatrixDataBefore(dataframe string, i int, j int, t time.Time) *MatrixData {
+func (d *InMemoryDriver) GetMatrixDataBefore(dataframe string, i int, j int, t time.Time) (*MatrixData, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	df := d.ensure(dataframe)
 	var bestMD *MatrixData
 	for _, md := range df.MatrixData {
@@ -108,10 +210,12 @@ func (d *InMemoryDriver) GetMatrixDataBefore(dataframe string, i int, j int, t t
 			bestMD = md
 		}
 	}
-	return bestMD
+	return bestMD, nil
 }
 
-func (d *InMemoryDriver) GetMatrixDatasAfter(dataframe string, t time.Time) []*MatrixData {
+func (d *InMemoryDriver) GetMatrixDatasAfter(dataframe string, t time.Time) ([]*MatrixData, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	df := d.ensure(dataframe)
 	var mds []*MatrixData
 	for _, md := range df.MatrixData {
@@ -119,10 +223,12 @@ func (d *InMemoryDriver) GetMatrixDatasAfter(dataframe string, t time.Time) []*M
 			mds = append(mds, md)
 		}
 	}
-	return mds
+	return mds, nil
 }
 
-func (d InMemoryDriver) GetPredecessorFrames(t time.Time, count int) []*Frame {
+func (d *InMemoryDriver) GetPredecessorFrames(t time.Time, count int) ([]*Frame, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	var endIdx int = len(d.Frames)
 	for idx, frame := range d.Frames {
 		if !frame.Time.Before(t) {
@@ -134,13 +240,16 @@ func (d InMemoryDriver) GetPredecessorFrames(t time.Time, count int) []*Frame {
 	if startIdx < 0 {
 		startIdx = 0
 	}
-	return d.Frames[startIdx:endIdx]
+	return append([]*Frame(nil), d.Frames[startIdx:endIdx]...), nil
 	//return driver2.GetPredecessorFrames(t, count)
 }
 
-func (d *InMemoryDriver) AddFrame(idx int, t time.Time, bounds common.Polygon) *Frame {
+func (d *InMemoryDriver) AddFrame(videoID int, idx int, t time.Time, bounds common.Polygon) (*Frame, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	frame := &Frame{
 		ID: len(d.Frames),
+		VideoID: videoID,
 		Idx: idx,
 		Time: t,
 		Bounds: bounds,
@@ -154,11 +263,13 @@ func (d *InMemoryDriver) AddFrame(idx int, t time.Time, bounds common.Polygon) *
 			break
 		}
 	}
-	return frame
+	return frame, nil
 	//return driver2.AddFrame(idx, t, bounds)
 }
 
-func (d *InMemoryDriver) GetFramesStartingFrom(t time.Time) []*Frame {
+func (d *InMemoryDriver) GetFramesStartingFrom(t time.Time) ([]*Frame, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	var startIdx int = len(d.Frames)
 	for i := range d.Frames {
 		if !d.Frames[i].Time.Before(t) {
@@ -166,36 +277,183 @@ func (d *InMemoryDriver) GetFramesStartingFrom(t time.Time) []*Frame {
 			break
 		}
 	}
-	return d.Frames[startIdx:]
+	return append([]*Frame(nil), d.Frames[startIdx:]...), nil
 	//return driver2.GetFramesStartingFrom(t)
 }
 
-func (d *InMemoryDriver) AddSequence(dataframe string, t time.Time) *Sequence {
+func (d *InMemoryDriver) GetFrame(id int) (*Frame, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, frame := range d.Frames {
+		if frame.ID == id {
+			return frame, nil
+		}
+	}
+	return nil, nil
+}
+
+func (d *InMemoryDriver) GetVideoFrames(videoID int) ([]*Frame, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var frames []*Frame
+	for _, frame := range d.Frames {
+		if frame.VideoID == videoID {
+			frames = append(frames, frame)
+		}
+	}
+	sort.Slice(frames, func(i, j int) bool {
+		return frames[i].Idx < frames[j].Idx
+	})
+	return frames, nil
+}
+
+// Returns detections in the dataframe with a polygon that satisfy f, ordered
+// by time.
+func (d *InMemoryDriver) getDetections(dataframe string, f func(detection *Detection) bool) []*Detection {
+	var detections []*Detection
+	for _, detection := range d.ensure(dataframe).Detections {
+		if len(detection.Polygon) > 0 && f(detection) {
+			detections = append(detections, detection)
+		}
+	}
+	sort.Slice(detections, func(i, j int) bool {
+		if detections[i].Time.Equal(detections[j].Time) {
+			return detections[i].ID < detections[j].ID
+		}
+		return detections[i].Time.Before(detections[j].Time)
+	})
+	return detections
+}
+
+func (d *InMemoryDriver) GetFrameDetections(dataframe string, frameID int) ([]*Detection, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.getDetections(dataframe, func(detection *Detection) bool {
+		return detection.FrameID == frameID
+	}), nil
+}
+
+func (d *InMemoryDriver) GetDetectionsAfter(dataframe string, t time.Time) ([]*Detection, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.getDetections(dataframe, func(detection *Detection) bool {
+		return !detection.Time.Before(t)
+	}), nil
+}
+
+func (d *InMemoryDriver) GetDetections(dataframe string) ([]*Detection, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.getDetections(dataframe, func(detection *Detection) bool {
+		return true
+	}), nil
+}
+
+func (d *InMemoryDriver) GetDetection(id int) (*Detection, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, df := range d.DFs {
+		if detection := df.Detections[id]; detection != nil {
+			return detection, nil
+		}
+	}
+	return nil, nil
+}
+
+func (d *InMemoryDriver) AddDetection(dataframe string, detection *Detection) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	detection.ID = d.DetectionCounter
+	d.DetectionCounter++
+	df := d.ensure(dataframe)
+	d.undoLogs[dataframe].saveDetection(df, detection.ID)
+	df.Detections[detection.ID] = detection
+	return nil
+}
+
+func (d *InMemoryDriver) CopyDetection(dataframe string, detection *Detection) (*Detection, error) {
+	copied := *detection
+	if err := d.AddDetection(dataframe, &copied); err != nil {
+		return nil, err
+	}
+	return &copied, nil
+}
+
+func (d *InMemoryDriver) UndoDetections(dataframe string, t time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	df := d.ensure(dataframe)
+	for id, detection := range df.Detections {
+		if !detection.Time.Before(t) {
+			d.undoLogs[dataframe].saveDetection(df, id)
+			delete(df.Detections, id)
+		}
+	}
+	return nil
+}
+
+func (d *InMemoryDriver) CountDetections(dataframe string) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.ensure(dataframe).Detections), nil
+}
+
+func (d *InMemoryDriver) CountDetectionsAfter(dataframe string, t time.Time) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var count int
+	for _, detection := range d.ensure(dataframe).Detections {
+		if !detection.Time.Before(t) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (d *InMemoryDriver) AddSequence(dataframe string, t time.Time) (*Sequence, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	df := d.ensure(dataframe)
 	seq := &Sequence{
 		ID: df.Counter,
 		Time: t,
 		dataframe: dataframe,
+		driver: d,
 	}
+	d.undoLogs[dataframe].saveSequence(df, df.Counter)
 	df.Sequences[df.Counter] = seq
 	df.Counter++
-	return seq
+	return seq, nil
 }
 
-func (d *InMemoryDriver) TerminateSequence(seq *Sequence, t time.Time) {
+func (d *InMemoryDriver) TerminateSequence(seq *Sequence, t time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.undoLogs[seq.dataframe].saveSequence(d.ensure(seq.dataframe), seq.ID)
 	seq.Terminated = new(time.Time)
 	*seq.Terminated = t
+	return nil
 }
 
-func (d *InMemoryDriver) AddSequenceMember(seq *Sequence, detection *Detection, t time.Time) {
+func (d *InMemoryDriver) AddSequenceMember(seq *Sequence, detection *Detection, t time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	member := &SequenceMember{
 		Detection: detection,
 		time: t,
 	}
+	d.undoLogs[seq.dataframe].saveSequence(d.ensure(seq.dataframe), seq.ID)
 	seq.Members = append(seq.Members, member)
+	return nil
+}
+
+func (d *InMemoryDriver) GetSequenceMetadata(seq *Sequence) ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.getSequenceMetadata(seq), nil
 }
 
-func (d *InMemoryDriver) GetSequenceMetadata(seq *Sequence) []string {
+func (d *InMemoryDriver) getSequenceMetadata(seq *Sequence) []string {
 	var s []string
 	df := d.ensure(seq.dataframe)
 	for _, meta := range df.Metadata[seq.ID] {
@@ -204,14 +462,23 @@ func (d *InMemoryDriver) GetSequenceMetadata(seq *Sequence) []string {
 	return s
 }
 
-func (d *InMemoryDriver) AddSequenceMetadata(seq *Sequence, metadata string, t time.Time) {
+func (d *InMemoryDriver) AddSequenceMetadata(seq *Sequence, metadata string, t time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	df := d.ensure(seq.dataframe)
-	seq.GetMetadata()
+	if seq.metadata == nil {
+		existing := d.getSequenceMetadata(seq)
+		seq.metadata = &existing
+	}
 	*seq.metadata = append(*seq.metadata, metadata)
+	d.undoLogs[seq.dataframe].saveMetadata(df, seq.ID)
 	df.Metadata[seq.ID] = append(df.Metadata[seq.ID], inMemoryMetadata{t, metadata})
+	return nil
 }
 
-func (d *InMemoryDriver) GetUnterminatedSequences(dataframe string) map[int]*Sequence {
+func (d *InMemoryDriver) GetUnterminatedSequences(dataframe string) (map[int]*Sequence, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	seqs := make(map[int]*Sequence)
 	df := d.ensure(dataframe)
 	for _, seq := range df.Sequences {
@@ -220,10 +487,12 @@ func (d *InMemoryDriver) GetUnterminatedSequences(dataframe string) map[int]*Seq
 		}
 		seqs[seq.ID] = seq
 	}
-	return seqs
+	return seqs, nil
 }
 
-func (d *InMemoryDriver) GetSequencesAfter(dataframe string, t time.Time) map[int]*Sequence {
+func (d *InMemoryDriver) GetSequencesAfter(dataframe string, t time.Time) (map[int]*Sequence, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	seqs := make(map[int]*Sequence)
 	df := d.ensure(dataframe)
 	for _, seq := range df.Sequences {
@@ -232,28 +501,36 @@ func (d *InMemoryDriver) GetSequencesAfter(dataframe string, t time.Time) map[in
 		}
 		seqs[seq.ID] = seq
 	}
-	return seqs
+	return seqs, nil
 }
 
-func (d *InMemoryDriver) GetSequences(dataframe string) map[int]*Sequence {
+func (d *InMemoryDriver) GetSequences(dataframe string) (map[int]*Sequence, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	seqs := make(map[int]*Sequence)
 	df := d.ensure(dataframe)
 	for _, seq := range df.Sequences {
 		seqs[seq.ID] = seq
 	}
-	return seqs
+	return seqs, nil
 }
 
-func (d *InMemoryDriver) UndoSequences(dataframe string, t time.Time) {
+func (d *InMemoryDriver) UndoSequences(dataframe string, t time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	df := d.ensure(dataframe)
+	undo := d.undoLogs[dataframe]
 	// remove sequences that started after the time, and reset terminated flag
 	for id, seq := range df.Sequences {
 		if !seq.Time.Before(t) {
+			undo.saveSequence(df, id)
 			delete(df.Sequences, id)
 			if df.Metadata[seq.ID] != nil {
+				undo.saveMetadata(df, seq.ID)
 				delete(df.Metadata, seq.ID)
 			}
 		} else if seq.Terminated != nil && !seq.Terminated.Before(t) {
+			undo.saveSequence(df, id)
 			seq.Terminated = nil
 		}
 	}
@@ -261,15 +538,139 @@ func (d *InMemoryDriver) UndoSequences(dataframe string, t time.Time) {
 	for _, seq := range df.Sequences {
 		for i, member := range seq.Members {
 			if !member.time.Before(t) {
+				undo.saveSequence(df, seq.ID)
 				seq.Members = seq.Members[:i]
 				break
 			}
 		}
 		for i, meta := range df.Metadata[seq.ID] {
 			if !meta.t.Before(t) {
+				undo.saveMetadata(df, seq.ID)
 				df.Metadata[seq.ID] = df.Metadata[seq.ID][:i]
 				break
 			}
 		}
 	}
+	return nil
+}
+
+// Begin starts recording an undo log for the dataframe, so that Rollback only
+// needs to restore the rows that changed.
+func (d *InMemoryDriver) Begin(dataframe string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.undoLogs == nil {
+		d.undoLogs = make(map[string]*inMemoryUndoLog)
+	}
+	if d.undoLogs[dataframe] != nil {
+		return fmt.Errorf("dataframe %s is already in a transaction", dataframe)
+	}
+	d.undoLogs[dataframe] = &inMemoryUndoLog{
+		matrixData: make(map[int]*MatrixData),
+		detections: make(map[int]*Detection),
+		sequences: make(map[int]*inMemorySavedSequence),
+		metadata: make(map[int]*[]inMemoryMetadata),
+		counter: d.ensure(dataframe).Counter,
+	}
+	return nil
+}
+
+func (d *InMemoryDriver) Commit(dataframe string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.undoLogs[dataframe] == nil {
+		return fmt.Errorf("dataframe %s is not in a transaction", dataframe)
+	}
+	delete(d.undoLogs, dataframe)
+	return nil
+}
+
+func (d *InMemoryDriver) Rollback(dataframe string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	undo := d.undoLogs[dataframe]
+	if undo == nil {
+		return fmt.Errorf("dataframe %s is not in a transaction", dataframe)
+	}
+	delete(d.undoLogs, dataframe)
+	df := d.ensure(dataframe)
+	for id, md := range undo.matrixData {
+		if md == nil {
+			delete(df.MatrixData, id)
+		} else {
+			df.MatrixData[id] = md
+		}
+	}
+	for id, detection := range undo.detections {
+		if detection == nil {
+			delete(df.Detections, id)
+		} else {
+			df.Detections[id] = detection
+		}
+	}
+	for id, saved := range undo.sequences {
+		if saved == nil {
+			delete(df.Sequences, id)
+			continue
+		}
+		*saved.seq = saved.value
+		// reload cached metadata from df.Metadata
+		saved.seq.metadata = nil
+		df.Sequences[id] = saved.seq
+	}
+	for id, metadata := range undo.metadata {
+		if metadata == nil {
+			delete(df.Metadata, id)
+		} else {
+			df.Metadata[id] = *metadata
+		}
+		if seq := df.Sequences[id]; seq != nil {
+			seq.metadata = nil
+		}
+	}
+	df.Counter = undo.counter
+	return nil
+}
+
+func (d *InMemoryDriver) CountMatrixAfter(dataframe string, t time.Time) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var count int
+	for _, md := range d.ensure(dataframe).MatrixData {
+		if !md.Time.Before(t) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (d *InMemoryDriver) CountSequences(dataframe string) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.ensure(dataframe).Sequences), nil
+}
+
+func (d *InMemoryDriver) CountUndoSequences(dataframe string, t time.Time) (UndoCounts, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var counts UndoCounts
+	df := d.ensure(dataframe)
+	for _, seq := range df.Sequences {
+		if !seq.Time.Before(t) {
+			counts.Sequences++
+		} else if seq.Terminated != nil && !seq.Terminated.Before(t) {
+			counts.Reopened++
+		}
+		for _, member := range seq.Members {
+			if !member.time.Before(t) {
+				counts.Members++
+			}
+		}
+		for _, meta := range df.Metadata[seq.ID] {
+			if !meta.t.Before(t) {
+				counts.Metadata++
+			}
+		}
+	}
+	return counts, nil
 }
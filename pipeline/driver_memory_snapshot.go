@@ -0,0 +1,218 @@
+package pipeline
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+/*
+On-disk snapshots of an InMemoryDriver.
+
+A snapshot holds the frames, and the matrix data, detections, sequences,
+sequence metadata and ID counters of every dataframe, as gzipped JSON. Long
+simulations can save a snapshot periodically and resume from it with
+LoadInMemorySnapshot, and snapshots can be inspected offline, e.g. with
+"zcat sim.json.gz | jq".
+
+Detections are stored once, and sequence members refer to them by ID, so
+sequences that share detections with other dataframes still share them after
+loading.
+*/
+
+// Version of the snapshot format.
+const inMemorySnapshotVersion = 1
+
+type inMemorySnapshotFile struct {
+	Version int `json:"version"`
+	Frames []*Frame `json:"frames"`
+	DetectionCounter int `json:"detection_counter"`
+	// Detections of all dataframes, and detections of sequence members that
+	// are no longer in a dataframe.
+	Detections []*Detection `json:"detections"`
+	DFs map[string]*inMemorySnapshotDF `json:"dataframes"`
+}
+
+type inMemorySnapshotDF struct {
+	Counter int `json:"counter"`
+	MatrixData []*MatrixData `json:"matrix_data"`
+	DetectionIDs []int `json:"detection_ids"`
+	Sequences []inMemorySnapshotSequence `json:"sequences"`
+}
+
+type inMemorySnapshotSequence struct {
+	ID int `json:"id"`
+	Time time.Time `json:"time"`
+	Terminated *time.Time `json:"terminated,omitempty"`
+	Members []inMemorySnapshotMember `json:"members"`
+	Metadata []inMemorySnapshotMetadata `json:"metadata,omitempty"`
+}
+
+type inMemorySnapshotMember struct {
+	DetectionID int `json:"detection_id"`
+	Time time.Time `json:"time"`
+}
+
+type inMemorySnapshotMetadata struct {
+	Time time.Time `json:"time"`
+	Val string `json:"val"`
+}
+
+// Write a snapshot of the driver. Fails if a dataframe is in a transaction,
+// since its changes may still be rolled back.
+func (d *InMemoryDriver) WriteSnapshot(w io.Writer) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for dataframe := range d.undoLogs {
+		return fmt.Errorf("dataframe %s is in a transaction", dataframe)
+	}
+
+	file := inMemorySnapshotFile{
+		Version: inMemorySnapshotVersion,
+		Frames: d.Frames,
+		DetectionCounter: d.DetectionCounter,
+		DFs: make(map[string]*inMemorySnapshotDF),
+	}
+	detections := make(map[int]*Detection)
+	for name, df := range d.DFs {
+		sdf := &inMemorySnapshotDF{Counter: df.Counter}
+		for _, md := range df.MatrixData {
+			sdf.MatrixData = append(sdf.MatrixData, md)
+		}
+		sort.Slice(sdf.MatrixData, func(i, j int) bool {
+			return sdf.MatrixData[i].ID < sdf.MatrixData[j].ID
+		})
+		for id, detection := range df.Detections {
+			sdf.DetectionIDs = append(sdf.DetectionIDs, id)
+			detections[id] = detection
+		}
+		sort.Ints(sdf.DetectionIDs)
+		for _, seq := range df.Sequences {
+			sseq := inMemorySnapshotSequence{
+				ID: seq.ID,
+				Time: seq.Time,
+				Terminated: seq.Terminated,
+			}
+			for _, member := range seq.Members {
+				sseq.Members = append(sseq.Members, inMemorySnapshotMember{member.Detection.ID, member.time})
+				if detections[member.Detection.ID] == nil {
+					detections[member.Detection.ID] = member.Detection
+				}
+			}
+			for _, meta := range df.Metadata[seq.ID] {
+				sseq.Metadata = append(sseq.Metadata, inMemorySnapshotMetadata{meta.t, meta.v})
+			}
+			sdf.Sequences = append(sdf.Sequences, sseq)
+		}
+		sort.Slice(sdf.Sequences, func(i, j int) bool {
+			return sdf.Sequences[i].ID < sdf.Sequences[j].ID
+		})
+		file.DFs[name] = sdf
+	}
+	for _, detection := range detections {
+		file.Detections = append(file.Detections, detection)
+	}
+	sort.Slice(file.Detections, func(i, j int) bool {
+		return file.Detections[i].ID < file.Detections[j].ID
+	})
+
+	zw := gzip.NewWriter(w)
+	if err := json.NewEncoder(zw).Encode(file); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Save a snapshot of the driver to the file. The snapshot is written to a
+// temporary file first, so the previous snapshot is kept if this fails.
+func (d *InMemoryDriver) SaveSnapshot(fname string) error {
+	tmpName := fname + ".tmp"
+	f, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	if err := d.WriteSnapshot(f); err != nil {
+		f.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, fname)
+}
+
+// Read a snapshot written by WriteSnapshot into a new driver.
+func ReadInMemorySnapshot(r io.Reader) (*InMemoryDriver, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	var file inMemorySnapshotFile
+	if err := json.NewDecoder(zr).Decode(&file); err != nil {
+		return nil, err
+	}
+	if file.Version != inMemorySnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", file.Version)
+	}
+
+	d := NewInMemoryDriver()
+	d.Frames = file.Frames
+	d.DetectionCounter = file.DetectionCounter
+	detections := make(map[int]*Detection)
+	for _, detection := range file.Detections {
+		detections[detection.ID] = detection
+	}
+	for name, sdf := range file.DFs {
+		df := d.ensure(name)
+		df.Counter = sdf.Counter
+		for _, md := range sdf.MatrixData {
+			df.MatrixData[md.ID] = md
+		}
+		for _, id := range sdf.DetectionIDs {
+			if detections[id] == nil {
+				return nil, fmt.Errorf("dataframe %s has unknown detection %d", name, id)
+			}
+			df.Detections[id] = detections[id]
+		}
+		for _, sseq := range sdf.Sequences {
+			seq := &Sequence{
+				ID: sseq.ID,
+				Time: sseq.Time,
+				Terminated: sseq.Terminated,
+				dataframe: name,
+				driver: d,
+			}
+			for _, member := range sseq.Members {
+				if detections[member.DetectionID] == nil {
+					return nil, fmt.Errorf("sequence %d in %s has unknown detection %d", seq.ID, name, member.DetectionID)
+				}
+				seq.Members = append(seq.Members, &SequenceMember{
+					Detection: detections[member.DetectionID],
+					time: member.Time,
+				})
+			}
+			for _, meta := range sseq.Metadata {
+				df.Metadata[seq.ID] = append(df.Metadata[seq.ID], inMemoryMetadata{meta.Time, meta.Val})
+			}
+			df.Sequences[seq.ID] = seq
+		}
+	}
+	return d, nil
+}
+
+// Load a snapshot saved by SaveSnapshot.
+func LoadInMemorySnapshot(fname string) (*InMemoryDriver, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadInMemorySnapshot(f)
+}
@@ -0,0 +1,119 @@
+package pipeline
+
+import (
+	"github.com/mitroadmaps/gomapinfer/common"
+
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInMemorySnapshot(t *testing.T) {
+	driver := NewInMemoryDriver()
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	poly := common.Polygon{{0, 0}, {100, 0}, {100, 100}, {0, 100}}
+	var detections []*Detection
+	for i := 0; i < 2; i++ {
+		frame, err := driver.AddFrame(1, i, base.Add(time.Duration(i)*time.Second), poly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		detection := &Detection{
+			Time: frame.Time,
+			Polygon: poly,
+			FrameID: frame.ID,
+			Class: "car",
+			Confidence: 0.5,
+			FramePolygon: poly,
+		}
+		if err := driver.AddDetection("dets", detection); err != nil {
+			t.Fatal(err)
+		}
+		detections = append(detections, detection)
+	}
+
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	addSequence := func(d *InMemoryDriver, dataframe string, start time.Time, members ...*Detection) *Sequence {
+		seq, err := d.AddSequence(dataframe, start)
+		check(err)
+		for _, detection := range members {
+			check(seq.AddMember(detection, detection.Time))
+		}
+		return seq
+	}
+
+	// a terminated track with metadata, and an open track
+	terminated := addSequence(driver, "tracks", base, detections[0], detections[1])
+	check(terminated.Terminate(base.Add(time.Second)))
+	check(driver.AddSequenceMetadata(terminated, "parked", base.Add(time.Second)))
+	open := addSequence(driver, "tracks", base.Add(time.Second), detections[1])
+	// a filter output that shares the first detection with dets and tracks
+	filtered := addSequence(driver, "long", base, detections[0])
+	check(driver.AddMatrixData("counts", &MatrixData{Time: base, I: 1, J: 2, Val: 3, Metadata: "m"}))
+
+	var buf bytes.Buffer
+	if err := driver.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ReadInMemorySnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded.Frames) != 2 {
+		t.Fatalf("expected 2 frames, got %d", len(loaded.Frames))
+	}
+	for i, frame := range loaded.Frames {
+		if frame.ID != driver.Frames[i].ID || frame.VideoID != 1 || frame.Idx != i || !frame.Time.Equal(driver.Frames[i].Time) || !reflect.DeepEqual(frame.Bounds, poly) {
+			t.Errorf("frame %d: expected %v, got %v", i, driver.Frames[i], frame)
+		}
+	}
+
+	shared := loaded.DFs["dets"].Detections[detections[0].ID]
+	if shared == nil || shared.Class != "car" || shared.Confidence != 0.5 || !reflect.DeepEqual(shared.FramePolygon, poly) {
+		t.Fatalf("expected detection %v, got %v", detections[0], shared)
+	}
+	loadedTerminated := loaded.DFs["tracks"].Sequences[terminated.ID]
+	loadedOpen := loaded.DFs["tracks"].Sequences[open.ID]
+	loadedFiltered := loaded.DFs["long"].Sequences[filtered.ID]
+	if loadedTerminated == nil || loadedOpen == nil || loadedFiltered == nil {
+		t.Fatal("missing sequences")
+	}
+	if loadedTerminated.Members[0].Detection != shared || loadedFiltered.Members[0].Detection != shared {
+		t.Fatal("expected sequences to share the detection from dets")
+	} else if loadedTerminated.Members[1].Detection != loadedOpen.Members[0].Detection {
+		t.Fatal("expected tracks to share their second detection")
+	}
+	if loadedTerminated.Terminated == nil || !loadedTerminated.Terminated.Equal(base.Add(time.Second)) {
+		t.Fatalf("expected terminated sequence, got %v", loadedTerminated.Terminated)
+	} else if loadedOpen.Terminated != nil {
+		t.Fatalf("expected open sequence, got %v", loadedOpen.Terminated)
+	}
+	if metadata, _ := loaded.GetSequenceMetadata(loadedTerminated); !reflect.DeepEqual(metadata, []string{"parked"}) {
+		t.Fatalf("expected metadata [parked], got %v", metadata)
+	}
+	md, _ := loaded.GetLatestMatrixData("counts", 1, 2)
+	if md == nil || md.Val != 3 || md.Metadata != "m" || !md.Time.Equal(base) {
+		t.Fatalf("expected matrix data, got %v", md)
+	}
+
+	// IDs continue from the counters
+	detection := &Detection{Time: base.Add(2 * time.Second), Polygon: poly}
+	check(loaded.AddDetection("dets", detection))
+	if detection.ID != detections[1].ID+1 {
+		t.Fatalf("expected detection ID %d, got %d", detections[1].ID+1, detection.ID)
+	}
+	seq := addSequence(loaded, "tracks", detection.Time, detection)
+	if seq.ID != open.ID+1 {
+		t.Fatalf("expected sequence ID %d, got %d", open.ID+1, seq.ID)
+	}
+	if seqs, _ := loaded.GetSequences("tracks"); len(seqs) != 3 {
+		t.Fatalf("expected 3 tracks, got %d", len(seqs))
+	}
+}
@@ -0,0 +1,124 @@
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Summarizes the dataframe so that states before and after a transaction can
+// be compared.
+func dumpInMemoryDF(d *InMemoryDriver, dataframe string) string {
+	df := d.ensure(dataframe)
+	var lines []string
+	for id, md := range df.MatrixData {
+		lines = append(lines, fmt.Sprintf("matrix %d %d %d %d %v", id, md.I, md.J, md.Val, md.Time))
+	}
+	for id, detection := range df.Detections {
+		lines = append(lines, fmt.Sprintf("detection %d %v", id, detection.Time))
+	}
+	for id, seq := range df.Sequences {
+		var members []int
+		for _, member := range seq.Members {
+			members = append(members, member.Detection.ID)
+		}
+		var terminated string
+		if seq.Terminated != nil {
+			terminated = seq.Terminated.String()
+		}
+		metadata, _ := seq.GetMetadata()
+		lines = append(lines, fmt.Sprintf("sequence %d %v %q %v %v", id, seq.Time, terminated, members, metadata))
+	}
+	for id, metadata := range df.Metadata {
+		lines = append(lines, fmt.Sprintf("metadata %d %v", id, metadata))
+	}
+	sort.Strings(lines)
+	return fmt.Sprintf("counter %d %v", df.Counter, lines)
+}
+
+func TestInMemoryRollback(t *testing.T) {
+	driver := NewInMemoryDriver()
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time {
+		return base.Add(time.Duration(seconds) * time.Second)
+	}
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// one terminated and one open sequence, with matrix data and metadata
+	var detections []*Detection
+	for i := 0; i < 4; i++ {
+		detection := &Detection{Time: at(i)}
+		check(driver.AddDetection("df", detection))
+		detections = append(detections, detection)
+	}
+	check(driver.AddMatrixData("df", &MatrixData{Time: at(0), I: 1, J: 2, Val: 1}))
+	check(driver.AddMatrixData("df", &MatrixData{Time: at(2), I: 1, J: 2, Val: 2}))
+	closed, err := driver.AddSequence("df", at(0))
+	check(err)
+	check(driver.AddSequenceMember(closed, detections[0], at(0)))
+	check(driver.AddSequenceMember(closed, detections[1], at(1)))
+	check(driver.AddSequenceMetadata(closed, "x", at(1)))
+	check(driver.TerminateSequence(closed, at(1)))
+	open, err := driver.AddSequence("df", at(1))
+	check(err)
+	check(driver.AddSequenceMember(open, detections[1], at(1)))
+	check(driver.AddSequenceMember(open, detections[2], at(2)))
+	check(driver.AddSequenceMetadata(open, "y", at(2)))
+	before := dumpInMemoryDF(driver, "df")
+
+	// change every kind of row, then roll back
+	mutate := func() {
+		check(driver.UndoSequences("df", at(1)))
+		check(driver.UndoDetections("df", at(3)))
+		check(driver.DeleteMatrixAfter("df", at(2)))
+		check(driver.AddMatrixData("df", &MatrixData{Time: at(3), I: 1, J: 2, Val: 3}))
+		check(driver.AddSequenceMember(closed, detections[3], at(3)))
+		check(driver.AddSequenceMetadata(closed, "z", at(3)))
+		check(driver.TerminateSequence(closed, at(3)))
+		seq, err := driver.AddSequence("df", at(3))
+		check(err)
+		check(driver.AddSequenceMember(seq, detections[3], at(3)))
+		check(driver.AddSequenceMetadata(seq, "w", at(3)))
+		check(driver.AddDetection("df", &Detection{Time: at(4)}))
+	}
+	check(driver.Begin("df"))
+	if err := driver.Begin("df"); err == nil {
+		t.Fatal("expected error for nested Begin")
+	}
+	mutate()
+	after := dumpInMemoryDF(driver, "df")
+	if after == before {
+		t.Fatal("expected the transaction to change the dataframe")
+	}
+	check(driver.Rollback("df"))
+	if got := dumpInMemoryDF(driver, "df"); got != before {
+		t.Fatalf("expected rollback to restore\n%s\ngot\n%s", before, got)
+	}
+	if seqs, _ := driver.GetSequences("df"); seqs[closed.ID] != closed || seqs[open.ID] != open {
+		t.Fatal("expected rollback to keep the sequence objects")
+	}
+
+	// committed changes are kept, and later rollbacks only undo their own changes
+	check(driver.Begin("df"))
+	check(driver.AddMatrixData("df", &MatrixData{Time: at(5), I: 3, J: 4, Val: 4}))
+	check(driver.Commit("df"))
+	committed := dumpInMemoryDF(driver, "df")
+	if committed == before {
+		t.Fatal("expected commit to keep the changes")
+	}
+	check(driver.Begin("df"))
+	mutate()
+	check(driver.Rollback("df"))
+	if got := dumpInMemoryDF(driver, "df"); got != committed {
+		t.Fatalf("expected rollback to restore\n%s\ngot\n%s", committed, got)
+	}
+	if err := driver.Commit("df"); err == nil {
+		t.Fatal("expected error for Commit outside a transaction")
+	}
+}
@@ -0,0 +1,91 @@
+package pipeline
+
+import (
+	"github.com/mitroadmaps/gomapinfer/common"
+
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Driver for PostgreSQL databases with PostGIS (see schema-postgis.sql).
+// Dataframes are stored like in DatabaseDriver, except that detection
+// polygons and frame bounds are geometry, indexed by time and location, so
+// that we can find data in a region without loading it all (see
+// SpatialDriver).
+type PostGISDriver struct {
+	*DatabaseDriver
+}
+
+func NewPostGISDriver(db *Database) Driver {
+	return &PostGISDriver{NewDatabaseDriver(db).(*DatabaseDriver)}
+}
+
+// Returns the polygon as WKT for ST_GeomFromText.
+func polygonWKT(poly common.Polygon) (string, error) {
+	if len(poly) < 3 {
+		return "", fmt.Errorf("polygon must have at least three points")
+	}
+	var pointStrs []string
+	for _, p := range poly {
+		pointStrs = append(pointStrs, fmt.Sprintf("%v %v", p.X, p.Y))
+	}
+	// close the ring
+	pointStrs = append(pointStrs, pointStrs[0])
+	return "POLYGON((" + strings.Join(pointStrs, ", ") + "))", nil
+}
+
+// Returns detections in the dataframe that intersect the polygon, with times
+// in [start, end), ordered by time.
+func (d *PostGISDriver) GetDetectionsInPolygon(dataframe string, poly common.Polygon, start time.Time, end time.Time) ([]*Detection, error) {
+	wkt, err := polygonWKT(poly)
+	if err != nil {
+		return nil, err
+	}
+	return d.queryDetections(
+		"SELECT id, time, " + d.polygonColumn("polygon") + ", frame_id, class, confidence FROM detections " +
+		"WHERE dataframe = ? AND time >= ? AND time < ? AND ST_Intersects(polygon, ST_GeomFromText(?)) " +
+		"ORDER BY time",
+		dataframe, start, end, wkt,
+	)
+}
+
+// Returns enabled frames whose bounds intersect the polygon, with times in
+// [start, end), ordered by time.
+func (d *PostGISDriver) GetFramesInPolygon(poly common.Polygon, start time.Time, end time.Time) ([]*Frame, error) {
+	wkt, err := polygonWKT(poly)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := d.db.Query(
+		"SELECT id, COALESCE(video_id, 0), idx, time, " + d.polygonColumn("bounds") + " FROM video_frames " +
+		"WHERE time >= ? AND time < ? AND enabled = 1 AND ST_Intersects(bounds, ST_GeomFromText(?)) " +
+		"ORDER BY time",
+		start, end, wkt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return rowsToFrames(rows)
+}
+
+// Returns sequences in the dataframe with a member in [start, end) that
+// intersects the polygon. The sequences include all of their members.
+func (d *PostGISDriver) GetSequencesInPolygon(dataframe string, poly common.Polygon, start time.Time, end time.Time) (map[int]*Sequence, error) {
+	wkt, err := polygonWKT(poly)
+	if err != nil {
+		return nil, err
+	}
+	return d.querySequences(
+		dataframe,
+		"SELECT sm.id, sm.sequence_id, sm.detection_id, d.time, " + d.polygonColumn("d.polygon") + ", d.frame_id, d.class, d.confidence, seqs.time, seqs.terminated_at " +
+		"FROM sequences AS seqs, sequence_members AS sm, detections AS d " +
+		"WHERE seqs.id = sm.sequence_id AND d.id = sm.detection_id AND " +
+		"sm.sequence_id IN (" +
+		"SELECT subsm.sequence_id FROM sequence_members AS subsm, detections AS subd, sequences AS subseqs " +
+		"WHERE subseqs.id = subsm.sequence_id AND subd.id = subsm.detection_id AND subseqs.dataframe = ? AND " +
+		"subd.time >= ? AND subd.time < ? AND ST_Intersects(subd.polygon, ST_GeomFromText(?))" +
+		") ORDER BY sm.id",
+		dataframe, start, end, wkt,
+	)
+}
@@ -1,6 +1,7 @@
 package pipeline
 
 import (
+	"encoding/json"
 	"strconv"
 	"strings"
 	"time"
@@ -29,3 +30,27 @@ func GetErrorRateCells(region string) [][2]int {
 	}
 	return cells
 }
+
+// Checkpointed state of the error rate operators: the time of the last
+// observations, and a value per cell whose meaning depends on the operator.
+type errorRateState struct {
+	LastTime time.Time
+	Cells []errorRateCell
+}
+
+type errorRateCell struct {
+	Cell [2]int
+	Val int
+	Time int `json:",omitempty"`
+}
+
+func (state errorRateState) encode() (string, error) {
+	bytes, err := json.Marshal(state)
+	return string(bytes), err
+}
+
+func decodeErrorRateState(s string) (errorRateState, error) {
+	var state errorRateState
+	err := json.Unmarshal([]byte(s), &state)
+	return state, err
+}
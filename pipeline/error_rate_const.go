@@ -4,10 +4,20 @@ import (
 	"time"
 )
 
+var ConstErrorRateSchema = OperatorSchema{
+	Output: MatrixKind,
+	Parents: []DataKind{MatrixKind},
+	Operands: []OperandSpec{
+		{Name: "region", Type: RegionOperand},
+	},
+}
+
 func MakeConstErrorRate(op *Operator, operands map[string]string) {
 	regionCells := GetErrorRateCells(operands["region"])
 	var seenCells map[[2]int]bool
 	var lastTime time.Time
+	// time of the rerun frame
+	var firstFrameTime time.Time
 
 	// we only create observations every ErrorRateInterval
 	// so if we rerun starting in the middle of an interval, we miss all of the
@@ -16,22 +26,51 @@ func MakeConstErrorRate(op *Operator, operands map[string]string) {
 	//  lastTime
 	op.LookBehind = ErrorRateInterval
 
-	op.InitFunc = func(frame *Frame) {
-		driver.DeleteMatrixAfter(op.Name, frame.Time)
-		matrix := LoadMatrix(op.Name)
+	// if we resume from a checkpoint instead of the previous interval, we
+	//  restore the cells seen since the start
+	op.SaveState = func() (string, error) {
+		state := errorRateState{LastTime: lastTime}
+		for cell := range seenCells {
+			state.Cells = append(state.Cells, errorRateCell{Cell: cell})
+		}
+		return state.encode()
+	}
+	op.LoadState = func(s string) error {
+		state, err := decodeErrorRateState(s)
+		if err != nil {
+			return err
+		}
+		lastTime = state.LastTime
+		seenCells = make(map[[2]int]bool)
+		for _, c := range state.Cells {
+			seenCells[c.Cell] = true
+		}
+		return nil
+	}
+
+	op.InitFunc = func(frame *Frame) error {
+		if err := op.Context.Driver.DeleteMatrixAfter(op.Name, frame.Time); err != nil {
+			return err
+		}
+		matrix, err := op.Context.LoadMatrix(op.Name)
+		if err != nil {
+			return err
+		}
 		seenCells = make(map[[2]int]bool)
 		for _, md := range matrix {
 			lastTime = md.Time
 			seenCells[[2]int{md.I, md.J}] = true
 		}
 
+		firstFrameTime = frame.Time
 		op.updateChildRerunTime(frame.Time)
+		return nil
 	}
 
-	op.MatFunc = func(frame *Frame, matrixData []*MatrixData) {
+	op.MatFunc = func(frame *Frame, matrixData []*MatrixData) error {
 		// ignore parent data that has already been processed
 		if frame.Time.Before(lastTime) {
-			return
+			return nil
 		}
 
 		for _, md := range matrixData {
@@ -39,7 +78,14 @@ func MakeConstErrorRate(op *Operator, operands map[string]string) {
 		}
 
 		if frame.Time.Sub(lastTime) < ErrorRateInterval {
-			return
+			return nil
+		}
+
+		// when resuming from a checkpoint, the previous run already added
+		// the observations before the rerun frame
+		if frame.Time.Before(firstFrameTime) {
+			lastTime = frame.Time
+			return nil
 		}
 
 		// generate new observations for all cells
@@ -51,9 +97,12 @@ func MakeConstErrorRate(op *Operator, operands map[string]string) {
 			}
 		}
 		for _, cell := range obsCells {
-			AddMatrixData(op.Name, cell[0], cell[1], 1, "", frame.Time)
+			if _, err := op.Context.AddMatrixData(op.Name, cell[0], cell[1], 1, "", frame.Time); err != nil {
+				return err
+			}
 		}
 		lastTime = frame.Time
+		return nil
 	}
 
 	op.Loader = op.MatrixLoader
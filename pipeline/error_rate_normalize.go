@@ -4,29 +4,63 @@ import (
 	"time"
 )
 
+var NormalizeErrorRateSchema = OperatorSchema{
+	Output: MatrixKind,
+	Parents: []DataKind{MatrixKind},
+}
+
 // Normalize error rates from parents so that the rates are emitted every ErrorRateInterval.
 // This is needed for some operators like PATTERN.
 func MakeNormalizeErrorRate(op *Operator, operands map[string]string) {
 	var rates map[[2]int]int
 	var lastTime time.Time
+	// time of the rerun frame
+	var firstFrameTime time.Time
 
 	// we only create observations every PatternGranularity
 	op.LookBehind = ErrorRateInterval
 
-	op.InitFunc = func(frame *Frame) {
-		driver.DeleteMatrixAfter(op.Name, frame.Time)
-		matrix := LoadMatrix(op.Name)
+	op.SaveState = func() (string, error) {
+		state := errorRateState{LastTime: lastTime}
+		for cell, rate := range rates {
+			state.Cells = append(state.Cells, errorRateCell{Cell: cell, Val: rate})
+		}
+		return state.encode()
+	}
+	op.LoadState = func(s string) error {
+		state, err := decodeErrorRateState(s)
+		if err != nil {
+			return err
+		}
+		lastTime = state.LastTime
+		rates = make(map[[2]int]int)
+		for _, c := range state.Cells {
+			rates[c.Cell] = c.Val
+		}
+		return nil
+	}
+
+	op.InitFunc = func(frame *Frame) error {
+		if err := op.Context.Driver.DeleteMatrixAfter(op.Name, frame.Time); err != nil {
+			return err
+		}
+		matrix, err := op.Context.LoadMatrix(op.Name)
+		if err != nil {
+			return err
+		}
 		rates = make(map[[2]int]int)
 		for _, md := range matrix {
 			lastTime = md.Time
 			rates[[2]int{md.I, md.J}] = md.Val
 		}
+		firstFrameTime = frame.Time
 		op.updateChildRerunTime(frame.Time)
+		return nil
 	}
 
-	op.MatFunc = func(frame *Frame, matrixData []*MatrixData) {
+	op.MatFunc = func(frame *Frame, matrixData []*MatrixData) error {
 		if frame.Time.Before(lastTime) {
-			return
+			return nil
 		}
 
 		for _, md := range matrixData {
@@ -34,7 +68,14 @@ func MakeNormalizeErrorRate(op *Operator, operands map[string]string) {
 		}
 
 		if frame.Time.Sub(lastTime) < ErrorRateInterval {
-			return
+			return nil
+		}
+
+		// when resuming from a checkpoint, the previous run already added
+		// the observations before the rerun frame
+		if frame.Time.Before(firstFrameTime) {
+			lastTime = frame.Time
+			return nil
 		}
 
 		// generate new observations for all cells
@@ -43,9 +84,12 @@ func MakeNormalizeErrorRate(op *Operator, operands map[string]string) {
 			obsCells = append(obsCells, cell)
 		}
 		for _, cell := range obsCells {
-			AddMatrixData(op.Name, cell[0], cell[1], rates[cell], "", frame.Time)
+			if _, err := op.Context.AddMatrixData(op.Name, cell[0], cell[1], rates[cell], "", frame.Time); err != nil {
+				return err
+			}
 		}
 		lastTime = frame.Time
+		return nil
 	}
 
 	op.Loader = op.MatrixLoader
@@ -27,6 +27,15 @@ const PatternHistory int = 7
 // at least for absolute it could be: current value | list of past stuff for (window/recurs) intervals
 // then when we update we look at two matrix datas: previous hour's, and previous day's at same hour
 
+var PatternErrorRateSchema = OperatorSchema{
+	Output: MatrixKind,
+	Parents: []DataKind{MatrixKind},
+	Operands: []OperandSpec{
+		{Name: "mode", Type: StringOperand, Default: "error", Allowed: []string{"absolute", "error"}},
+		{Name: "region", Type: RegionOperand},
+	},
+}
+
 func MakePatternErrorRate(op *Operator, operands map[string]string) {
 	parent := op.Parents[0]
 	isAbsolute := operands["mode"] == "absolute"
@@ -43,20 +52,27 @@ func MakePatternErrorRate(op *Operator, operands map[string]string) {
 	// we only create observations every PatternGranularity
 	//op.LookBehind = PatternGranularity
 
-	op.InitFunc = func(frame *Frame) {
-		driver.DeleteMatrixAfter(op.Name, frame.Time)
-		matrix = LoadMatrix(op.Name)
+	op.InitFunc = func(frame *Frame) error {
+		if err := op.Context.Driver.DeleteMatrixAfter(op.Name, frame.Time); err != nil {
+			return err
+		}
+		var err error
+		matrix, err = op.Context.LoadMatrix(op.Name)
+		if err != nil {
+			return err
+		}
 		//parentMatrix = make(map[[2]int][]*MatrixData)
 		for _, md := range matrix {
 			lastTime = md.Time
 
-			/*parentMD := driver.GetMatrixDataBefore(parent.Name, cell[0], cell[1], lastTime)
+			/*parentMD := op.Context.Driver.GetMatrixDataBefore(parent.Name, cell[0], cell[1], lastTime)
 			if parentMD != nil {
 				parentMatrix[cell] = []*MatrixData{parentMD}
 			}*/
 		}
 		fmt.Printf("last time! %v\n", lastTime)
 		op.updateChildRerunTime(frame.Time)
+		return nil
 	}
 
 	// metadata is lines like "past-delta1,past-delta2,..."
@@ -114,9 +130,9 @@ func MakePatternErrorRate(op *Operator, operands map[string]string) {
 		return intervals
 	}
 
-	op.MatFunc = func(frame *Frame, matrixData []*MatrixData) {
+	op.MatFunc = func(frame *Frame, matrixData []*MatrixData) error {
 		if frame.Time.Before(lastTime) {
-			return
+			return nil
 		}
 
 		for _, md := range matrixData {
@@ -125,7 +141,11 @@ func MakePatternErrorRate(op *Operator, operands map[string]string) {
 				continue
 			}
 			metadata := make([][]int, int(PatternRecurs / PatternGranularity))
-			matrix[cell] = AddMatrixData(op.Name, cell[0], cell[1], 1, encodeMetadata(metadata), frame.Time)
+			newMD, err := op.Context.AddMatrixData(op.Name, cell[0], cell[1], 1, encodeMetadata(metadata), frame.Time)
+			if err != nil {
+				return err
+			}
+			matrix[cell] = newMD
 		}
 
 		lastInterval := getInterval(lastTime)
@@ -133,13 +153,19 @@ func MakePatternErrorRate(op *Operator, operands map[string]string) {
 		if curInterval != lastInterval {
 			parentMatrix := make(map[[2]int][]*MatrixData)
 			for cell := range matrix {
-				md := driver.GetMatrixDataBefore(parent.Name, cell[0], cell[1], lastTime)
-				if md == nil {
+				md, err := op.Context.Driver.GetMatrixDataBefore(parent.Name, cell[0], cell[1], lastTime)
+				if err != nil {
+					return err
+				} else if md == nil {
 					continue
 				}
 				parentMatrix[cell] = append(parentMatrix[cell], md)
 			}
-			for _, md := range driver.GetMatrixDatasAfter(parent.Name, lastTime) {
+			parentDatas, err := op.Context.Driver.GetMatrixDatasAfter(parent.Name, lastTime)
+			if err != nil {
+				return err
+			}
+			for _, md := range parentDatas {
 				cell := [2]int{md.I, md.J}
 				parentMatrix[cell] = append(parentMatrix[cell], md)
 			}
@@ -194,7 +220,11 @@ func MakePatternErrorRate(op *Operator, operands map[string]string) {
 					curDelta = 1
 				}
 				//fmt.Printf("cell=%v, curdelta=%v, lastint=%v, curint=%v, metadata: %v\n", cell, curDelta, lastInterval, curInterval, metadata)
-				matrix[cell] = AddMatrixData(op.Name, cell[0], cell[1], curDelta, encodeMetadata(metadata), frame.Time)
+				md, err := op.Context.AddMatrixData(op.Name, cell[0], cell[1], curDelta, encodeMetadata(metadata), frame.Time)
+				if err != nil {
+					return err
+				}
+				matrix[cell] = md
 				if min == -1 || curDelta < min {
 					min = curDelta
 				}
@@ -209,6 +239,7 @@ func MakePatternErrorRate(op *Operator, operands map[string]string) {
 		/*for _, md := range matrixData {
 			parentMatrix[[2]int{md.I, md.J}] = append(parentMatrix[[2]int{md.I, md.J}], md)
 		}*/
+		return nil
 	}
 
 	op.Loader = op.MatrixLoader
@@ -16,10 +16,20 @@ const DurationBetweenObs time.Duration = time.Hour
 // earlier timestamp, well, we just don't re-run it in that case, just stick to
 // the error rate that was previously used.
 
+var TTLErrorRateSchema = OperatorSchema{
+	Output: MatrixKind,
+	Parents: []DataKind{MatrixKind},
+	Operands: []OperandSpec{
+		{Name: "region", Type: RegionOperand},
+	},
+}
+
 func MakeTTLErrorRate(op *Operator, operands map[string]string) {
 	regionCells := GetErrorRateCells(operands["region"])
 	var matrix map[[2]int]*MatrixData
 	var lastTime time.Time
+	// time of the rerun frame
+	var firstFrameTime time.Time
 
 	// cached TTL map
 	// -1: saw a > 0 observation in parent matrix
@@ -38,9 +48,35 @@ func MakeTTLErrorRate(op *Operator, operands map[string]string) {
 	//  lastTime
 	op.LookBehind = ErrorRateInterval
 
-	op.InitFunc = func(frame *Frame) {
-		driver.DeleteMatrixAfter(op.Name, frame.Time)
-		matrix = LoadMatrix(op.Name)
+	op.SaveState = func() (string, error) {
+		state := errorRateState{LastTime: lastTime}
+		for cell, v := range badVisits {
+			state.Cells = append(state.Cells, errorRateCell{Cell: cell, Val: v.count, Time: v.time})
+		}
+		return state.encode()
+	}
+	op.LoadState = func(s string) error {
+		state, err := decodeErrorRateState(s)
+		if err != nil {
+			return err
+		}
+		lastTime = state.LastTime
+		badVisits = make(map[[2]int]visit)
+		for _, c := range state.Cells {
+			badVisits[c.Cell] = visit{c.Val, c.Time}
+		}
+		return nil
+	}
+
+	op.InitFunc = func(frame *Frame) error {
+		if err := op.Context.Driver.DeleteMatrixAfter(op.Name, frame.Time); err != nil {
+			return err
+		}
+		var err error
+		matrix, err = op.Context.LoadMatrix(op.Name)
+		if err != nil {
+			return err
+		}
 		badVisits = make(map[[2]int]visit)
 		for _, md := range matrix {
 			lastTime = md.Time
@@ -48,22 +84,24 @@ func MakeTTLErrorRate(op *Operator, operands map[string]string) {
 			metadataParts := strings.Split(md.Metadata, ",")
 			count, err := strconv.Atoi(metadataParts[0])
 			if err != nil {
-				panic(err)
+				return err
 			}
 			visitTime, err := strconv.Atoi(metadataParts[1])
 			if err != nil {
-				panic(err)
+				return err
 			}
 			badVisits[[2]int{md.I, md.J}] = visit{count, visitTime}
 		}
 
+		firstFrameTime = frame.Time
 		op.updateChildRerunTime(frame.Time)
+		return nil
 	}
 
-	op.MatFunc = func(frame *Frame, matrixData []*MatrixData) {
+	op.MatFunc = func(frame *Frame, matrixData []*MatrixData) error {
 		// ignore parent data that has already been processed
 		if frame.Time.Before(lastTime) {
-			return
+			return nil
 		}
 
 		// update badVisits
@@ -83,7 +121,14 @@ func MakeTTLErrorRate(op *Operator, operands map[string]string) {
 		}
 
 		if frame.Time.Sub(lastTime) < ErrorRateInterval {
-			return
+			return nil
+		}
+
+		// when resuming from a checkpoint, the previous run already added
+		// the observations before the rerun frame
+		if frame.Time.Before(firstFrameTime) {
+			lastTime = frame.Time
+			return nil
 		}
 
 		// generate new observations for all cells
@@ -103,9 +148,14 @@ func MakeTTLErrorRate(op *Operator, operands map[string]string) {
 			} else {
 				errorRate = 1
 			}
-			matrix[cell] = AddMatrixData(op.Name, cell[0], cell[1], errorRate, metadata, frame.Time)
+			md, err := op.Context.AddMatrixData(op.Name, cell[0], cell[1], errorRate, metadata, frame.Time)
+			if err != nil {
+				return err
+			}
+			matrix[cell] = md
 		}
 		lastTime = frame.Time
+		return nil
 	}
 
 	op.Loader = op.MatrixLoader
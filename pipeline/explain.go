@@ -0,0 +1,274 @@
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+Dry-run planner.
+
+Explain walks the operators in dependency order and simulates how rerun times
+propagate, without executing anything. For each operator it reports the
+frames that Execute would load and the output that InitFunc would discard.
+
+Rerun times lowered by parents are lower bounds: operators like obj_track may
+lower their children further when they extend sequences that started before
+their own rerun time, which we can only know by executing them.
+*/
+
+type OperatorPlan struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Parents []string `json:"parents"`
+
+	// Rerun time in the dataframes table, and after parents lower it.
+	StoredRerunTime time.Time `json:"stored_rerun_time"`
+	RerunTime time.Time `json:"rerun_time"`
+
+	// Time of the checkpoint that execution resumes from, or RerunTime minus
+	// LookBehind if there is none.
+	StartTime time.Time `json:"start_time"`
+	FromCheckpoint bool `json:"from_checkpoint,omitempty"`
+
+	// Number of frames loaded from StartTime.
+	Frames int `json:"frames"`
+
+	// False if there are no frames after the rerun time, in which case the
+	// operator is skipped and does not lower its children's rerun times.
+	Execute bool `json:"execute"`
+
+	// Output discarded by InitFunc.
+	DiscardTime *time.Time `json:"discard_time,omitempty"`
+	DiscardMatrixData int `json:"discard_matrix_data,omitempty"`
+	DiscardDetections int `json:"discard_detections,omitempty"`
+	DiscardSequences *UndoCounts `json:"discard_sequences,omitempty"`
+
+	// Children whose rerun time this operator lowers, and the new rerun time.
+	LowersChildren map[string]time.Time `json:"lowers_children,omitempty"`
+}
+
+// Operator plans in dependency order.
+type Plan []OperatorPlan
+
+// Returns operators in dependency order, breaking ties by name.
+func (pipeline Pipeline) sortedOperators() []*Operator {
+	pending := make(map[string]int)
+	var ready []*Operator
+	for _, op := range pipeline {
+		pending[op.Name] = len(op.Parents)
+		if len(op.Parents) == 0 {
+			ready = append(ready, op)
+		}
+	}
+	var ops []*Operator
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool {
+			return ready[i].Name < ready[j].Name
+		})
+		op := ready[0]
+		ready = ready[1:]
+		ops = append(ops, op)
+		for _, child := range op.Children {
+			pending[child.Name]--
+			if pending[child.Name] == 0 {
+				ready = append(ready, child)
+			}
+		}
+	}
+	return ops
+}
+
+// Returns the time from which the operator discards its output when it is
+// initialized at the specified frame.
+func getDiscardTime(op *Operator, rerunFrame *Frame) time.Time {
+	if op.Type == "time_shift" {
+		return rerunFrame.Time.Add(TimeShiftDuration)
+	}
+	return rerunFrame.Time
+}
+
+// Plan the next run of the pipeline from the current rerun times.
+// The operators are not modified.
+func (pipeline Pipeline) Explain() (Plan, error) {
+	rerunTimes := make(map[string]time.Time)
+	for _, op := range pipeline {
+		rerunTimes[op.Name] = op.RerunTime
+	}
+
+	var plan Plan
+	for _, op := range pipeline.sortedOperators() {
+		p := OperatorPlan{
+			Name: op.Name,
+			Type: op.Type,
+			StoredRerunTime: op.RerunTime,
+			RerunTime: rerunTimes[op.Name],
+		}
+		for _, parent := range op.Parents {
+			p.Parents = append(p.Parents, parent.Name)
+		}
+
+		// children are lowered to this time if the operator runs
+		childRerunTime := p.RerunTime
+
+		if len(op.Parents) > 0 {
+			startTime, resume, err := op.getStartTime(p.RerunTime)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", op.Name, err)
+			}
+			p.StartTime = startTime
+			p.FromCheckpoint = resume != nil
+			frames, err := op.Context.Driver.GetFramesStartingFrom(p.StartTime)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", op.Name, err)
+			}
+			p.Frames = len(frames)
+
+			var rerunFrame *Frame
+			for _, frame := range frames {
+				if !frame.Time.Before(p.RerunTime) {
+					rerunFrame = frame
+					break
+				}
+			}
+			if rerunFrame == nil {
+				plan = append(plan, p)
+				continue
+			}
+			p.Execute = true
+
+			discardTime := getDiscardTime(op, rerunFrame)
+			p.DiscardTime = &discardTime
+			if discardTime.Before(childRerunTime) {
+				childRerunTime = discardTime
+			}
+			if op.InitFunc != nil {
+				switch OperatorSchemas[op.Type].Output {
+				case DetectionKind:
+					p.DiscardDetections, err = op.Context.Driver.CountDetectionsAfter(op.Name, discardTime)
+				case MatrixKind:
+					p.DiscardMatrixData, err = op.Context.Driver.CountMatrixAfter(op.Name, discardTime)
+				case SequenceKind:
+					var counts UndoCounts
+					counts, err = op.Context.Driver.CountUndoSequences(op.Name, discardTime)
+					p.DiscardSequences = &counts
+				}
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", op.Name, err)
+				}
+			}
+		}
+
+		for _, child := range op.Children {
+			if childRerunTime.Before(rerunTimes[child.Name]) {
+				rerunTimes[child.Name] = childRerunTime
+				if p.LowersChildren == nil {
+					p.LowersChildren = make(map[string]time.Time)
+				}
+				p.LowersChildren[child.Name] = childRerunTime
+			}
+		}
+		plan = append(plan, p)
+	}
+	return plan, nil
+}
+
+// Returns the operators that will execute, and the total frames they load.
+func (plan Plan) Totals() (int, int) {
+	var operators, frames int
+	for _, p := range plan {
+		if p.Execute {
+			operators++
+			frames += p.Frames
+		}
+	}
+	return operators, frames
+}
+
+const planTimeFormat = "2006-01-02 15:04:05"
+
+func (p OperatorPlan) describe() []string {
+	var lines []string
+	if len(p.Parents) == 0 {
+		lines = append(lines, fmt.Sprintf("rerun time %s", p.RerunTime.Format(planTimeFormat)))
+	} else if !p.Execute {
+		lines = append(lines, fmt.Sprintf("up to date (no frames after %s)", p.RerunTime.Format(planTimeFormat)))
+	} else {
+		rerun := p.RerunTime.Format(planTimeFormat)
+		if !p.RerunTime.Equal(p.StoredRerunTime) {
+			rerun += fmt.Sprintf(" (lowered from %s)", p.StoredRerunTime.Format(planTimeFormat))
+		}
+		lines = append(lines, "rerun time " + rerun)
+		from := p.StartTime.Format(planTimeFormat)
+		if p.FromCheckpoint {
+			from += " (checkpoint)"
+		}
+		lines = append(lines, fmt.Sprintf("execute %d frames from %s", p.Frames, from))
+		if p.DiscardSequences != nil {
+			c := p.DiscardSequences
+			lines = append(lines, fmt.Sprintf(
+				"discard %d sequences, %d members, %d metadata after %s, reopen %d sequences",
+				c.Sequences, c.Members, c.Metadata, p.DiscardTime.Format(planTimeFormat), c.Reopened,
+			))
+		} else if p.DiscardTime != nil && OperatorSchemas[p.Type].Output == DetectionKind {
+			lines = append(lines, fmt.Sprintf("discard %d detections after %s", p.DiscardDetections, p.DiscardTime.Format(planTimeFormat)))
+		} else if p.DiscardTime != nil {
+			lines = append(lines, fmt.Sprintf("discard %d matrix data after %s", p.DiscardMatrixData, p.DiscardTime.Format(planTimeFormat)))
+		}
+	}
+	var children []string
+	for name := range p.LowersChildren {
+		children = append(children, name)
+	}
+	sort.Strings(children)
+	for _, name := range children {
+		lines = append(lines, fmt.Sprintf("lower %s to %s", name, p.LowersChildren[name].Format(planTimeFormat)))
+	}
+	return lines
+}
+
+// Render the plan as a tree from the root operators. Operators with several
+// parents are shown in full under the first parent.
+func (plan Plan) String() string {
+	byName := make(map[string]OperatorPlan)
+	children := make(map[string][]string)
+	var roots []string
+	for _, p := range plan {
+		byName[p.Name] = p
+		if len(p.Parents) == 0 {
+			roots = append(roots, p.Name)
+		}
+		for _, parent := range p.Parents {
+			children[parent] = append(children[parent], p.Name)
+		}
+	}
+
+	var lines []string
+	shown := make(map[string]bool)
+	var visit func(name string, depth int)
+	visit = func(name string, depth int) {
+		indent := strings.Repeat("    ", depth)
+		p := byName[name]
+		if shown[name] {
+			lines = append(lines, fmt.Sprintf("%s%s (see above)", indent, name))
+			return
+		}
+		shown[name] = true
+		lines = append(lines, fmt.Sprintf("%s%s = %s(%s)", indent, name, p.Type, strings.Join(p.Parents, ", ")))
+		for _, line := range p.describe() {
+			lines = append(lines, indent + "  | " + line)
+		}
+		for _, child := range children[name] {
+			visit(child, depth + 1)
+		}
+	}
+	for _, name := range roots {
+		visit(name, 0)
+	}
+
+	operators, frames := plan.Totals()
+	lines = append(lines, fmt.Sprintf("%d operators will execute, loading %d frames in total", operators, frames))
+	return strings.Join(lines, "\n")
+}
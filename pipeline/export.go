@@ -0,0 +1,375 @@
+package pipeline
+
+import (
+	"github.com/mitroadmaps/gomapinfer/common"
+
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Export of sequence and matrix dataframes for GIS tools and notebooks.
+
+Sequences are written as GeoJSON LineStrings (or Points for single detections)
+or MOTChallenge text, and matrices as GeoJSON cell polygons or long-form CSV.
+Coordinates are orthoimage pixels, or WGS84 or UTM coordinates for GeoJSON
+given a Projection from a Georef. Bounds filters are always in pixels.
+*/
+
+// Export formats of each kind of dataframe. The first is the default.
+var ExportFormats = map[DataKind][]string{
+	SequenceKind: {"geojson", "mot"},
+	MatrixKind: {"geojson", "csv"},
+}
+
+// Restricts exported data to a time range and a region of the orthoimage.
+type ExportFilter struct {
+	// Zero times mean no limit. End is exclusive.
+	Start time.Time
+	End time.Time
+
+	// If set, only sequence members whose center is inside, and cells that
+	// intersect the bounds.
+	Bounds *common.Rectangle
+
+	// If set, only sequence members in frames of the video. Matrices are not
+	// filtered by video.
+	VideoID int
+}
+
+func (filter ExportFilter) containsTime(t time.Time) bool {
+	if !filter.Start.IsZero() && t.Before(filter.Start) {
+		return false
+	} else if !filter.End.IsZero() && !t.Before(filter.End) {
+		return false
+	}
+	return true
+}
+
+// Parses a rectangle "sx sy ex ey" with corners in any order.
+func ParseRectangle(s string) (common.Rectangle, error) {
+	parts := strings.Fields(s)
+	if len(parts) != 4 {
+		return common.Rectangle{}, fmt.Errorf("expected four numbers")
+	}
+	var vals [4]float64
+	for i, part := range parts {
+		var err error
+		vals[i], err = strconv.ParseFloat(part, 64)
+		if err != nil {
+			return common.Rectangle{}, err
+		}
+	}
+	return common.Rectangle{
+		common.Point{math.Min(vals[0], vals[2]), math.Min(vals[1], vals[3])},
+		common.Point{math.Max(vals[0], vals[2]), math.Max(vals[1], vals[3])},
+	}, nil
+}
+
+type geoJSONGeometry struct {
+	Type string `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type geoJSONFeature struct {
+	Type string `json:"type"`
+	Geometry geoJSONGeometry `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONFeatureCollection struct {
+	Type string `json:"type"`
+	CRS interface{} `json:"crs,omitempty"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+func writeGeoJSON(w io.Writer, features []geoJSONFeature, proj Projection) error {
+	if features == nil {
+		features = []geoJSONFeature{}
+	}
+	collection := geoJSONFeatureCollection{Type: "FeatureCollection", Features: features}
+	// WGS84 is the default CRS of GeoJSON
+	if proj.CRS != "" && proj.CRS != "EPSG:4326" {
+		collection.CRS = map[string]interface{}{
+			"type": "name",
+			"properties": map[string]string{
+				"name": "urn:ogc:def:crs:" + strings.Replace(proj.CRS, ":", "::", 1),
+			},
+		}
+	}
+	bytes, err := json.Marshal(collection)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(bytes, '\n'))
+	return err
+}
+
+// Returns the members of each sequence that pass the filter, ordered by
+// sequence ID. Sequences with no such members are skipped.
+func (ctx *Context) getExportSequences(dataframe string, filter ExportFilter) ([]*Sequence, error) {
+	var sequences map[int]*Sequence
+	var err error
+	if driver, ok := ctx.Driver.(SpatialDriver); ok && filter.Bounds != nil {
+		// only load sequences in the region, the members are filtered below
+		end := filter.End
+		if end.IsZero() {
+			end = time.Now().Add(9999*time.Hour)
+		}
+		sequences, err = driver.GetSequencesInPolygon(dataframe, filter.Bounds.ToPolygon(), filter.Start, end)
+	} else {
+		sequences, err = ctx.Driver.GetSequences(dataframe)
+	}
+	if err != nil {
+		return nil, err
+	}
+	frameVideos := make(map[int]int)
+	var seqs []*Sequence
+	for _, seq := range sequences {
+		var members []*SequenceMember
+		for _, member := range seq.Members {
+			if !filter.containsTime(member.Detection.Time) {
+				continue
+			} else if filter.Bounds != nil && !filter.Bounds.Contains(member.Detection.Polygon.Bounds().Center()) {
+				continue
+			}
+			if filter.VideoID != 0 {
+				frameID := member.Detection.FrameID
+				if _, ok := frameVideos[frameID]; !ok {
+					frame, err := ctx.Driver.GetFrame(frameID)
+					if err != nil {
+						return nil, err
+					} else if frame == nil {
+						return nil, fmt.Errorf("detection %d has unknown frame %d", member.Detection.ID, frameID)
+					}
+					frameVideos[frameID] = frame.VideoID
+				}
+				if frameVideos[frameID] != filter.VideoID {
+					continue
+				}
+			}
+			members = append(members, member)
+		}
+		if len(members) == 0 {
+			continue
+		}
+		sort.Slice(members, func(i, j int) bool {
+			return members[i].Detection.Time.Before(members[j].Detection.Time)
+		})
+		s := *seq
+		s.Members = members
+		seqs = append(seqs, &s)
+	}
+	sort.Slice(seqs, func(i, j int) bool {
+		return seqs[i].ID < seqs[j].ID
+	})
+	return seqs, nil
+}
+
+func (ctx *Context) ExportSequencesGeoJSON(w io.Writer, dataframe string, filter ExportFilter, proj Projection) error {
+	seqs, err := ctx.getExportSequences(dataframe, filter)
+	if err != nil {
+		return err
+	}
+	var features []geoJSONFeature
+	for _, seq := range seqs {
+		var coords [][2]float64
+		var times []string
+		for _, p := range seq.points() {
+			p = proj.Project(p)
+			coords = append(coords, [2]float64{p.X, p.Y})
+		}
+		for _, member := range seq.Members {
+			times = append(times, member.Detection.Time.Format(time.RFC3339Nano))
+		}
+		geometry := geoJSONGeometry{"LineString", coords}
+		if len(coords) == 1 {
+			geometry = geoJSONGeometry{"Point", coords[0]}
+		}
+		properties := map[string]interface{}{
+			"id": seq.ID,
+			"start": seq.Members[0].Detection.Time,
+			"end": seq.Members[len(seq.Members)-1].Detection.Time,
+			"times": times,
+		}
+		if seq.Terminated != nil {
+			properties["terminated"] = *seq.Terminated
+		}
+		features = append(features, geoJSONFeature{"Feature", geometry, properties})
+	}
+	return writeGeoJSON(w, features, proj)
+}
+
+// Writes frame,id,x,y,w,h,conf,-1,-1,-1 lines, where frame is the index of the
+// detection's frame in its video, id is the sequence ID, and the box is the
+// bounds of the detection in the orthoimage.
+// Frame indices are only unique within a video, so the exported members must
+// all be from one video, e.g. by setting filter.VideoID.
+func (ctx *Context) ExportSequencesMOT(w io.Writer, dataframe string, filter ExportFilter) error {
+	seqs, err := ctx.getExportSequences(dataframe, filter)
+	if err != nil {
+		return err
+	}
+	frameIdxs := make(map[int]int)
+	videoID := -1
+	type motRow struct {
+		frameIdx int
+		seqID int
+		detection *Detection
+	}
+	var rows []motRow
+	for _, seq := range seqs {
+		for _, member := range seq.Members {
+			frameID := member.Detection.FrameID
+			if _, ok := frameIdxs[frameID]; !ok {
+				frame, err := ctx.Driver.GetFrame(frameID)
+				if err != nil {
+					return err
+				} else if frame == nil {
+					return fmt.Errorf("detection %d has unknown frame %d", member.Detection.ID, frameID)
+				}
+				if videoID == -1 {
+					videoID = frame.VideoID
+				} else if frame.VideoID != videoID {
+					return fmt.Errorf("sequences are in videos %d and %d, but MOT frame numbers are per video, so export one video at a time", videoID, frame.VideoID)
+				}
+				frameIdxs[frameID] = frame.Idx
+			}
+			rows = append(rows, motRow{frameIdxs[frameID], seq.ID, member.Detection})
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].frameIdx < rows[j].frameIdx
+	})
+	for _, row := range rows {
+		rect := row.detection.Polygon.Bounds()
+		lengths := rect.Lengths()
+		if _, err := fmt.Fprintf(w, "%d,%d,%v,%v,%v,%v,%v,-1,-1,-1\n", row.frameIdx, row.seqID, rect.Min.X, rect.Min.Y, lengths.X, lengths.Y, row.detection.Confidence); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the matrix data that pass the filter, ordered by time.
+func (ctx *Context) getExportMatrixData(dataframe string, grid Grid, filter ExportFilter) ([]*MatrixData, error) {
+	mds, err := ctx.Driver.GetMatrixDatasAfter(dataframe, filter.Start)
+	if err != nil {
+		return nil, err
+	}
+	var filtered []*MatrixData
+	for _, md := range mds {
+		if !filter.containsTime(md.Time) {
+			continue
+		} else if filter.Bounds != nil && !filter.Bounds.Intersects(GetCellRect([2]int{md.I, md.J}, grid)) {
+			continue
+		}
+		filtered = append(filtered, md)
+	}
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return filtered[i].Time.Before(filtered[j].Time)
+	})
+	return filtered, nil
+}
+
+func (ctx *Context) ExportMatrixGeoJSON(w io.Writer, dataframe string, filter ExportFilter, proj Projection) error {
+	grid, err := ctx.GetDataframeGrid(dataframe)
+	if err != nil {
+		return err
+	}
+	mds, err := ctx.getExportMatrixData(dataframe, grid, filter)
+	if err != nil {
+		return err
+	}
+	var features []geoJSONFeature
+	for _, md := range mds {
+		var ring [][2]float64
+		rect := GetCellRect([2]int{md.I, md.J}, grid)
+		for _, p := range []common.Point{rect.Min, {rect.Max.X, rect.Min.Y}, rect.Max, {rect.Min.X, rect.Max.Y}, rect.Min} {
+			p = proj.Project(p)
+			ring = append(ring, [2]float64{p.X, p.Y})
+		}
+		features = append(features, geoJSONFeature{
+			Type: "Feature",
+			Geometry: geoJSONGeometry{"Polygon", [][][2]float64{ring}},
+			Properties: map[string]interface{}{
+				"time": md.Time,
+				"i": md.I,
+				"j": md.J,
+				"val": md.Val,
+			},
+		})
+	}
+	return writeGeoJSON(w, features, proj)
+}
+
+// Writes time,i,j,val rows with a header.
+func (ctx *Context) ExportMatrixCSV(w io.Writer, dataframe string, filter ExportFilter) error {
+	grid, err := ctx.GetDataframeGrid(dataframe)
+	if err != nil {
+		return err
+	}
+	mds, err := ctx.getExportMatrixData(dataframe, grid, filter)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "i", "j", "val"})
+	for _, md := range mds {
+		cw.Write([]string{
+			md.Time.Format(time.RFC3339Nano),
+			strconv.Itoa(md.I),
+			strconv.Itoa(md.J),
+			strconv.Itoa(md.Val),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Export a sequence or matrix dataframe in one of its ExportFormats, or the
+// default format if format is empty. Only GeoJSON supports projections other
+// than PixelProjection.
+func ExportDataframe(w io.Writer, dataframe string, format string, filter ExportFilter, proj Projection) error {
+	return DefaultContext.ExportDataframe(w, dataframe, format, filter, proj)
+}
+
+func (ctx *Context) ExportDataframe(w io.Writer, dataframe string, format string, filter ExportFilter, proj Projection) error {
+	specs, err := ctx.GetDataframeSpecs()
+	if err != nil {
+		return err
+	}
+	spec, ok := specs[dataframe]
+	if !ok {
+		return fmt.Errorf("unknown dataframe %s", dataframe)
+	}
+	kind := OperatorSchemas[spec.OpType].Output
+	formats := ExportFormats[kind]
+	if len(formats) == 0 {
+		return fmt.Errorf("cannot export %s dataframe %s", kind, dataframe)
+	}
+	if format == "" {
+		format = formats[0]
+	}
+	if format != "geojson" && proj.CRS != "" {
+		return fmt.Errorf("%s export only supports pixel coordinates", format)
+	}
+	switch {
+	case kind == SequenceKind && format == "geojson":
+		return ctx.ExportSequencesGeoJSON(w, dataframe, filter, proj)
+	case kind == SequenceKind && format == "mot":
+		return ctx.ExportSequencesMOT(w, dataframe, filter)
+	case kind == MatrixKind && format == "geojson":
+		return ctx.ExportMatrixGeoJSON(w, dataframe, filter, proj)
+	case kind == MatrixKind && format == "csv":
+		return ctx.ExportMatrixCSV(w, dataframe, filter)
+	}
+	return fmt.Errorf("cannot export %s dataframe %s as %s; expected one of %s", kind, dataframe, format, strings.Join(formats, ", "))
+}
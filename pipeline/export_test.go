@@ -0,0 +1,52 @@
+package pipeline
+
+import (
+	"github.com/mitroadmaps/gomapinfer/common"
+
+	"bytes"
+	"testing"
+	"time"
+)
+
+// MOT frame numbers are per video, so sequences that span videos can only be
+// exported one video at a time.
+func TestExportSequencesMOTVideos(t *testing.T) {
+	ctx, driver := newTestContext(t)
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	seq, err := driver.AddSequence("tracks", base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, videoID := range []int{1, 1, 2} {
+		poly := common.Polygon{{0, 0}, {10, 0}, {10, 20}, {0, 20}}
+		frame, err := driver.AddFrame(videoID, i, base.Add(time.Duration(i)*time.Second), poly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		detection := &Detection{
+			Time: frame.Time,
+			Polygon: poly,
+			FrameID: frame.ID,
+			Confidence: 1,
+		}
+		if err := driver.AddDetection("dets", detection); err != nil {
+			t.Fatal(err)
+		}
+		if err := seq.AddMember(detection, detection.Time); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := ctx.ExportSequencesMOT(&buf, "tracks", ExportFilter{}); err == nil {
+		t.Fatal("expected error for sequences in two videos")
+	}
+	buf.Reset()
+	if err := ctx.ExportSequencesMOT(&buf, "tracks", ExportFilter{VideoID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "0,0,0,0,10,20,1,-1,-1,-1\n1,0,0,0,10,20,1,-1,-1,-1\n"
+	if buf.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, buf.String())
+	}
+}
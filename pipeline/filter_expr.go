@@ -0,0 +1,193 @@
+package pipeline
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+Filter expressions.
+
+An expression combines comparisons of FilterFuncs with AND, OR, NOT and
+parentheses, e.g.:
+
+	length > 5 AND displacement < 75 AND NOT (duration < 120 OR duration > 3600)
+
+Each side of a comparison is a FilterFuncs name or a number, and the
+comparison is one of <, >, <=, >=, == or !=. NOT binds tighter than AND, which
+binds tighter than OR. Keywords are case-insensitive.
+*/
+
+type FilterExpr func(*Sequence) bool
+
+var filterComparisons = map[string]func(float64, float64) bool{
+	"<": func(a, b float64) bool { return a < b },
+	">": func(a, b float64) bool { return a > b },
+	"<=": func(a, b float64) bool { return a <= b },
+	">=": func(a, b float64) bool { return a >= b },
+	"==": func(a, b float64) bool { return a == b },
+	"!=": func(a, b float64) bool { return a != b },
+}
+
+type filterParser struct {
+	ctx *Context
+	tokens []queryToken
+	pos int
+	// whether the expression uses metrics in meters, which need the GSD
+	usesMeters bool
+}
+
+func (p *filterParser) peek() queryToken {
+	if p.pos >= len(p.tokens) {
+		return queryToken{"end", "end of expression"}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) isKeyword(keyword string) bool {
+	token := p.peek()
+	return token.kind == "ident" && strings.ToUpper(token.text) == keyword
+}
+
+func (p *filterParser) parseOr() (FilterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(seq *Sequence) bool {
+			return l(seq) || right(seq)
+		}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (FilterExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(seq *Sequence) bool {
+			return l(seq) && right(seq)
+		}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (FilterExpr, error) {
+	if p.isKeyword("NOT") {
+		p.pos++
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(seq *Sequence) bool {
+			return !expr(seq)
+		}, nil
+	}
+	if p.peek().kind == "(" {
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != ")" {
+			return nil, fmt.Errorf("expected ), got %s", p.peek().text)
+		}
+		p.pos++
+		return expr, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (FilterExpr, error) {
+	left, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	token := p.peek()
+	cmp := filterComparisons[token.text]
+	if token.kind != "cmp" || cmp == nil {
+		return nil, fmt.Errorf("expected comparison, got %s", token.text)
+	}
+	p.pos++
+	right, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	return func(seq *Sequence) bool {
+		return cmp(left(seq), right(seq))
+	}, nil
+}
+
+// Parse a FilterFuncs name or a number.
+func (p *filterParser) parseValue() (FilterFunc, error) {
+	token := p.peek()
+	if token.kind != "ident" {
+		return nil, fmt.Errorf("expected filter function or number, got %s", token.text)
+	}
+	p.pos++
+	if f := p.ctx.getFilterFunc(token.text); f != nil {
+		if isMeterMetric(token.text) {
+			p.usesMeters = true
+		}
+		return f, nil
+	}
+	val, err := strconv.ParseFloat(token.text, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unknown filter function %s; expected one of %s", token.text, strings.Join(filterFuncNames(), ", "))
+	}
+	return func(seq *Sequence) float64 {
+		return val
+	}, nil
+}
+
+func ParseFilterExpr(s string) (FilterExpr, error) {
+	expr, _, err := parseFilterExpr(s, DefaultContext)
+	return expr, err
+}
+
+// Metrics in meters use the GSD of the context.
+// Also returns whether the expression uses metrics in meters.
+func parseFilterExpr(s string, ctx *Context) (FilterExpr, bool, error) {
+	tokens, err := tokenizeQueryLine(s)
+	if err != nil {
+		return nil, false, err
+	}
+	p := &filterParser{ctx: ctx, tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, false, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, false, fmt.Errorf("unexpected %s", p.peek().text)
+	}
+	return expr, p.usesMeters, nil
+}
+
+// Join tokens of a filter expression in the query language back into a
+// string for the expr operand.
+func joinFilterTokens(tokens []queryToken) string {
+	var s string
+	for i, token := range tokens {
+		if i > 0 && token.kind != ")" && tokens[i-1].kind != "(" {
+			s += " "
+		}
+		s += token.text
+	}
+	return s
+}
@@ -0,0 +1,144 @@
+package pipeline
+
+import (
+	"github.com/mitroadmaps/gomapinfer/common"
+
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns a sequence with n members spread evenly over the duration, moving
+// 10 pixels right at each member.
+func makeTestSequence(n int, duration time.Duration) *Sequence {
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	seq := &Sequence{Time: base}
+	for i := 0; i < n; i++ {
+		t := base
+		if n > 1 {
+			t = base.Add(duration * time.Duration(i) / time.Duration(n-1))
+		}
+		x := float64(10 * i)
+		seq.Members = append(seq.Members, &SequenceMember{
+			Detection: &Detection{
+				Time: t,
+				Polygon: common.Polygon{{x, 0}, {x + 10, 0}, {x + 10, 10}, {x, 10}},
+			},
+			time: t,
+		})
+	}
+	return seq
+}
+
+func TestFilterExpr(t *testing.T) {
+	ctx := &Context{GSD: 0.5}
+	short := makeTestSequence(3, 60*time.Second)
+	long := makeTestSequence(10, 600*time.Second)
+	hours := makeTestSequence(10, 2*time.Hour)
+	tests := []struct {
+		expr string
+		seq *Sequence
+		expected bool
+	}{
+		{"length > 5 AND NOT (duration < 120 OR duration > 3600)", long, true},
+		{"length > 5 AND NOT (duration < 120 OR duration > 3600)", short, false},
+		{"length > 5 AND NOT (duration < 120 OR duration > 3600)", hours, false},
+		// AND binds tighter than OR, and NOT tighter than AND
+		{"length < 5 OR length > 5 AND duration > 3600", long, false},
+		{"length < 5 OR length > 5 AND duration > 3600", short, true},
+		{"(length < 5 OR length > 5) AND duration > 3600", short, false},
+		{"NOT length < 5 AND duration < 3600", long, true},
+		{"NOT (length < 5 AND duration < 3600)", short, false},
+		{"not length < 5 and duration < 3600", long, true},
+		// numbers on either side
+		{"5 < length", long, true},
+		{"length >= 10 AND length <= 10 AND length == 10 AND length != 9.5", long, true},
+		{"duration == 0", makeTestSequence(1, 0), true},
+		// displacement is 90px, or 45m at 0.5m per pixel
+		{"displacement > 89.5 AND displacement_m < 45.5 AND displacement_m > 44.5", long, true},
+	}
+	for _, test := range tests {
+		expr, _, err := parseFilterExpr(test.expr, ctx)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if got := expr(test.seq); got != test.expected {
+			t.Errorf("%s on %d members over %vs: expected %v, got %v", test.expr, len(test.seq.Members), FilterFuncs["duration"](test.seq), test.expected, got)
+		}
+	}
+}
+
+func TestFilterExprUsesMeters(t *testing.T) {
+	ctx := &Context{GSD: 0.5}
+	for expr, expected := range map[string]bool{
+		"length > 5": false,
+		"length > 5 OR NOT displacement_m < 10": true,
+	} {
+		if _, usesMeters, err := parseFilterExpr(expr, ctx); err != nil {
+			t.Errorf("%s: %v", expr, err)
+		} else if usesMeters != expected {
+			t.Errorf("%s: expected usesMeters=%v, got %v", expr, expected, usesMeters)
+		}
+	}
+}
+
+func TestFilterExprErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err string
+	}{
+		{"lenght > 5", "unknown filter function lenght"},
+		{"length > 5 duration", "unexpected duration"},
+		{"length > 5)", "unexpected )"},
+		{"(length > 5", "expected ), got end of expression"},
+		{"length AND duration > 5", "expected comparison, got AND"},
+		{"length >", "expected filter function or number, got end of expression"},
+		{"NOT", "expected filter function or number, got end of expression"},
+		{"length > 5 AND", "expected filter function or number, got end of expression"},
+		{"length = 5", "expected comparison, got ="},
+	}
+	for _, test := range tests {
+		_, _, err := parseFilterExpr(test.expr, &Context{})
+		if err == nil {
+			t.Errorf("%s: expected error", test.expr)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %q, got %v", test.expr, test.err, err)
+		}
+	}
+}
+
+// Filter expressions in queries are joined back into the expr operand, which
+// must parse to the same expression.
+func TestFilterExprQuery(t *testing.T) {
+	existing := map[string]DataframeSpec{
+		"cars": {Name: "cars", OpType: "raw_detection"},
+		"tracks": {Name: "tracks", Parents: []string{"cars"}, OpType: "obj_track"},
+	}
+	for _, expr := range []string{
+		"length > 5 AND NOT (duration < 120 OR duration > 3600)",
+		"(length < 5 OR length > 5) AND duration > 3600",
+		"NOT (NOT length >= 10)",
+	} {
+		specs, err := CompileQuery("long = filter(tracks, "+expr+")", existing)
+		if err != nil {
+			t.Errorf("%s: %v", expr, err)
+			continue
+		}
+		if len(specs) != 1 || specs[0].Operands["expr"] != expr {
+			t.Errorf("%s: expected expr operand %q, got %v", expr, expr, specs)
+			continue
+		}
+		joined, _, err := parseFilterExpr(specs[0].Operands["expr"], &Context{})
+		if err != nil {
+			t.Errorf("%s: %v", expr, err)
+			continue
+		}
+		original, _, _ := parseFilterExpr(expr, &Context{})
+		for _, seq := range []*Sequence{makeTestSequence(3, 60*time.Second), makeTestSequence(10, 600*time.Second), makeTestSequence(10, 2*time.Hour)} {
+			if joined(seq) != original(seq) {
+				t.Errorf("%s: joined expression %q evaluates differently", expr, specs[0].Operands["expr"])
+			}
+		}
+	}
+}
@@ -14,12 +14,7 @@ type Frame struct {
 	Bounds common.Polygon
 }
 
-func GetFrame(id int) *Frame {
-	rows := db.Query("SELECT id, IFNULL(video_id, 0), idx, time, bounds FROM video_frames WHERE id = ?", id)
-	frames := rowsToFrames(rows)
-	if len(frames) == 1 {
-		return frames[0]
-	} else {
-		return nil
-	}
+// Returns the frame in DefaultContext, or nil if there is no frame with the ID.
+func GetFrame(id int) (*Frame, error) {
+	return DefaultContext.Driver.GetFrame(id)
 }
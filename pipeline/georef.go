@@ -0,0 +1,364 @@
+package pipeline
+
+import (
+	"github.com/mitroadmaps/gomapinfer/common"
+
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+Georeferencing of orthoimage pixel coordinates.
+
+A Georef maps orthoimage pixels to a coordinate reference system (CRS) with a
+GeoTIFF-style affine transform, as printed by gdalinfo ("Origin" and "Pixel
+Size") or stored in a world file. Supported CRSes are WGS84 longitude/latitude
+(EPSG:4326), web mercator (EPSG:3857), and WGS84 UTM zones (EPSG:326xx north,
+EPSG:327xx south), which is what OpenDroneMap produces.
+
+Georefs are stored by area name in the georefs table.
+*/
+
+// Name of the georef of the orthoimage used by new contexts, which can be set
+// with the SKYQUERY_GEOREF environment variable. Each Context can use a
+// different georef, see Context.GeorefName.
+var DefaultGeorefName = getDefaultGeorefName()
+
+func getDefaultGeorefName() string {
+	if name := os.Getenv("SKYQUERY_GEOREF"); name != "" {
+		return name
+	}
+	return "ortho"
+}
+
+type Georef struct {
+	// GDAL geotransform: pixel (px, py) maps to CRS coordinates
+	//  x = T[0] + px*T[1] + py*T[2]
+	//  y = T[3] + px*T[4] + py*T[5]
+	Transform [6]float64
+	CRS string
+}
+
+const (
+	wgs84A float64 = 6378137
+	wgs84F float64 = 1 / 298.257223563
+	utmK0 float64 = 0.9996
+)
+
+// Parses an EPSG code like EPSG:32611 into a UTM zone and hemisphere.
+func parseUTMCRS(crs string) (zone int, north bool, ok bool) {
+	code, err := strconv.Atoi(strings.TrimPrefix(crs, "EPSG:"))
+	if err != nil {
+		return 0, false, false
+	} else if code > 32600 && code <= 32660 {
+		return code - 32600, true, true
+	} else if code > 32700 && code <= 32760 {
+		return code - 32700, false, true
+	}
+	return 0, false, false
+}
+
+func checkCRS(crs string) error {
+	if crs == "EPSG:4326" || crs == "EPSG:3857" {
+		return nil
+	} else if _, _, ok := parseUTMCRS(crs); ok {
+		return nil
+	}
+	return fmt.Errorf("unsupported CRS %s; expected EPSG:4326, EPSG:3857, or a WGS84 UTM zone EPSG:326xx/327xx", crs)
+}
+
+func NewGeoref(transform [6]float64, crs string) (*Georef, error) {
+	if err := checkCRS(crs); err != nil {
+		return nil, err
+	}
+	if transform[1]*transform[5] - transform[2]*transform[4] == 0 {
+		return nil, fmt.Errorf("transform is not invertible")
+	}
+	return &Georef{transform, crs}, nil
+}
+
+// Returns a north-up georef in the WGS84 UTM zone containing the longitude and
+// latitude origin, where pixel (0, 0) is at origin and pixels are squares of
+// the given size in meters.
+func NewUTMGeoref(origin common.Point, metersPerPixel float64) (*Georef, error) {
+	zone, north := getUTMZone(origin)
+	crs := fmt.Sprintf("EPSG:%d", 32600 + zone)
+	if !north {
+		crs = fmt.Sprintf("EPSG:%d", 32700 + zone)
+	}
+	p := lonLatToUTM(origin, zone, north)
+	return NewGeoref([6]float64{p.X, metersPerPixel, 0, p.Y, 0, -metersPerPixel}, crs)
+}
+
+// Returns a georef from the six lines of a world file (A, D, B, E, C, F),
+// which give the center of the top-left pixel rather than its corner.
+func ParseWorldFile(s string, crs string) (*Georef, error) {
+	parts := strings.Fields(s)
+	if len(parts) != 6 {
+		return nil, fmt.Errorf("expected six numbers in world file")
+	}
+	var v [6]float64
+	for i, part := range parts {
+		var err error
+		v[i], err = strconv.ParseFloat(part, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	a, d, b, e, c, f := v[0], v[1], v[2], v[3], v[4], v[5]
+	return NewGeoref([6]float64{c - a/2 - b/2, a, b, f - d/2 - e/2, d, e}, crs)
+}
+
+// Parses a geotransform "T0 T1 T2 T3 T4 T5".
+func ParseGeoTransform(s string, crs string) (*Georef, error) {
+	parts := strings.Fields(s)
+	if len(parts) != 6 {
+		return nil, fmt.Errorf("expected six numbers in geotransform")
+	}
+	var transform [6]float64
+	for i, part := range parts {
+		var err error
+		transform[i], err = strconv.ParseFloat(part, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewGeoref(transform, crs)
+}
+
+func (g *Georef) transformString() string {
+	var parts []string
+	for _, v := range g.Transform {
+		parts = append(parts, strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (g *Georef) String() string {
+	return fmt.Sprintf("%s [%s]", g.CRS, g.transformString())
+}
+
+// Returns the georef of the area, or nil if there is none.
+func GetGeoref(name string) (*Georef, error) {
+	return DefaultContext.GetGeoref(name)
+}
+
+func (ctx *Context) GetGeoref(name string) (*Georef, error) {
+	rows, err := ctx.DB.Query("SELECT crs, transform FROM georefs WHERE name = ?", name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	var crs, transform string
+	if err := rows.Scan(&crs, &transform); err != nil {
+		return nil, err
+	}
+	return ParseGeoTransform(transform, crs)
+}
+
+func SetGeoref(name string, g *Georef) error {
+	return DefaultContext.SetGeoref(name, g)
+}
+
+func (ctx *Context) SetGeoref(name string, g *Georef) error {
+	_, err := ctx.DB.Exec(
+		ctx.DB.Dialect().Replace("georefs", "name", "crs", "transform"),
+		name, g.CRS, g.transformString(),
+	)
+	return err
+}
+
+func (g *Georef) PixelToCRS(p common.Point) common.Point {
+	t := g.Transform
+	return common.Point{
+		t[0] + p.X*t[1] + p.Y*t[2],
+		t[3] + p.X*t[4] + p.Y*t[5],
+	}
+}
+
+func (g *Georef) CRSToPixel(p common.Point) common.Point {
+	t := g.Transform
+	det := t[1]*t[5] - t[2]*t[4]
+	x, y := p.X - t[0], p.Y - t[3]
+	return common.Point{
+		(x*t[5] - y*t[2]) / det,
+		(y*t[1] - x*t[4]) / det,
+	}
+}
+
+// Returns the WGS84 longitude and latitude of a pixel.
+func (g *Georef) ToLonLat(p common.Point) common.Point {
+	p = g.PixelToCRS(p)
+	if g.CRS == "EPSG:3857" {
+		return webMercatorToLonLat(p)
+	} else if zone, north, ok := parseUTMCRS(g.CRS); ok {
+		return utmToLonLat(p, zone, north)
+	}
+	return p
+}
+
+// Returns the pixel of a WGS84 longitude and latitude.
+func (g *Georef) FromLonLat(lonLat common.Point) common.Point {
+	p := lonLat
+	if g.CRS == "EPSG:3857" {
+		p = lonLatToWebMercator(lonLat)
+	} else if zone, north, ok := parseUTMCRS(g.CRS); ok {
+		p = lonLatToUTM(lonLat, zone, north)
+	}
+	return g.CRSToPixel(p)
+}
+
+// Returns the UTM zone of the georef, i.e. its own zone if the CRS is UTM, or
+// the zone containing the top-left pixel otherwise.
+func (g *Georef) UTMZone() (zone int, north bool) {
+	if zone, north, ok := parseUTMCRS(g.CRS); ok {
+		return zone, north
+	}
+	return getUTMZone(g.ToLonLat(common.Point{0, 0}))
+}
+
+// Returns the UTM zone containing a longitude and latitude.
+func getUTMZone(lonLat common.Point) (zone int, north bool) {
+	zone = int(math.Floor((lonLat.X + 180) / 6)) + 1
+	if zone > 60 {
+		zone = 60
+	}
+	return zone, lonLat.Y >= 0
+}
+
+// Returns the UTM easting and northing in meters of a pixel, in the georef's
+// UTM zone.
+func (g *Georef) ToUTM(p common.Point) common.Point {
+	zone, north := g.UTMZone()
+	if zone2, north2, ok := parseUTMCRS(g.CRS); ok && zone2 == zone && north2 == north {
+		return g.PixelToCRS(p)
+	}
+	return lonLatToUTM(g.ToLonLat(p), zone, north)
+}
+
+// Returns the distance in meters between two pixels.
+func (g *Georef) Distance(p1 common.Point, p2 common.Point) float64 {
+	return g.ToUTM(p1).Distance(g.ToUTM(p2))
+}
+
+// Returns the mean side length of a pixel in meters at the top-left pixel.
+func (g *Georef) MetersPerPixel() float64 {
+	origin := common.Point{0, 0}
+	return (g.Distance(origin, common.Point{1, 0}) + g.Distance(origin, common.Point{0, 1})) / 2
+}
+
+func webMercatorToLonLat(p common.Point) common.Point {
+	return common.Point{
+		p.X / wgs84A * 180 / math.Pi,
+		(2*math.Atan(math.Exp(p.Y / wgs84A)) - math.Pi/2) * 180 / math.Pi,
+	}
+}
+
+func lonLatToWebMercator(p common.Point) common.Point {
+	lat := p.Y * math.Pi / 180
+	return common.Point{
+		wgs84A * p.X * math.Pi / 180,
+		wgs84A * math.Log(math.Tan(math.Pi/4 + lat/2)),
+	}
+}
+
+// Transverse mercator series from Snyder, Map Projections: A Working Manual.
+
+func utmCentralMeridian(zone int) float64 {
+	return float64(zone - 1)*6 - 180 + 3
+}
+
+func lonLatToUTM(p common.Point, zone int, north bool) common.Point {
+	e2 := wgs84F * (2 - wgs84F)
+	ep2 := e2 / (1 - e2)
+	lat := p.Y * math.Pi / 180
+	dlon := (p.X - utmCentralMeridian(zone)) * math.Pi / 180
+
+	sin, cos, tan := math.Sin(lat), math.Cos(lat), math.Tan(lat)
+	n := wgs84A / math.Sqrt(1 - e2*sin*sin)
+	t := tan * tan
+	c := ep2 * cos * cos
+	a := cos * dlon
+	m := wgs84A * ((1 - e2/4 - 3*e2*e2/64 - 5*e2*e2*e2/256)*lat -
+		(3*e2/8 + 3*e2*e2/32 + 45*e2*e2*e2/1024)*math.Sin(2*lat) +
+		(15*e2*e2/256 + 45*e2*e2*e2/1024)*math.Sin(4*lat) -
+		(35*e2*e2*e2/3072)*math.Sin(6*lat))
+
+	x := utmK0 * n * (a + (1 - t + c)*math.Pow(a, 3)/6 +
+		(5 - 18*t + t*t + 72*c - 58*ep2)*math.Pow(a, 5)/120) + 500000
+	y := utmK0 * (m + n*tan*(a*a/2 + (5 - t + 9*c + 4*c*c)*math.Pow(a, 4)/24 +
+		(61 - 58*t + t*t + 600*c - 330*ep2)*math.Pow(a, 6)/720))
+	if !north {
+		y += 10000000
+	}
+	return common.Point{x, y}
+}
+
+func utmToLonLat(p common.Point, zone int, north bool) common.Point {
+	e2 := wgs84F * (2 - wgs84F)
+	ep2 := e2 / (1 - e2)
+	x := p.X - 500000
+	y := p.Y
+	if !north {
+		y -= 10000000
+	}
+
+	m := y / utmK0
+	mu := m / (wgs84A * (1 - e2/4 - 3*e2*e2/64 - 5*e2*e2*e2/256))
+	e1 := (1 - math.Sqrt(1 - e2)) / (1 + math.Sqrt(1 - e2))
+	lat1 := mu + (3*e1/2 - 27*math.Pow(e1, 3)/32)*math.Sin(2*mu) +
+		(21*e1*e1/16 - 55*math.Pow(e1, 4)/32)*math.Sin(4*mu) +
+		(151*math.Pow(e1, 3)/96)*math.Sin(6*mu) +
+		(1097*math.Pow(e1, 4)/512)*math.Sin(8*mu)
+
+	sin, cos, tan := math.Sin(lat1), math.Cos(lat1), math.Tan(lat1)
+	n := wgs84A / math.Sqrt(1 - e2*sin*sin)
+	t := tan * tan
+	c := ep2 * cos * cos
+	r := wgs84A * (1 - e2) / math.Pow(1 - e2*sin*sin, 1.5)
+	d := x / (n * utmK0)
+
+	lat := lat1 - (n*tan/r)*(d*d/2 - (5 + 3*t + 10*c - 4*c*c - 9*ep2)*math.Pow(d, 4)/24 +
+		(61 + 90*t + 298*c + 45*t*t - 252*ep2 - 3*c*c)*math.Pow(d, 6)/720)
+	dlon := (d - (1 + 2*t + c)*math.Pow(d, 3)/6 +
+		(5 - 2*c + 28*t - 3*c*c + 8*ep2 + 24*t*t)*math.Pow(d, 5)/120) / cos
+	return common.Point{
+		utmCentralMeridian(zone) + dlon*180/math.Pi,
+		lat * 180 / math.Pi,
+	}
+}
+
+// Maps orthoimage pixels to output coordinates, for exports.
+type Projection struct {
+	// EPSG code of the output coordinates, or empty for pixels.
+	CRS string
+	Project func(p common.Point) common.Point
+}
+
+var PixelProjection = Projection{"", func(p common.Point) common.Point { return p }}
+
+// Returns the projection to pixel, wgs84 (longitude, latitude) or utm (meters
+// in the georef's UTM zone) coordinates.
+func (g *Georef) Projection(coords string) (Projection, error) {
+	if coords == "pixel" {
+		return PixelProjection, nil
+	} else if g == nil {
+		return Projection{}, fmt.Errorf("%s coordinates need a georef", coords)
+	} else if coords == "wgs84" {
+		return Projection{"EPSG:4326", g.ToLonLat}, nil
+	} else if coords == "utm" {
+		zone, north := g.UTMZone()
+		crs := fmt.Sprintf("EPSG:%d", 32600 + zone)
+		if !north {
+			crs = fmt.Sprintf("EPSG:%d", 32700 + zone)
+		}
+		return Projection{crs, g.ToUTM}, nil
+	}
+	return Projection{}, fmt.Errorf("unknown coordinates %s; expected pixel, wgs84 or utm", coords)
+}
@@ -0,0 +1,83 @@
+package pipeline
+
+import (
+	"github.com/mitroadmaps/gomapinfer/common"
+
+	"math"
+	"testing"
+)
+
+func checkPoint(t *testing.T, name string, expected common.Point, got common.Point, tol float64) {
+	t.Helper()
+	if math.Abs(expected.X - got.X) > tol || math.Abs(expected.Y - got.Y) > tol {
+		t.Errorf("%s: expected %v, got %v", name, expected, got)
+	}
+}
+
+func TestUTM(t *testing.T) {
+	tests := []struct {
+		name string
+		lonLat common.Point
+		zone int
+		north bool
+		utm common.Point
+	}{
+		{"san diego", common.Point{-117.1611, 32.7157}, 11, true, common.Point{484902.6, 3619781.6}},
+		{"sydney", common.Point{151.2093, -33.8688}, 56, false, common.Point{334368.6, 6250948.3}},
+		{"central meridian", common.Point{-117, 0}, 11, true, common.Point{500000, 0}},
+	}
+	for _, test := range tests {
+		if zone, north := getUTMZone(test.lonLat); zone != test.zone || north != test.north {
+			t.Errorf("%s: expected zone %d north=%v, got %d north=%v", test.name, test.zone, test.north, zone, north)
+		}
+		utm := lonLatToUTM(test.lonLat, test.zone, test.north)
+		checkPoint(t, test.name, test.utm, utm, 0.1)
+		checkPoint(t, test.name + " inverse", test.lonLat, utmToLonLat(utm, test.zone, test.north), 1e-8)
+	}
+}
+
+func TestGeorefRoundTrip(t *testing.T) {
+	utm, err := NewUTMGeoref(common.Point{-117.15, 32.7}, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mercator, err := ParseGeoTransform("-13042000 0.6 0 3856000 0 -0.6", "EPSG:3857")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, g := range map[string]*Georef{"utm": utm, "mercator": mercator} {
+		for _, p := range []common.Point{{0, 0}, {1000, -4434}, {-2500.5, 12000.25}} {
+			checkPoint(t, name, p, g.FromLonLat(g.ToLonLat(p)), 1e-4)
+		}
+	}
+	// 0.02 degrees north is about 2217m, or 4434 half-meter pixels up, and a
+	// few pixels right since grid north is not true north away from the
+	// central meridian
+	checkPoint(t, "utm scale", common.Point{6.3, -4434.2}, utm.FromLonLat(common.Point{-117.15, 32.72}), 0.1)
+	if mpp := utm.MetersPerPixel(); math.Abs(mpp - 0.5) > 1e-6 {
+		t.Errorf("expected 0.5 meters per pixel, got %v", mpp)
+	}
+}
+
+// World files give the center of the top-left pixel, and geotransforms its
+// corner.
+func TestParseWorldFile(t *testing.T) {
+	g, err := ParseWorldFile("0.5\n0\n0\n-0.5\n484902.75\n3619781.25\n", "EPSG:32611")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [6]float64{484902.5, 0.5, 0, 3619781.5, 0, -0.5}
+	if g.Transform != expected {
+		t.Fatalf("expected transform %v, got %v", expected, g.Transform)
+	}
+	checkPoint(t, "corner", common.Point{484902.5, 3619781.5}, g.PixelToCRS(common.Point{0, 0}), 1e-9)
+	checkPoint(t, "center", common.Point{484902.75, 3619781.25}, g.PixelToCRS(common.Point{0.5, 0.5}), 1e-9)
+	checkPoint(t, "lonlat", common.Point{-117.1611, 32.7157}, g.ToLonLat(common.Point{0.2, 0.2}), 1e-5)
+
+	if _, err := ParseWorldFile("0.5 0 0 -0.5 1", "EPSG:32611"); err == nil {
+		t.Error("expected error for five numbers")
+	}
+	if _, err := ParseWorldFile("0.5 0 0 -0.5 1 2", "EPSG:2229"); err == nil {
+		t.Error("expected error for unsupported CRS")
+	}
+}
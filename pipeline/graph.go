@@ -0,0 +1,148 @@
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An operator in the pipeline graph, with its current status.
+type GraphNode struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Operands map[string]string `json:"operands,omitempty"`
+	Parents []string `json:"parents"`
+
+	// Grid of matrix dataframes.
+	Grid *Grid `json:"grid,omitempty"`
+
+	RerunTime time.Time `json:"rerun_time"`
+
+	// Number of detections, sequences or matrix data in the dataframe.
+	Rows int `json:"rows"`
+
+	// Seconds taken by the last Execute, or nil if the operator has not
+	// executed since the column was added.
+	LastDuration *float64 `json:"last_duration"`
+}
+
+// Operators in dependency order.
+type Graph []GraphNode
+
+// Returns the last_duration column of the dataframes table.
+func (ctx *Context) getLastDurations() (map[string]float64, error) {
+	rows, err := ctx.DB.Query("SELECT name, last_duration FROM dataframes")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	durations := make(map[string]float64)
+	for rows.Next() {
+		var name string
+		var duration *float64
+		if err := rows.Scan(&name, &duration); err != nil {
+			return nil, err
+		}
+		if duration != nil {
+			durations[name] = *duration
+		}
+	}
+	return durations, rows.Err()
+}
+
+// Count the output of the operator.
+func (op *Operator) countRows() (int, error) {
+	switch OperatorSchemas[op.Type].Output {
+	case DetectionKind:
+		return op.Context.Driver.CountDetections(op.Name)
+	case SequenceKind:
+		return op.Context.Driver.CountSequences(op.Name)
+	case MatrixKind:
+		return op.Context.Driver.CountMatrixAfter(op.Name, time.Time{})
+	}
+	return 0, nil
+}
+
+// Get the operator graph annotated with rerun times from the pipeline, and
+// row counts and durations from the database.
+func (pipeline Pipeline) GetGraph() (Graph, error) {
+	durations, err := pipeline.Context().getLastDurations()
+	if err != nil {
+		return nil, err
+	}
+	var graph Graph
+	for _, op := range pipeline.sortedOperators() {
+		node := GraphNode{
+			Name: op.Name,
+			Type: op.Type,
+			Operands: op.Operands,
+			Parents: []string{},
+			RerunTime: op.RerunTime,
+		}
+		for _, parent := range op.Parents {
+			node.Parents = append(node.Parents, parent.Name)
+		}
+		if OperatorSchemas[op.Type].Output == MatrixKind {
+			grid := op.Grid
+			node.Grid = &grid
+		}
+		node.Rows, err = op.countRows()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", op.Name, err)
+		}
+		if duration, ok := durations[op.Name]; ok {
+			node.LastDuration = &duration
+		}
+		graph = append(graph, node)
+	}
+	return graph, nil
+}
+
+// Quote a string for DOT, with newlines as line breaks in labels.
+func dotQuote(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "\"", "\\\"", -1)
+	s = strings.Replace(s, "\n", "\\n", -1)
+	return "\"" + s + "\""
+}
+
+func (node GraphNode) label() string {
+	lines := []string{node.Name, node.Type}
+	var keys []string
+	for k := range node.Operands {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		lines = append(lines, k + "=" + node.Operands[k])
+	}
+	if node.Grid != nil {
+		lines = append(lines, fmt.Sprintf("grid %v", *node.Grid))
+	}
+	lines = append(lines, "rerun " + node.RerunTime.Format(planTimeFormat))
+	lines = append(lines, fmt.Sprintf("%d rows", node.Rows))
+	if node.LastDuration != nil {
+		lines = append(lines, fmt.Sprintf("last run %.1fs", *node.LastDuration))
+	} else {
+		lines = append(lines, "never run")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Render the graph in Graphviz DOT format, e.g. for `dot -Tpng`.
+func (graph Graph) DOT() string {
+	var lines []string
+	lines = append(lines, "digraph pipeline {")
+	lines = append(lines, "\tnode [shape=box];")
+	for _, node := range graph {
+		lines = append(lines, fmt.Sprintf("\t%s [label=%s];", dotQuote(node.Name), dotQuote(node.label())))
+	}
+	for _, node := range graph {
+		for _, parent := range node.Parents {
+			lines = append(lines, fmt.Sprintf("\t%s -> %s;", dotQuote(parent), dotQuote(node.Name)))
+		}
+	}
+	lines = append(lines, "}")
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,210 @@
+package pipeline
+
+import (
+	"github.com/mitroadmaps/gomapinfer/common"
+
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Default side length of matrix cells, in orthoimage pixels.
+const MatrixGridSize float64 = 512
+
+// Grid of the cells of a matrix dataframe.
+// Cell (i, j) covers Origin + [i*Size, (i+1)*Size) x [j*Size, (j+1)*Size).
+type Grid struct {
+	Size float64 `json:"size"`
+	Origin common.Point `json:"origin"`
+}
+
+var DefaultGrid = Grid{MatrixGridSize, common.Point{0, 0}}
+
+func (grid Grid) String() string {
+	return fmt.Sprintf("%v at (%v, %v)", grid.Size, grid.Origin.X, grid.Origin.Y)
+}
+
+// Operands of matrix operators that define their own grid, e.g.
+// grid_size=64,grid_origin=100 -200.
+// Other matrix operators use the grid of their matrix parents.
+var GridOperands = []OperandSpec{
+	{Name: "grid_size", Type: FloatOperand, Default: fmt.Sprintf("%v", MatrixGridSize)},
+	{Name: "grid_origin", Type: PointOperand, Default: "0 0"},
+}
+
+func (schema OperatorSchema) hasGridOperands() bool {
+	for _, spec := range schema.Operands {
+		if spec.Name == "grid_size" {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses a point operand "x y".
+func ParsePoint(s string) (common.Point, error) {
+	parts := strings.Fields(s)
+	if len(parts) != 2 {
+		return common.Point{}, fmt.Errorf("expected two numbers")
+	}
+	x, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return common.Point{}, err
+	}
+	y, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return common.Point{}, err
+	}
+	return common.Point{x, y}, nil
+}
+
+// Parses the grid operands, which must have defaults filled in.
+func parseGrid(operands map[string]string) (Grid, error) {
+	var grid Grid
+	var err error
+	grid.Size, err = strconv.ParseFloat(operands["grid_size"], 64)
+	if err != nil {
+		return grid, fmt.Errorf("grid_size: %v", err)
+	} else if grid.Size <= 0 {
+		return grid, fmt.Errorf("grid_size must be positive")
+	}
+	grid.Origin, err = ParsePoint(operands["grid_origin"])
+	if err != nil {
+		return grid, fmt.Errorf("grid_origin: %v", err)
+	}
+	return grid, nil
+}
+
+// Returns the grid of each matrix dataframe. Operators with GridOperands in
+// their schema take their grid from their operands, and other operators from
+// their matrix parents, which must all have the same grid.
+// The specs must not have cycles.
+func GetGrids(specs map[string]DataframeSpec) (map[string]Grid, error) {
+	grids := make(map[string]Grid)
+	var resolve func(name string) error
+	resolve = func(name string) error {
+		if _, ok := grids[name]; ok {
+			return nil
+		}
+		spec := specs[name]
+		schema := OperatorSchemas[spec.OpType]
+		if schema.Output != MatrixKind {
+			return nil
+		}
+		if schema.hasGridOperands() {
+			grid, err := parseGrid(schema.WithDefaults(spec.Operands))
+			if err != nil {
+				return fmt.Errorf("dataframe %s: %v", name, err)
+			}
+			grids[name] = grid
+			return nil
+		}
+		var parentName string
+		for i, kind := range schema.Parents {
+			if kind != MatrixKind || i >= len(spec.Parents) {
+				continue
+			}
+			if err := resolve(spec.Parents[i]); err != nil {
+				return err
+			}
+			if parentName == "" {
+				parentName = spec.Parents[i]
+				grids[name] = grids[parentName]
+			} else if grids[spec.Parents[i]] != grids[parentName] {
+				return fmt.Errorf(
+					"dataframe %s: parents %s (grid %v) and %s (grid %v) have different grids",
+					name, parentName, grids[parentName], spec.Parents[i], grids[spec.Parents[i]],
+				)
+			}
+		}
+		if parentName == "" {
+			grids[name] = DefaultGrid
+		}
+		return nil
+	}
+	for name := range specs {
+		if err := resolve(name); err != nil {
+			return nil, err
+		}
+	}
+	return grids, nil
+}
+
+// Returns the grid of a matrix dataframe in the dataframes table.
+func GetDataframeGrid(dataframe string) (Grid, error) {
+	return DefaultContext.GetDataframeGrid(dataframe)
+}
+
+func (ctx *Context) GetDataframeGrid(dataframe string) (Grid, error) {
+	specs, err := ctx.GetDataframeSpecs()
+	if err != nil {
+		return Grid{}, err
+	}
+	grids, err := GetGrids(specs)
+	if err != nil {
+		return Grid{}, err
+	}
+	grid, ok := grids[dataframe]
+	if !ok {
+		return Grid{}, fmt.Errorf("%s is not a matrix dataframe", dataframe)
+	}
+	return grid, nil
+}
+
+func ToCell(p common.Point, grid Grid) [2]int {
+	p = p.Sub(grid.Origin)
+	return [2]int{
+		int(math.Floor(p.X / grid.Size)),
+		int(math.Floor(p.Y / grid.Size)),
+	}
+}
+
+func GetCellRect(cell [2]int, grid Grid) common.Rectangle {
+	cellPoint := common.Point{float64(cell[0]), float64(cell[1])}
+	return common.Rectangle{
+		cellPoint.Scale(grid.Size).Add(grid.Origin),
+		cellPoint.Add(common.Point{1, 1}).Scale(grid.Size).Add(grid.Origin),
+	}
+}
+
+func IsCellInFrame(cell [2]int, frame *Frame, grid Grid) bool {
+	cellRect := GetCellRect(cell, grid)
+	for _, p := range cellRect.ToPolygon() {
+		if !frame.Bounds.Contains(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns map from cells visible in current frame to the distances from
+// those cells to the frame boundaries
+func GetCellsInFrame(frame *Frame, grid Grid) map[[2]int]float64 {
+	frameRect := frame.Bounds.Bounds()
+	startCell := ToCell(frameRect.Min, grid)
+	endCell := ToCell(frameRect.Max, grid)
+	frameCells := make(map[[2]int]float64)
+	processCell := func(cell [2]int) {
+		if !IsCellInFrame(cell, frame, grid) {
+			return
+		}
+		cellRect := GetCellRect(cell, grid)
+		var worstDistance float64 = -1
+		for _, cellSegment := range cellRect.ToPolygon().Segments() {
+			for _, frameSegment := range frame.Bounds.Segments() {
+				d := cellSegment.DistanceToSegment(frameSegment)
+				if worstDistance == -1 || d < worstDistance {
+					worstDistance = d
+				}
+			}
+		}
+		frameCells[cell] = worstDistance
+	}
+	for i := startCell[0]; i <= endCell[0]; i++ {
+		for j := startCell[1]; j <= endCell[1]; j++ {
+			processCell([2]int{i, j})
+		}
+	}
+	return frameCells
+}
@@ -13,7 +13,7 @@ type MatrixData struct {
 	Metadata string
 }
 
-func AddMatrixData(dataframe string, i int, j int, val int, metadata string, t time.Time) *MatrixData {
+func (ctx *Context) AddMatrixData(dataframe string, i int, j int, val int, metadata string, t time.Time) (*MatrixData, error) {
 	md := &MatrixData{
 		Time: t,
 		I: i,
@@ -21,10 +21,20 @@ func AddMatrixData(dataframe string, i int, j int, val int, metadata string, t t
 		Val: val,
 		Metadata: metadata,
 	}
-	driver.AddMatrixData(dataframe, md)
-	return md
+	if err := ctx.Driver.AddMatrixData(dataframe, md); err != nil {
+		return nil, err
+	}
+	return md, nil
+}
+
+func (ctx *Context) LoadMatrix(dataframe string) (map[[2]int]*MatrixData, error) {
+	return ctx.Driver.LoadMatrixBefore(dataframe, time.Now().Add(9999*time.Hour))
+}
+
+func AddMatrixData(dataframe string, i int, j int, val int, metadata string, t time.Time) (*MatrixData, error) {
+	return DefaultContext.AddMatrixData(dataframe, i, j, val, metadata, t)
 }
 
-func LoadMatrix(dataframe string) map[[2]int]*MatrixData {
-	return driver.LoadMatrixBefore(dataframe, time.Now().Add(9999*time.Hour))
+func LoadMatrix(dataframe string) (map[[2]int]*MatrixData, error) {
+	return DefaultContext.LoadMatrix(dataframe)
 }
@@ -0,0 +1,161 @@
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Metrics recorded in the operator_metrics table each time an operator
+// executes from its rerun time. Steps of the streaming daemon that only feed
+// new frames into an operator are not recorded.
+//
+// Rows are detections, sequences or matrix data depending on the operator's
+// output. Rows deleted are counted when InitFunc discards output after the
+// rerun time, and rows emitted when Func adds output.
+type OperatorMetrics struct {
+	ID int `json:"id"`
+	Dataframe string `json:"dataframe"`
+	StartedAt time.Time `json:"started_at"`
+
+	WallTime time.Duration `json:"wall_time"`
+	InitTime time.Duration `json:"init_time"`
+
+	Frames int `json:"frames"`
+	ParentRows int `json:"parent_rows"`
+	RowsEmitted int `json:"rows_emitted"`
+	RowsDeleted int `json:"rows_deleted"`
+}
+
+func (m OperatorMetrics) String() string {
+	return fmt.Sprintf(
+		"%s: %v (init %v), %d frames, %d parent rows, %d rows emitted, %d rows deleted",
+		m.Dataframe, m.WallTime, m.InitTime, m.Frames, m.ParentRows, m.RowsEmitted, m.RowsDeleted,
+	)
+}
+
+// Counts the rows in parent data for one frame.
+func (pd ParentData) count() int {
+	var n int
+	for _, detections := range pd.Detections {
+		n += len(detections)
+	}
+	for _, sequences := range pd.Sequences {
+		n += len(sequences)
+	}
+	for _, matrixData := range pd.MatrixData {
+		n += len(matrixData)
+	}
+	return n
+}
+
+// Write the metrics in the operator's transaction, and set last_duration in
+// the dataframes table for the operator graph.
+func (m *OperatorMetrics) save(ctx *Context) error {
+	opDB := ctx.dbFor(m.Dataframe)
+	id, err := opDB.ExecInsert(
+		"INSERT INTO operator_metrics (dataframe, started_at, wall_time, init_time, frames, parent_rows, rows_emitted, rows_deleted) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		m.Dataframe, m.StartedAt, m.WallTime.Seconds(), m.InitTime.Seconds(), m.Frames, m.ParentRows, m.RowsEmitted, m.RowsDeleted,
+	)
+	if err != nil {
+		return err
+	}
+	m.ID = id
+	_, err = opDB.Exec("UPDATE dataframes SET last_duration = ? WHERE name = ?", m.WallTime.Seconds(), m.Dataframe)
+	return err
+}
+
+func (ctx *Context) queryMetrics(q string, args ...interface{}) ([]*OperatorMetrics, error) {
+	rows, err := ctx.DB.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var metrics []*OperatorMetrics
+	for rows.Next() {
+		var m OperatorMetrics
+		var wallTime, initTime float64
+		if err := rows.Scan(&m.ID, &m.Dataframe, &m.StartedAt, &wallTime, &initTime, &m.Frames, &m.ParentRows, &m.RowsEmitted, &m.RowsDeleted); err != nil {
+			return nil, err
+		}
+		m.WallTime = time.Duration(wallTime * float64(time.Second))
+		m.InitTime = time.Duration(initTime * float64(time.Second))
+		metrics = append(metrics, &m)
+	}
+	return metrics, rows.Err()
+}
+
+const metricsColumns = "id, dataframe, started_at, wall_time, init_time, frames, parent_rows, rows_emitted, rows_deleted"
+
+// Returns the metrics of the dataframe's most recent executions, newest first.
+// If limit is zero, all executions are returned.
+func GetMetrics(dataframe string, limit int) ([]*OperatorMetrics, error) {
+	return DefaultContext.GetMetrics(dataframe, limit)
+}
+
+func (ctx *Context) GetMetrics(dataframe string, limit int) ([]*OperatorMetrics, error) {
+	q := "SELECT " + metricsColumns + " FROM operator_metrics WHERE dataframe = ? ORDER BY id DESC"
+	if limit > 0 {
+		return ctx.queryMetrics(q + " LIMIT ?", dataframe, limit)
+	}
+	return ctx.queryMetrics(q, dataframe)
+}
+
+// Returns the metrics of the most recent execution of each dataframe.
+func GetLatestMetrics() (map[string]*OperatorMetrics, error) {
+	return DefaultContext.GetLatestMetrics()
+}
+
+func (ctx *Context) GetLatestMetrics() (map[string]*OperatorMetrics, error) {
+	metrics, err := ctx.queryMetrics(
+		"SELECT " + metricsColumns + " FROM operator_metrics WHERE id IN (SELECT MAX(id) FROM operator_metrics GROUP BY dataframe)",
+	)
+	if err != nil {
+		return nil, err
+	}
+	latest := make(map[string]*OperatorMetrics)
+	for _, m := range metrics {
+		latest[m.Dataframe] = m
+	}
+	return latest, nil
+}
+
+// Total wall time and executions of an operator type.
+type TypeMetrics struct {
+	Type string `json:"type"`
+	Executions int `json:"executions"`
+	WallTime time.Duration `json:"wall_time"`
+	Frames int `json:"frames"`
+}
+
+// Sum metrics recorded since the specified time by operator type, using the
+// op_type of each dataframe in the pipeline. Sorted by wall time, longest
+// first.
+func (pipeline Pipeline) GetTypeMetrics(since time.Time) ([]TypeMetrics, error) {
+	metrics, err := pipeline.Context().queryMetrics("SELECT " + metricsColumns + " FROM operator_metrics WHERE started_at >= ?", since)
+	if err != nil {
+		return nil, err
+	}
+	byType := make(map[string]*TypeMetrics)
+	for _, m := range metrics {
+		op := pipeline[m.Dataframe]
+		if op == nil {
+			continue
+		}
+		if byType[op.Type] == nil {
+			byType[op.Type] = &TypeMetrics{Type: op.Type}
+		}
+		t := byType[op.Type]
+		t.Executions++
+		t.WallTime += m.WallTime
+		t.Frames += m.Frames
+	}
+	var types []TypeMetrics
+	for _, t := range byType {
+		types = append(types, *t)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return types[i].WallTime > types[j].WallTime
+	})
+	return types, nil
+}
@@ -0,0 +1,66 @@
+package pipeline
+
+import (
+	"strconv"
+	"strings"
+)
+
+var DetFilterSchema = OperatorSchema{
+	Output: DetectionKind,
+	Parents: []DataKind{DetectionKind},
+	Operands: []OperandSpec{
+		{Name: "class", Type: StringOperand},
+		{Name: "min_confidence", Type: FloatOperand, Default: "0"},
+		{Name: "min_area", Type: FloatOperand, Default: "0"},
+		{Name: "max_area", Type: FloatOperand, Default: "0"},
+	},
+}
+
+// Keep detections whose class is one of the space-separated classes (any
+// class if empty), with confidence at least min_confidence, and with bounding
+// box area in [min_area, max_area] orthoimage pixels (no maximum if zero).
+// For example, one detector pass can feed both queries:
+//  cars = det_filter(detections, class="car truck", min_confidence=0.5)
+//  people = det_filter(detections, class=person)
+func MakeDetFilterOperator(op *Operator, operands map[string]string) {
+	classes := make(map[string]bool)
+	for _, class := range strings.Fields(operands["class"]) {
+		classes[class] = true
+	}
+	minConfidence, _ := strconv.ParseFloat(operands["min_confidence"], 64)
+	minArea, _ := strconv.ParseFloat(operands["min_area"], 64)
+	maxArea, _ := strconv.ParseFloat(operands["max_area"], 64)
+
+	evaluate := func(detection *Detection) bool {
+		if len(classes) > 0 && !classes[detection.Class] {
+			return false
+		} else if detection.Confidence < minConfidence {
+			return false
+		}
+		area := detection.Polygon.Bounds().Area()
+		return area >= minArea && (maxArea == 0 || area <= maxArea)
+	}
+
+	op.InitFunc = func(frame *Frame) error {
+		// our detections have the same time as the parent detections
+		if err := op.Context.Driver.UndoDetections(op.Name, frame.Time); err != nil {
+			return err
+		}
+		op.updateChildRerunTime(frame.Time)
+		return nil
+	}
+
+	op.DetFunc = func(frame *Frame, detections []*Detection) error {
+		for _, detection := range detections {
+			if !evaluate(detection) {
+				continue
+			}
+			if _, err := op.Context.Driver.CopyDetection(op.Name, detection); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	op.Loader = op.DetectionLoader
+}
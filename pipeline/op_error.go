@@ -1,5 +1,10 @@
 package pipeline
 
+var ErrorSchema = OperatorSchema{
+	Output: MatrixKind,
+	Parents: []DataKind{MatrixKind},
+}
+
 // Error rate operator should emit an error rate every error interval.
 // So at those intervals, we simply increment matrix value (error) by the
 // specified rate.
@@ -7,18 +12,29 @@ package pipeline
 func MakeErrorOperator(op *Operator, operands map[string]string) {
 	var matrix map[[2]int]*MatrixData
 
-	op.InitFunc = func(frame *Frame) {
-		driver.DeleteMatrixAfter(op.Name, frame.Time)
-		matrix = LoadMatrix(op.Name)
+	op.InitFunc = func(frame *Frame) error {
+		if err := op.Context.Driver.DeleteMatrixAfter(op.Name, frame.Time); err != nil {
+			return err
+		}
+		var err error
+		matrix, err = op.Context.LoadMatrix(op.Name)
+		if err != nil {
+			return err
+		}
 		op.updateChildRerunTime(frame.Time)
+		return nil
 	}
 
-	op.MatFunc = func(frame *Frame, matrixData []*MatrixData) {
-		for cell := range GetCellsInFrame(frame) {
+	op.MatFunc = func(frame *Frame, matrixData []*MatrixData) error {
+		for cell := range GetCellsInFrame(frame, op.Grid) {
 			if matrix[cell] == nil || matrix[cell].Val == 0 {
 				continue
 			}
-			matrix[cell] = AddMatrixData(op.Name, cell[0], cell[1], 0, "", frame.Time)
+			md, err := op.Context.AddMatrixData(op.Name, cell[0], cell[1], 0, "", frame.Time)
+			if err != nil {
+				return err
+			}
+			matrix[cell] = md
 		}
 
 		for _, parentMD := range matrixData {
@@ -31,11 +47,16 @@ func MakeErrorOperator(op *Operator, operands map[string]string) {
 			}
 			val += parentMD.Val
 			// but zero if visible
-			if IsCellInFrame(cell, frame, MatrixGridSize) {
+			if IsCellInFrame(cell, frame, op.Grid) {
 				val = 0
 			}
-			matrix[cell] = AddMatrixData(op.Name, cell[0], cell[1], val, "", frame.Time)
+			md, err := op.Context.AddMatrixData(op.Name, cell[0], cell[1], val, "", frame.Time)
+			if err != nil {
+				return err
+			}
+			matrix[cell] = md
 		}
+		return nil
 	}
 
 	op.Loader = op.MatrixLoader
@@ -2,16 +2,17 @@ package pipeline
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 )
 
+// Sequence metrics, see trajectory.go for units. Metrics ending in _m, _m2
+// and _mps are in meters, square meters and meters/sec, and need the GSD (see
+// units.go). Here they use the GSD of DefaultContext, operators use the GSD
+// of their own context (see getFilterFunc).
 type FilterFunc func(*Sequence) float64
-var FilterFuncs = map[string]FilterFunc{
-	"displacement": func(seq *Sequence) float64 {
-		start := seq.Members[0].Detection.Polygon.Bounds().Center()
-		end := seq.Members[len(seq.Members)-1].Detection.Polygon.Bounds().Center()
-		return start.Distance(end)
-	},
+var FilterFuncs = withMeterFilterFuncs(map[string]FilterFunc{
+	"displacement": (*Sequence).Displacement,
 	"length": func(seq *Sequence) float64 {
 		return float64(len(seq.Members))
 	},
@@ -20,57 +21,147 @@ var FilterFuncs = map[string]FilterFunc{
 		end := seq.Members[len(seq.Members)-1].Detection.Time
 		return end.Sub(start).Seconds()
 	},
+	"path_length": (*Sequence).PathLength,
+	"mean_speed": (*Sequence).MeanSpeed,
+	"max_speed": (*Sequence).MaxSpeed,
+	"mean_heading": (*Sequence).MeanHeading,
+	"heading_change": (*Sequence).HeadingChange,
+	"box_area": (*Sequence).BoxArea,
+	"box_aspect": (*Sequence).BoxAspectRatio,
+	"max_gap": (*Sequence).MaxGap,
+	"straightness": (*Sequence).Straightness,
+	"stationary": (*Sequence).StationaryPercent,
+	"confidence": (*Sequence).MeanConfidence,
+})
+
+var meterFilterFuncs = map[string]meterMetric{
+	"displacement_m": inMeters((*Sequence).Displacement, 1),
+	"path_length_m": inMeters((*Sequence).PathLength, 1),
+	"mean_speed_mps": inMeters((*Sequence).MeanSpeed, 1),
+	"max_speed_mps": inMeters((*Sequence).MaxSpeed, 1),
+	"box_area_m2": inMeters((*Sequence).BoxArea, 2),
+	// percentage like stationary, with StationarySpeedMPS as the threshold
+	"stationary_mps": func(seq *Sequence, gsd float64) float64 {
+		return seq.stationaryPercent(StationarySpeedMPS / gsd)
+	},
+}
+
+func withMeterFilterFuncs(funcs map[string]FilterFunc) map[string]FilterFunc {
+	for name, m := range meterFilterFuncs {
+		funcs[name] = DefaultContext.withGSD(m)
+	}
+	return funcs
+}
+
+// Sorted names of FilterFuncs.
+func filterFuncNames() []string {
+	var names []string
+	for name := range FilterFuncs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var FilterSchema = OperatorSchema{
+	Output: SequenceKind,
+	Parents: []DataKind{SequenceKind},
+	Operands: []OperandSpec{
+		{Name: "expr", Type: FilterOperand},
+		{Name: "left", Type: StringOperand, Allowed: filterFuncNames()},
+		{Name: "op", Type: StringOperand, Allowed: []string{"<", ">", "<=", ">=", "==", "!="}},
+		{Name: "right", Type: FloatOperand},
+	},
+	Check: func(operands map[string]string) error {
+		_, hasExpr := operands["expr"]
+		for _, k := range []string{"left", "op", "right"} {
+			_, ok := operands[k]
+			if hasExpr && ok {
+				return fmt.Errorf("operand %s cannot be used with expr", k)
+			} else if !hasExpr && !ok {
+				return fmt.Errorf("missing required operand %s (or expr)", k)
+			}
+		}
+		return nil
+	},
 }
 
+// Keep parent sequences that satisfy expr, a filter expression (see
+// ParseFilterExpr), or the single comparison "left op right".
 func MakeFilterOperator(op *Operator, operands map[string]string) {
-	filterFunc := FilterFuncs[operands["left"]]
-	val, err := strconv.ParseFloat(operands["right"], 64)
+	expr := operands["expr"]
+	if expr == "" {
+		expr = fmt.Sprintf("%s %s %s", operands["left"], operands["op"], operands["right"])
+	}
+	evaluate, usesMeters, err := parseFilterExpr(expr, op.Context)
 	if err != nil {
 		panic(err)
 	}
-	evaluate := func(seq *Sequence) bool {
-		v1 := filterFunc(seq)
-		if operands["op"] == "<" && v1 < val {
-			return true
-		} else if operands["op"] == ">" && v1 > val {
-			return true
-		}
-		return false
-	}
 
 	// map from parent sequence ID to our sequence
-	sequences := make(map[int]*Sequence)
-	op.InitFunc = func(frame *Frame) {
+	var sequences map[int]*Sequence
+	op.InitFunc = func(frame *Frame) error {
+		// rebuilt from our dataframe, since a rolled back or rerun execution
+		// may have added sequences that are no longer there
+		sequences = make(map[int]*Sequence)
+
+		if usesMeters {
+			if err := op.Context.requireGSD(); err != nil {
+				return err
+			}
+		}
+
 		// for filter sequences: seq.time = seq.terminated_at (if not null) = member.time for all members
 		// so because the times are the same, we can simply delete all rows with time >= rerun-time
-		driver.UndoSequences(op.Name, frame.Time)
+		if err := op.Context.Driver.UndoSequences(op.Name, frame.Time); err != nil {
+			return err
+		}
 
-		for _, seq := range GetUnterminatedSequences(op.Name) {
-			parentID, _ := strconv.Atoi(seq.GetMetadata()[0])
+		unterminated, err := op.Context.Driver.GetUnterminatedSequences(op.Name)
+		if err != nil {
+			return err
+		}
+		for _, seq := range unterminated {
+			metadata, err := seq.GetMetadata()
+			if err != nil {
+				return err
+			}
+			parentID, _ := strconv.Atoi(metadata[0])
 			sequences[parentID] = seq
 		}
 
 		op.updateChildRerunTime(frame.Time)
+		return nil
 	}
 
-	op.SeqFunc = func(frame *Frame, seqs []*Sequence) {
+	op.SeqFunc = func(frame *Frame, seqs []*Sequence) error {
 		for _, seq := range seqs {
 			//if sequences[seq.ID] != nil || seq.Terminated == nil || !evaluate(seq) {
 			if sequences[seq.ID] != nil || !evaluate(seq) {
 				continue
 			}
-			mySeq := NewSequence(op.Name, seq.Time)
-			mySeq.AddMetadata(fmt.Sprintf("%d", seq.ID), seq.Time)
+			mySeq, err := op.Context.Driver.AddSequence(op.Name, seq.Time)
+			if err != nil {
+				return err
+			}
+			if err := mySeq.AddMetadata(fmt.Sprintf("%d", seq.ID), seq.Time); err != nil {
+				return err
+			}
 			for _, member := range seq.Members {
-				mySeq.AddMember(member.Detection, seq.Time)
+				if err := mySeq.AddMember(member.Detection, seq.Time); err != nil {
+					return err
+				}
 			}
 			// TODO: maybe we should terminate at last member's time?
 			// otherwise when rerunning, parent may give us sequences which
 			// we already marked terminated, but we missed them when
 			// loading unterminated sequences...
-			mySeq.Terminate(seq.Time)
+			if err := mySeq.Terminate(seq.Time); err != nil {
+				return err
+			}
 			sequences[seq.ID] = mySeq
 		}
+		return nil
 	}
 
 	op.Loader = op.SequenceLoader
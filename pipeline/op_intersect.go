@@ -22,7 +22,13 @@ For sequences:
 - Maintain that state so we don't need to re-evaluate later.
 */
 
-// TODO: we should be able to have different matrix grid size for each matrix, currently we assume they all the same
+var IntersectSchema = OperatorSchema{
+	Output: SequenceKind,
+	Parents: []DataKind{SequenceKind, MatrixKind},
+	Operands: []OperandSpec{
+		{Name: "mode", Type: StringOperand, Default: "all", Allowed: []string{"all", "any"}},
+	},
+}
 
 // Filters for sequences that intersect with an image.
 // Filtering can be either all detections in the sequence intersect, or any intersect.
@@ -31,40 +37,58 @@ func MakeIntersectOperator(op *Operator, operands map[string]string) {
 	if operands["mode"] == "any" {
 		mode = "any"
 	}
-	matrix := make(map[[2]int]int)
+	var matrix map[[2]int]int
 
-	getMatrixVal := func(cell [2]int, t time.Time) int {
+	getMatrixVal := func(cell [2]int, t time.Time) (int, error) {
 		val, ok := matrix[cell]
 		if ok {
-			return val
+			return val, nil
 		}
-		md := driver.GetMatrixDataBefore(op.Parents[1].Name, cell[0], cell[1], t)
-		if md == nil {
+		md, err := op.Context.Driver.GetMatrixDataBefore(op.Parents[1].Name, cell[0], cell[1], t)
+		if err != nil {
+			return 0, err
+		} else if md == nil {
 			matrix[cell] = 0
 		} else {
 			matrix[cell] = md.Val
 		}
-		return matrix[cell]
+		return matrix[cell], nil
 	}
 
 	// map from parent sequence ID to our sequence
-	sequences := make(map[int]*Sequence)
+	var sequences map[int]*Sequence
 
 	// set of parent sequence IDs that failed the intersection test
-	rejectedSeqs := make(map[int]bool)
+	var rejectedSeqs map[int]bool
+
+	op.InitFunc = func(frame *Frame) error {
+		// reset state from a previous execution, which may have been rolled back
+		matrix = make(map[[2]int]int)
+		sequences = make(map[int]*Sequence)
+		rejectedSeqs = make(map[int]bool)
 
-	op.InitFunc = func(frame *Frame) {
-		driver.UndoSequences(op.Name, frame.Time)
+		if err := op.Context.Driver.UndoSequences(op.Name, frame.Time); err != nil {
+			return err
+		}
 
-		for _, seq := range GetUnterminatedSequences(op.Name) {
-			parentID, _ := strconv.Atoi(seq.GetMetadata()[0])
+		unterminated, err := op.Context.Driver.GetUnterminatedSequences(op.Name)
+		if err != nil {
+			return err
+		}
+		for _, seq := range unterminated {
+			metadata, err := seq.GetMetadata()
+			if err != nil {
+				return err
+			}
+			parentID, _ := strconv.Atoi(metadata[0])
 			sequences[parentID] = seq
 		}
 
 		op.updateChildRerunTime(frame.Time)
+		return nil
 	}
 
-	op.Func = func(frame *Frame, pd ParentData) {
+	op.Func = func(frame *Frame, pd ParentData) error {
 		seqs := pd.Sequences[0]
 		matrixData := pd.MatrixData[0]
 
@@ -86,8 +110,11 @@ func MakeIntersectOperator(op *Operator, operands map[string]string) {
 			if mode == "all" {
 				okay = true
 				for _, member := range seq.Members {
-					cell := ToCell(member.Detection.Polygon.Bounds().Center(), MatrixGridSize)
-					val := getMatrixVal(cell, frame.Time)
+					cell := ToCell(member.Detection.Polygon.Bounds().Center(), op.Parents[1].Grid)
+					val, err := getMatrixVal(cell, frame.Time)
+					if err != nil {
+						return err
+					}
 					if val <= 0 {
 						okay = false
 						break
@@ -96,8 +123,11 @@ func MakeIntersectOperator(op *Operator, operands map[string]string) {
 			} else if mode == "any" {
 				okay = false
 				for _, member := range seq.Members {
-					cell := ToCell(member.Detection.Polygon.Bounds().Center(), MatrixGridSize)
-					val := getMatrixVal(cell, frame.Time)
+					cell := ToCell(member.Detection.Polygon.Bounds().Center(), op.Parents[1].Grid)
+					val, err := getMatrixVal(cell, frame.Time)
+					if err != nil {
+						return err
+					}
 					if val > 0 {
 						okay = true
 						break
@@ -110,14 +140,24 @@ func MakeIntersectOperator(op *Operator, operands map[string]string) {
 				continue
 			}
 
-			mySeq := NewSequence(op.Name, seq.Time)
-			mySeq.AddMetadata(fmt.Sprintf("%d", seq.ID), seq.Time)
+			mySeq, err := op.Context.Driver.AddSequence(op.Name, seq.Time)
+			if err != nil {
+				return err
+			}
+			if err := mySeq.AddMetadata(fmt.Sprintf("%d", seq.ID), seq.Time); err != nil {
+				return err
+			}
 			for _, member := range seq.Members {
-				mySeq.AddMember(member.Detection, seq.Time)
+				if err := mySeq.AddMember(member.Detection, seq.Time); err != nil {
+					return err
+				}
+			}
+			if err := mySeq.Terminate(mySeq.Members[len(mySeq.Members)-1].Detection.Time); err != nil {
+				return err
 			}
-			mySeq.Terminate(mySeq.Members[len(mySeq.Members)-1].Detection.Time)
 			sequences[seq.ID] = mySeq
 		}
+		return nil
 	}
 
 	op.Loader = op.SequenceLoader
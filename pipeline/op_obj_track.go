@@ -4,24 +4,37 @@ import (
 	goslgraph "github.com/cpmech/gosl/graph"
 
 	"fmt"
+	"math"
 	"time"
 )
 
+var ObjTrackSchema = OperatorSchema{
+	Output: SequenceKind,
+	Parents: []DataKind{DetectionKind},
+	Operands: []OperandSpec{
+		{Name: "mode", Type: StringOperand, Default: "iou", Allowed: []string{"iou"}},
+	},
+}
+
 func MakeObjTrackOperator(op *Operator, operands map[string]string) {
 	// unterminated sequences
 	var sequences map[int]*Sequence
 
-	op.InitFunc = func(frame *Frame) {
-		driver.UndoSequences(op.Name, frame.Time)
+	op.InitFunc = func(frame *Frame) error {
+		if err := op.Context.Driver.UndoSequences(op.Name, frame.Time); err != nil {
+			return err
+		}
 
-		sequences = GetUnterminatedSequences(op.Name)
+		var err error
+		sequences, err = op.Context.Driver.GetUnterminatedSequences(op.Name)
+		return err
 	}
 
 	// we rerun at the minimum of:
 	// * any frame processed from parent
 	// * start time of sequences that were modified
 
-	op.DetFunc = func(frame *Frame, detections []*Detection) {
+	op.DetFunc = func(frame *Frame, detections []*Detection) error {
 		op.updateChildRerunTime(frame.Time)
 		if Debug {
 			fmt.Printf("[%s] matching %d detections with %d active sequences\n", op.Name, len(detections), len(sequences))
@@ -33,14 +46,21 @@ func MakeObjTrackOperator(op *Operator, operands map[string]string) {
 		}
 		matches := hungarianMatcher(sequences, detectionMap)
 		for seqID, detection := range matches {
-			sequences[seqID].AddMember(detection, detection.Time)
+			if err := sequences[seqID].AddMember(detection, detection.Time); err != nil {
+				return err
+			}
 			op.updateChildRerunTime(sequences[seqID].Members[0].Detection.Time)
 		}
 
 		// new sequences for unmatched detections
 		for _, detection := range detectionMap {
-			seq := NewSequence(op.Name, detection.Time)
-			seq.AddMember(detection, detection.Time)
+			seq, err := op.Context.Driver.AddSequence(op.Name, detection.Time)
+			if err != nil {
+				return err
+			}
+			if err := seq.AddMember(detection, detection.Time); err != nil {
+				return err
+			}
 			sequences[seq.ID] = seq
 		}
 
@@ -50,10 +70,13 @@ func MakeObjTrackOperator(op *Operator, operands map[string]string) {
 			if frame.Time.Sub(lastTime) < 2*time.Second {
 				continue
 			}
-			seq.Terminate(frame.Time)
+			if err := seq.Terminate(frame.Time); err != nil {
+				return err
+			}
 			delete(sequences, seq.ID)
 			op.updateChildRerunTime(seq.Members[0].Detection.Time)
 		}
+		return nil
 	}
 
 	op.Loader = op.SequenceLoader
@@ -78,20 +101,23 @@ func hungarianMatcher(sequences map[int]*Sequence, detections map[int]*Detection
 	// create cost matrix for hungarian algorithm
 	// rows: existing sequences (sequenceList)
 	// cols: current detections (detectionList)
-	// values: 1-IoU if overlap is non-zero, or 10 otherwise
+	// values: 1-IoU*confidence if overlap is non-zero, or 10 otherwise
+	// so low-confidence detections need more overlap to extend a sequence
+	// detections never match sequences of a different class
 	costMatrix := make([][]float64, len(sequenceList))
 	for i, seq := range sequenceList {
 		costMatrix[i] = make([]float64, len(detectionList))
-		seqRect := seq.Members[len(seq.Members) - 1].Detection.Polygon.Bounds()
+		seqDetection := seq.Members[len(seq.Members) - 1].Detection
+		seqRect := seqDetection.Polygon.Bounds()
 
 		for j, detection := range detectionList {
 			curRect := detection.Polygon.Bounds()
 			iou := getIoU(seqRect, curRect)
 			var cost float64
-			if iou > 0.99 {
-				cost = 0.01
+			if detection.Class != seqDetection.Class {
+				cost = 10
 			} else if iou > 0.1 {
-				cost = 1 - iou
+				cost = math.Max(1 - iou*detection.Confidence, 0.01)
 			} else {
 				cost = 10
 			}
@@ -1,19 +1,34 @@
 package pipeline
 
+var OpenParkingSchema = OperatorSchema{
+	Output: MatrixKind,
+	Parents: []DataKind{MatrixKind, MatrixKind},
+}
+
 // Element-wise product of parent matrices.
 func MakeOpenParkingOperator(op *Operator, operands map[string]string) {
 	countParent := op.Parents[1]
 	var maxes map[[2]int]*MatrixData
 	var countMatrix map[[2]int]*MatrixData
 
-	op.InitFunc = func(frame *Frame) {
-		driver.DeleteMatrixAfter(op.Name, frame.Time)
-		maxes = LoadMatrix("maxes")
-		countMatrix = driver.LoadMatrixBefore(countParent.Name, frame.Time)
+	op.InitFunc = func(frame *Frame) error {
+		if err := op.Context.Driver.DeleteMatrixAfter(op.Name, frame.Time); err != nil {
+			return err
+		}
+		var err error
+		maxes, err = op.Context.LoadMatrix("maxes")
+		if err != nil {
+			return err
+		}
+		countMatrix, err = op.Context.Driver.LoadMatrixBefore(countParent.Name, frame.Time)
+		if err != nil {
+			return err
+		}
 		op.updateChildRerunTime(frame.Time)
+		return nil
 	}
 
-	op.Func = func(frame *Frame, pd ParentData) {
+	op.Func = func(frame *Frame, pd ParentData) error {
 		// update counts
 		for _, md := range pd.MatrixData[1] {
 			countMatrix[[2]int{md.I, md.J}] = md
@@ -37,8 +52,11 @@ func MakeOpenParkingOperator(op *Operator, operands map[string]string) {
 					val += 2
 				}
 			}
-			AddMatrixData(op.Name, cell[0], cell[1], val, "", frame.Time)
+			if _, err := op.Context.AddMatrixData(op.Name, cell[0], cell[1], val, "", frame.Time); err != nil {
+				return err
+			}
 		}
+		return nil
 	}
 
 	op.Loader = op.MatrixLoader
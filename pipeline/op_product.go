@@ -1,5 +1,13 @@
 package pipeline
 
+var ProductSchema = OperatorSchema{
+	Output: MatrixKind,
+	Parents: []DataKind{MatrixKind, MatrixKind},
+	Operands: []OperandSpec{
+		{Name: "invertright", Type: BoolOperand, Default: "no"},
+	},
+}
+
 // Element-wise product of parent matrices.
 func MakeProductOperator(op *Operator, operands map[string]string) {
 	invertRight := operands["invertright"] == "yes"
@@ -8,14 +16,24 @@ func MakeProductOperator(op *Operator, operands map[string]string) {
 	var matrix1 map[[2]int]*MatrixData
 	var matrix2 map[[2]int]*MatrixData
 
-	op.InitFunc = func(frame *Frame) {
-		driver.DeleteMatrixAfter(op.Name, frame.Time)
-		matrix1 = driver.LoadMatrixBefore(parent1.Name, frame.Time)
-		matrix2 = driver.LoadMatrixBefore(parent2.Name, frame.Time)
+	op.InitFunc = func(frame *Frame) error {
+		if err := op.Context.Driver.DeleteMatrixAfter(op.Name, frame.Time); err != nil {
+			return err
+		}
+		var err error
+		matrix1, err = op.Context.Driver.LoadMatrixBefore(parent1.Name, frame.Time)
+		if err != nil {
+			return err
+		}
+		matrix2, err = op.Context.Driver.LoadMatrixBefore(parent2.Name, frame.Time)
+		if err != nil {
+			return err
+		}
 		op.updateChildRerunTime(frame.Time)
+		return nil
 	}
 
-	op.Func = func(frame *Frame, pd ParentData) {
+	op.Func = func(frame *Frame, pd ParentData) error {
 		newCells := make(map[[2]int]bool)
 		for _, md := range pd.MatrixData[0] {
 			cell := [2]int{md.I, md.J}
@@ -36,8 +54,11 @@ func MakeProductOperator(op *Operator, operands map[string]string) {
 			if invertRight {
 				right = 1 - right
 			}
-			AddMatrixData(op.Name, cell[0], cell[1], left * right, "", frame.Time)
+			if _, err := op.Context.AddMatrixData(op.Name, cell[0], cell[1], left * right, "", frame.Time); err != nil {
+				return err
+			}
 		}
+		return nil
 	}
 
 	op.Loader = op.MatrixLoader
@@ -1,9 +1,28 @@
 package pipeline
 
+var RawDetectionSchema = OperatorSchema{
+	Output: DetectionKind,
+}
+
 func MakeDetectionOperator(op *Operator, operands map[string]string) {
 	op.Loader = op.DetectionLoader
 }
 
+var RawMatrixSchema = OperatorSchema{
+	Output: MatrixKind,
+	Operands: GridOperands,
+}
+
 func MakeMatrixOperator(op *Operator, operands map[string]string) {
 	op.Loader = op.MatrixLoader
 }
+
+// Sequences added outside the pipeline, e.g. ground truth tracks imported by
+// import-detections.go.
+var RawSequenceSchema = OperatorSchema{
+	Output: SequenceKind,
+}
+
+func MakeSequenceOperator(op *Operator, operands map[string]string) {
+	op.Loader = op.SequenceLoader
+}
@@ -0,0 +1,215 @@
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+)
+
+var RegridSchema = OperatorSchema{
+	Output: MatrixKind,
+	Parents: []DataKind{MatrixKind},
+	Operands: append([]OperandSpec{
+		{Name: "func", Type: StringOperand, Allowed: []string{"sum", "mean", "max", "split", "replicate"}},
+	}, GridOperands...),
+	CheckGrids: func(operands map[string]string, grid Grid, parentGrids []Grid) error {
+		_, err := getRegridFunc(operands, grid, parentGrids[0])
+		return err
+	},
+}
+
+// Returns the func operand, or its default, after checking that it matches
+// whether grid coarsens or refines the parent grid.
+func getRegridFunc(operands map[string]string, grid Grid, parentGrid Grid) (string, error) {
+	coarsen := grid.Size >= parentGrid.Size
+	funcName := operands["func"]
+	if funcName == "" && coarsen {
+		return "sum", nil
+	} else if funcName == "" {
+		return "split", nil
+	}
+	if coarsen && funcName != "sum" && funcName != "mean" && funcName != "max" {
+		return "", fmt.Errorf("func=%s refines a matrix, but grid %v is coarser than parent grid %v", funcName, grid, parentGrid)
+	} else if !coarsen && funcName != "split" && funcName != "replicate" {
+		return "", fmt.Errorf("func=%s coarsens a matrix, but grid %v is finer than parent grid %v", funcName, grid, parentGrid)
+	}
+	return funcName, nil
+}
+
+// Returns the quotient rounded down and the remainder in [0, b), for b > 0.
+func floorDivMod(a int, b int) (int, int) {
+	q, r := a / b, a % b
+	if r < 0 {
+		q--
+		r += b
+	}
+	return q, r
+}
+
+// Resample a matrix on the grid given by our grid_size and grid_origin.
+// When coarsening, each parent cell is assigned to the cell containing its
+// center, and func is one of:
+// * SUM (default) - sum of the parent cells
+// * MEAN - mean of the parent cells that have data
+// * MAX - maximum of the parent cells
+// When refining, each cell takes the parent cell containing its center, and
+// func is one of:
+// * SPLIT (default) - divide the parent value among its cells, so the total is kept
+// * REPLICATE - copy the parent value to each of its cells
+// We emit a cell whenever its value changes.
+func MakeRegridOperator(op *Operator, operands map[string]string) {
+	parentGrid := op.Parents[0].Grid
+	coarsen := op.Grid.Size >= parentGrid.Size
+	// checked in ValidateDataframes, but pipelines built without validation
+	// get the error when the operator executes
+	funcName, funcErr := getRegridFunc(operands, op.Grid, parentGrid)
+
+	// latest value of each parent cell, and of each of our cells
+	var parentVals map[[2]int]int
+	var vals map[[2]int]int
+
+	// for coarsening, the parent cells of each of our cells that have data
+	// for refining, our cells in each parent cell, in order
+	children := make(map[[2]int][][2]int)
+
+	getCell := func(parentCell [2]int) [2]int {
+		return ToCell(GetCellRect(parentCell, parentGrid).Center(), op.Grid)
+	}
+
+	getChildren := func(parentCell [2]int) [][2]int {
+		if children[parentCell] != nil {
+			return children[parentCell]
+		}
+		rect := GetCellRect(parentCell, parentGrid)
+		start := ToCell(rect.Min, op.Grid)
+		end := ToCell(rect.Max, op.Grid)
+		var cells [][2]int
+		for i := start[0]; i <= end[0]; i++ {
+			for j := start[1]; j <= end[1]; j++ {
+				cell := [2]int{i, j}
+				if ToCell(GetCellRect(cell, op.Grid).Center(), parentGrid) == parentCell {
+					cells = append(cells, cell)
+				}
+			}
+		}
+		children[parentCell] = cells
+		return cells
+	}
+
+	addParentCell := func(parentCell [2]int) {
+		cell := getCell(parentCell)
+		children[cell] = append(children[cell], parentCell)
+	}
+
+	// compute the value of a cell when coarsening
+	coarsenVal := func(cell [2]int) int {
+		var val int
+		for i, parentCell := range children[cell] {
+			parentVal := parentVals[parentCell]
+			if funcName == "max" {
+				if i == 0 || parentVal > val {
+					val = parentVal
+				}
+			} else {
+				val += parentVal
+			}
+		}
+		if funcName == "mean" && len(children[cell]) > 0 {
+			val /= len(children[cell])
+		}
+		return val
+	}
+
+	// compute the values of the cells in a parent cell when refining
+	refineVals := func(parentCell [2]int) map[[2]int]int {
+		cells := getChildren(parentCell)
+		parentVal := parentVals[parentCell]
+		cellVals := make(map[[2]int]int)
+		for i, cell := range cells {
+			if funcName == "replicate" {
+				cellVals[cell] = parentVal
+				continue
+			}
+			// give the remainder to the first cells so the total is kept,
+			// with floor division so this also holds for negative values
+			quotient, remainder := floorDivMod(parentVal, len(cells))
+			cellVals[cell] = quotient
+			if i < remainder {
+				cellVals[cell]++
+			}
+		}
+		return cellVals
+	}
+
+	op.InitFunc = func(frame *Frame) error {
+		if funcErr != nil {
+			return funcErr
+		}
+		if err := op.Context.Driver.DeleteMatrixAfter(op.Name, frame.Time); err != nil {
+			return err
+		}
+		parentMatrix, err := op.Context.Driver.LoadMatrixBefore(op.Parents[0].Name, frame.Time)
+		if err != nil {
+			return err
+		}
+		matrix, err := op.Context.Driver.LoadMatrixBefore(op.Name, frame.Time)
+		if err != nil {
+			return err
+		}
+		parentVals = make(map[[2]int]int)
+		for cell, md := range parentMatrix {
+			parentVals[cell] = md.Val
+		}
+		if coarsen {
+			children = make(map[[2]int][][2]int)
+			for cell := range parentVals {
+				addParentCell(cell)
+			}
+		}
+		vals = make(map[[2]int]int)
+		for cell, md := range matrix {
+			vals[cell] = md.Val
+		}
+		op.updateChildRerunTime(frame.Time)
+		return nil
+	}
+
+	op.MatFunc = func(frame *Frame, matrixData []*MatrixData) error {
+		// apply parent data in order, so the latest value of each cell wins
+		sort.Slice(matrixData, func(i, j int) bool {
+			if matrixData[i].Time.Equal(matrixData[j].Time) {
+				return matrixData[i].ID < matrixData[j].ID
+			}
+			return matrixData[i].Time.Before(matrixData[j].Time)
+		})
+
+		changed := make(map[[2]int]int)
+		for _, md := range matrixData {
+			parentCell := [2]int{md.I, md.J}
+			if _, ok := parentVals[parentCell]; !ok && coarsen {
+				addParentCell(parentCell)
+			}
+			parentVals[parentCell] = md.Val
+			if coarsen {
+				cell := getCell(parentCell)
+				changed[cell] = coarsenVal(cell)
+			} else {
+				for cell, val := range refineVals(parentCell) {
+					changed[cell] = val
+				}
+			}
+		}
+
+		for cell, val := range changed {
+			if prev, ok := vals[cell]; ok && prev == val {
+				continue
+			}
+			if _, err := op.Context.AddMatrixData(op.Name, cell[0], cell[1], val, "", frame.Time); err != nil {
+				return err
+			}
+			vals[cell] = val
+		}
+		return nil
+	}
+
+	op.Loader = op.MatrixLoader
+}
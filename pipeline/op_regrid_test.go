@@ -0,0 +1,76 @@
+package pipeline
+
+import (
+	"github.com/mitroadmaps/gomapinfer/common"
+
+	"testing"
+	"time"
+)
+
+func newTestRegridOperator(ctx *Context, gridSize float64, parentGridSize float64, operands map[string]string) *Operator {
+	parent := &Operator{
+		Name: "parent",
+		Context: ctx,
+		Grid: Grid{Size: parentGridSize},
+	}
+	op := &Operator{
+		Name: "regrid",
+		Context: ctx,
+		Parents: []*Operator{parent},
+		Grid: Grid{Size: gridSize},
+	}
+	MakeRegridOperator(op, operands)
+	return op
+}
+
+// Pipelines built without ValidateDataframes get the func error from InitFunc
+// rather than a panic.
+func TestRegridBadFunc(t *testing.T) {
+	ctx, _ := newTestContext(t)
+	op := newTestRegridOperator(ctx, 10, 50, map[string]string{"func": "sum"})
+	if err := op.InitFunc(&Frame{}); err == nil {
+		t.Fatal("expected error for func=sum on a finer grid")
+	}
+}
+
+// Split keeps the total of each parent cell, including negative values.
+func TestRegridSplit(t *testing.T) {
+	ctx, driver := newTestContext(t)
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	op := newTestRegridOperator(ctx, 10, 30, nil)
+	frame := &Frame{Time: base, Bounds: common.Polygon{{0, 0}, {30, 0}, {30, 30}, {0, 30}}}
+	if err := op.InitFunc(frame); err != nil {
+		t.Fatal(err)
+	}
+	for i, val := range []int{7, -7, -9, 0} {
+		frame := &Frame{Time: base.Add(time.Duration(i+1) * time.Second)}
+		md := &MatrixData{ID: i, Time: frame.Time, I: 0, J: 0, Val: val}
+		if err := op.MatFunc(frame, []*MatrixData{md}); err != nil {
+			t.Fatal(err)
+		}
+		matrix, err := driver.LoadMatrixBefore(op.Name, frame.Time.Add(time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matrix) != 9 {
+			t.Fatalf("expected 9 cells, got %d", len(matrix))
+		}
+		var sum, min, max int
+		first := true
+		for _, md := range matrix {
+			sum += md.Val
+			if first || md.Val < min {
+				min = md.Val
+			}
+			if first || md.Val > max {
+				max = md.Val
+			}
+			first = false
+		}
+		if sum != val {
+			t.Errorf("split %d: cells sum to %d", val, sum)
+		} else if max - min > 1 {
+			t.Errorf("split %d: cells range from %d to %d", val, min, max)
+		}
+	}
+}
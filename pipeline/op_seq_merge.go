@@ -1,6 +1,7 @@
 package pipeline
 
 import (
+	"encoding/json"
 	"fmt"
 	"os/exec"
 	"strconv"
@@ -12,11 +13,21 @@ import (
 // to qualify for a gap (sequence termination).
 const SeqMergeGapThreshold int = 10
 
-// Minimum distance from edge of frame for counting gaps.
-const SeqMergeGapPadding float64 = 50
+// Default minimum distance from edge of frame for counting gaps.
+const SeqMergeGapPadding string = "50px"
 
-// Maximum distance of next seq start poly from previous seq end poly.
-const SeqMergeDistanceThreshold float64 = 40
+// Default maximum distance of next seq start poly from previous seq end poly.
+const SeqMergeDistanceThreshold string = "40px"
+
+var SeqMergeSchema = OperatorSchema{
+	Output: SequenceKind,
+	Parents: []DataKind{SequenceKind},
+	Operands: []OperandSpec{
+		{Name: "mode", Type: StringOperand, Default: "spatial", Allowed: []string{"spatial", "image_similarity"}},
+		{Name: "distance", Type: LengthOperand, Default: SeqMergeDistanceThreshold},
+		{Name: "gap_padding", Type: LengthOperand, Default: SeqMergeGapPadding},
+	},
+}
 
 // Merge two sequences together with some merging criteria.
 // SPATIAL: merge if previous sequence ends where next sequence starts
@@ -27,12 +38,17 @@ const SeqMergeDistanceThreshold float64 = 40
 // This functionality is disabled if ignore_gaps=false.
 // But ignore_gaps results in a dataframe without any terminated sequences, so be careful...?
 func MakeSeqMergeOperator(op *Operator, operands map[string]string) {
+	// look-behind rebuilds seqStatuses if there is no checkpoint
 	op.LookBehind = 5*time.Second
 	mode := operands["mode"]
+	distanceLength, _ := ParseLength(operands["distance"])
+	gapPaddingLength, _ := ParseLength(operands["gap_padding"])
+	// in pixels, set by InitFunc since lengths in meters need the GSD
+	var distanceThreshold, gapPadding float64
 	cachedImageSimilarities := make(map[[2]int]float64)
 
 	// map from parent sequence ID -> our merged sequence
-	parentSeqMap := make(map[int]*Sequence)
+	var parentSeqMap map[int]*Sequence
 
 	// number of frames that the sequence was in the field of view
 	// resets to 0 if goes out of the view
@@ -40,7 +56,37 @@ func MakeSeqMergeOperator(op *Operator, operands map[string]string) {
 		frames int
 		videoID int
 	}
-	seqStatuses := make(map[int]seqStatus)
+	var seqStatuses map[int]seqStatus
+
+	// active sequences and parentSeqMap are reloaded by InitFunc, so only
+	// seqStatuses needs to be checkpointed
+	type statusCheckpoint struct {
+		ID int
+		Frames int
+		VideoID int
+	}
+	op.SaveState = func() (string, error) {
+		var statuses []statusCheckpoint
+		for id, status := range seqStatuses {
+			if status == (seqStatus{}) {
+				continue
+			}
+			statuses = append(statuses, statusCheckpoint{id, status.frames, status.videoID})
+		}
+		bytes, err := json.Marshal(statuses)
+		return string(bytes), err
+	}
+	op.LoadState = func(state string) error {
+		var statuses []statusCheckpoint
+		if err := json.Unmarshal([]byte(state), &statuses); err != nil {
+			return err
+		}
+		seqStatuses = make(map[int]seqStatus)
+		for _, status := range statuses {
+			seqStatuses[status.ID] = seqStatus{status.Frames, status.VideoID}
+		}
+		return nil
+	}
 
 	activeSequences := make(map[int]*Sequence)
 
@@ -78,7 +124,7 @@ func MakeSeqMergeOperator(op *Operator, operands map[string]string) {
 		for _, seq := range activeSequences {
 			detection := seq.Members[len(seq.Members)-1].Detection
 			d := getDetectionDistanceToFrame(frame, detection)
-			if d == -1 || d < SeqMergeGapPadding {
+			if d == -1 || d < gapPadding {
 				continue
 			}
 			matchSeqs[seq.ID] = seq
@@ -119,8 +165,8 @@ func MakeSeqMergeOperator(op *Operator, operands map[string]string) {
 		return gapSeqs
 	}
 
-	// return first or last detection at least SeqMergeGapPadding away from frame
-	findPaddedDetection := func(seq *Sequence, first bool) *Detection {
+	// return first or last detection at least gapPadding away from frame
+	findPaddedDetection := func(seq *Sequence, first bool) (*Detection, error) {
 		var detections []*Detection
 		for _, member := range seq.Members {
 			detections = append(detections, member.Detection)
@@ -133,33 +179,60 @@ func MakeSeqMergeOperator(op *Operator, operands map[string]string) {
 			detections = ndetections
 		}
 		for _, detection := range detections {
-			frame := GetFrame(detection.FrameID)
+			frame, err := op.Context.Driver.GetFrame(detection.FrameID)
+			if err != nil {
+				return nil, err
+			} else if frame == nil {
+				return nil, fmt.Errorf("frame %d of detection %d not found", detection.FrameID, detection.ID)
+			}
 			d := getDetectionDistanceToFrame(frame, detection)
-			if d >= SeqMergeGapPadding {
-				return detection
+			if d >= gapPadding {
+				return detection, nil
 			}
 		}
-		return detections[0]
+		return detections[0], nil
 	}
 
-	op.InitFunc = func(firstFrame *Frame) {
+	op.InitFunc = func(firstFrame *Frame) error {
+		// reset state from a previous execution, which may have been rolled back
+		parentSeqMap = make(map[int]*Sequence)
+		seqStatuses = make(map[int]seqStatus)
+
+		var err error
+		if distanceThreshold, err = distanceLength.Pixels(op.Context); err != nil {
+			return err
+		}
+		if gapPadding, err = gapPaddingLength.Pixels(op.Context); err != nil {
+			return err
+		}
+
 		// We set members.time equal to the seq.time of the parent sequence from which the members came from.
 		// Similarly, metadata about parent sequences is the same seq.time.
 		// So we delete everythnig based on the time.
-		driver.UndoSequences(op.Name, firstFrame.Time)
+		if err := op.Context.Driver.UndoSequences(op.Name, firstFrame.Time); err != nil {
+			return err
+		}
 
-		activeSequences = GetUnterminatedSequences(op.Name)
+		activeSequences, err = op.Context.Driver.GetUnterminatedSequences(op.Name)
+		if err != nil {
+			return err
+		}
 		for _, seq := range activeSequences {
-			for _, metadata := range seq.GetMetadata() {
+			seqMetadata, err := seq.GetMetadata()
+			if err != nil {
+				return err
+			}
+			for _, metadata := range seqMetadata {
 				parentID, _ := strconv.Atoi(metadata)
 				parentSeqMap[parentID] = seq
 			}
 		}
+		return nil
 	}
 
 	// we rerun at the minimum of any frame processed from parent or start time of sequences that we modify
 
-	op.SeqFunc = func(frame *Frame, seqs []*Sequence) {
+	op.SeqFunc = func(frame *Frame, seqs []*Sequence) error {
 		op.updateChildRerunTime(frame.Time)
 
 		// merge seqs into candidates
@@ -186,7 +259,7 @@ func MakeSeqMergeOperator(op *Operator, operands map[string]string) {
 
 				myPoint := myEnds.Polygon.Bounds().Center()
 				d := parentPoint.Distance(myPoint)
-				if d > SeqMergeDistanceThreshold {
+				if d > distanceThreshold {
 					continue
 				} else if parentBegins.Time.Before(myEnds.Time) {
 					continue
@@ -194,13 +267,24 @@ func MakeSeqMergeOperator(op *Operator, operands map[string]string) {
 
 				if mode == "image_similarity" {
 					// use external python script to verify that image similarity is close
-					// first get last/first detections that are SeqMergeGapPadding away from their frames
+					// first get last/first detections that are gapPadding away from their frames
 					var similarity float64
 					k := [2]int{parentSeq.ID, mySeq.ID}
 					if _, ok := cachedImageSimilarities[k]; ok {
 						similarity = cachedImageSimilarities[k]
 					} else {
-						similarity = getImageSimilarity(findPaddedDetection(parentSeq, true), findPaddedDetection(mySeq, false))
+						detection1, err := findPaddedDetection(parentSeq, true)
+						if err != nil {
+							return err
+						}
+						detection2, err := findPaddedDetection(mySeq, false)
+						if err != nil {
+							return err
+						}
+						similarity, err = getImageSimilarity(op.Context, detection1, detection2)
+						if err != nil {
+							return err
+						}
 						cachedImageSimilarities[k] = similarity
 						fmt.Printf("%v %v\n", k, similarity)
 					}
@@ -218,9 +302,13 @@ func MakeSeqMergeOperator(op *Operator, operands map[string]string) {
 			if bestMergeSequence != nil {
 				op.updateChildRerunTime(bestMergeSequence.Time)
 				for _, member := range parentSeq.Members {
-					bestMergeSequence.AddMember(member.Detection, frame.Time)
+					if err := bestMergeSequence.AddMember(member.Detection, frame.Time); err != nil {
+						return err
+					}
+				}
+				if err := bestMergeSequence.AddMetadata(fmt.Sprintf("%d", parentSeq.ID), frame.Time); err != nil {
+					return err
 				}
-				bestMergeSequence.AddMetadata(fmt.Sprintf("%d", parentSeq.ID), frame.Time)
 				parentSeqMap[parentSeq.ID] = bestMergeSequence
 				seqStatuses[bestMergeSequence.ID] = seqStatus{}
 			}
@@ -232,11 +320,18 @@ func MakeSeqMergeOperator(op *Operator, operands map[string]string) {
 				continue
 			}
 
-			mySeq := NewSequence(op.Name, parentSeq.Time)
+			mySeq, err := op.Context.Driver.AddSequence(op.Name, parentSeq.Time)
+			if err != nil {
+				return err
+			}
 			for _, member := range parentSeq.Members {
-				mySeq.AddMember(member.Detection, parentSeq.Time)
+				if err := mySeq.AddMember(member.Detection, parentSeq.Time); err != nil {
+					return err
+				}
+			}
+			if err := mySeq.AddMetadata(fmt.Sprintf("%d", parentSeq.ID), parentSeq.Time); err != nil {
+				return err
 			}
-			mySeq.AddMetadata(fmt.Sprintf("%d", parentSeq.ID), parentSeq.Time)
 			parentSeqMap[parentSeq.ID] = mySeq
 			activeSequences[mySeq.ID] = mySeq
 		}
@@ -244,39 +339,56 @@ func MakeSeqMergeOperator(op *Operator, operands map[string]string) {
 		// terminate sequences that were in the field of view and are no longer
 		gapSeqs := updateSeqStatus(frame)
 		for _, mySeq := range gapSeqs {
-			mySeq.Terminate(frame.Time)
+			if err := mySeq.Terminate(frame.Time); err != nil {
+				return err
+			}
 			delete(activeSequences, mySeq.ID)
 		}
+		return nil
 	}
 
 	op.Loader = op.SequenceLoader
 }
 
-func getImageSimilarity(detection1 *Detection, detection2 *Detection) float64 {
-	var video1, video2, frame1, frame2 string
-	db.QueryRow("SELECT video_id, idx FROM video_frames WHERE id = ?", detection1.FrameID).Scan(&video1, &frame1)
-	db.QueryRow("SELECT video_id, idx FROM video_frames WHERE id = ?", detection2.FrameID).Scan(&video2, &frame2)
-	var poly1, poly2 string
-	db.QueryRow("SELECT frame_polygon FROM detections WHERE id = ?", detection1.ID).Scan(&poly1)
-	db.QueryRow("SELECT frame_polygon FROM detections WHERE id = ?", detection2.ID).Scan(&poly2)
-	cmd := exec.Command("python", "seq-merge-imagediff.py", video1, video2, frame1, frame2, poly1, poly2)
+func getImageSimilarity(ctx *Context, detection1 *Detection, detection2 *Detection) (float64, error) {
+	// video ID, frame index and polygon in the frame of each detection
+	var args [3][2]string
+	for i, detection := range []*Detection{detection1, detection2} {
+		frame, err := ctx.Driver.GetFrame(detection.FrameID)
+		if err != nil {
+			return 0, fmt.Errorf("frame %d: %v", detection.FrameID, err)
+		} else if frame == nil {
+			return 0, fmt.Errorf("frame %d: not found", detection.FrameID)
+		}
+		// the sequence's detection does not have the frame polygon
+		full, err := ctx.Driver.GetDetection(detection.ID)
+		if err != nil {
+			return 0, fmt.Errorf("detection %d: %v", detection.ID, err)
+		} else if full == nil {
+			return 0, fmt.Errorf("detection %d: not found", detection.ID)
+		}
+		args[0][i] = strconv.Itoa(frame.VideoID)
+		args[1][i] = strconv.Itoa(frame.Idx)
+		args[2][i] = EncodePolygon(full.FramePolygon)
+	}
+	cmd := exec.Command("python", "seq-merge-imagediff.py", args[0][0], args[0][1], args[1][0], args[1][1], args[2][0], args[2][1])
 	bytes, err := cmd.Output()
 	if err != nil {
 		fmt.Println(string(bytes))
 		fmt.Println("warning!! image similarity error")
 		//panic(err)
-		return 0
+		return 0, nil
 	}
 	output := strings.TrimSpace(string(bytes))
 	lines := strings.Split(output, "\n")
 	lastLine := lines[len(lines)-1]
 	if strings.Contains(lastLine, "bad") {
-		return 0
+		return 0, nil
 	}
 	similarity, err := strconv.ParseFloat(lastLine, 64)
 	if err != nil {
 		fmt.Println(output)
-		panic(err)
+		return 0, err
 	}
-	return similarity
+	return similarity, nil
 }
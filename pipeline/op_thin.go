@@ -1,16 +1,28 @@
 package pipeline
 
+var ThinSchema = OperatorSchema{
+	Output: MatrixKind,
+	Parents: []DataKind{MatrixKind},
+}
+
 // Zero matrix cells that are adjacent to a <= 0 cell.
 func MakeThinOperator(op *Operator, operands map[string]string) {
 	var parentMatrix map[[2]int]*MatrixData
 
-	op.InitFunc = func(frame *Frame) {
-		driver.DeleteMatrixAfter(op.Name, frame.Time)
-		parentMatrix = driver.LoadMatrixBefore(op.Parents[0].Name, frame.Time)
+	op.InitFunc = func(frame *Frame) error {
+		if err := op.Context.Driver.DeleteMatrixAfter(op.Name, frame.Time); err != nil {
+			return err
+		}
+		var err error
+		parentMatrix, err = op.Context.Driver.LoadMatrixBefore(op.Parents[0].Name, frame.Time)
+		if err != nil {
+			return err
+		}
 		op.updateChildRerunTime(frame.Time)
+		return nil
 	}
 
-	op.MatFunc = func(frame *Frame, matrixData []*MatrixData) {
+	op.MatFunc = func(frame *Frame, matrixData []*MatrixData) error {
 		for _, md := range matrixData {
 			parentMatrix[[2]int{md.I, md.J}] = md
 
@@ -31,9 +43,12 @@ func MakeThinOperator(op *Operator, operands map[string]string) {
 				}
 			}
 			if good {
-				AddMatrixData(op.Name, md.I, md.J, md.Val, "", frame.Time)
+				if _, err := op.Context.AddMatrixData(op.Name, md.I, md.J, md.Val, "", frame.Time); err != nil {
+					return err
+				}
 			}
 		}
+		return nil
 	}
 
 	op.Loader = op.MatrixLoader
@@ -6,16 +6,27 @@ import (
 
 const TimeShiftDuration time.Duration = -time.Hour
 
+var TimeShiftSchema = OperatorSchema{
+	Output: MatrixKind,
+	Parents: []DataKind{MatrixKind},
+}
+
 func MakeTimeShiftOperator(op *Operator, operands map[string]string) {
-	op.InitFunc = func(frame *Frame) {
-		driver.DeleteMatrixAfter(op.Name, frame.Time.Add(TimeShiftDuration))
+	op.InitFunc = func(frame *Frame) error {
+		if err := op.Context.Driver.DeleteMatrixAfter(op.Name, frame.Time.Add(TimeShiftDuration)); err != nil {
+			return err
+		}
 		op.updateChildRerunTime(frame.Time.Add(TimeShiftDuration))
+		return nil
 	}
 
-	op.MatFunc = func(frame *Frame, matrixData []*MatrixData) {
+	op.MatFunc = func(frame *Frame, matrixData []*MatrixData) error {
 		for _, md := range matrixData {
-			AddMatrixData(op.Name, md.I, md.J, md.Val, "", frame.Time.Add(TimeShiftDuration))
+			if _, err := op.Context.AddMatrixData(op.Name, md.I, md.J, md.Val, "", frame.Time.Add(TimeShiftDuration)); err != nil {
+				return err
+			}
 		}
+		return nil
 	}
 
 	op.Loader = op.MatrixLoader
@@ -3,8 +3,10 @@ package pipeline
 import (
 	"github.com/mitroadmaps/gomapinfer/common"
 
+	"encoding/json"
 	"fmt"
 	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -27,23 +29,38 @@ TO_MATRIX chooses which frame to use intelligently
 another TODO: videos should have a termination frame for operators like SEQ_MERGE and TO_MATRIX
 */
 
-const MatrixGridSize float64 = 512
-
 // duration in the past to look at when re-running this operator
 // this is needed to reconstruct the selection of best cell to look at
 // as long as MatrixLookBehind>=1 frame, we will create the same matrix_data
 // but the value will only be correct if MatrixLookBehind is larger than
 // the time that a point may be visible in the video (which is related to the drone speed)
+// this is only used if there is no checkpoint of the cell statuses before the rerun time
 const MatrixLookBehind time.Duration = 30*time.Second
 
 type ToMatrixAggFunc func(cell [2]int, prev int, metadata string, frame *Frame, seqs []*Sequence) (int, string)
-var ToMatrixAggFuncs = map[string]ToMatrixAggFunc{
+var ToMatrixAggFuncs = withMetricAggFuncs(map[string]ToMatrixAggFunc{
 	"count": func(cell [2]int, prev int, metadata string, frame *Frame, seqs []*Sequence) (int, string) {
 		return len(seqs), ""
 	},
 	"count_sum": func(cell [2]int, prev int, metadata string, frame *Frame, seqs []*Sequence) (int, string) {
 		return prev + len(seqs), ""
 	},
+	// like count and count_sum, but each sequence counts as its mean
+	// confidence, so the value is the expected number of objects
+	"count_conf": func(cell [2]int, prev int, metadata string, frame *Frame, seqs []*Sequence) (int, string) {
+		var sum float64
+		for _, seq := range seqs {
+			sum += seq.MeanConfidence()
+		}
+		return int(math.Round(sum)), ""
+	},
+	"count_conf_sum": func(cell [2]int, prev int, metadata string, frame *Frame, seqs []*Sequence) (int, string) {
+		sum, _ := strconv.ParseFloat(metadata, 64)
+		for _, seq := range seqs {
+			sum += seq.MeanConfidence()
+		}
+		return int(math.Round(sum)), fmt.Sprintf("%v", sum)
+	},
 	"count_old_sum": func(cell [2]int, prev int, metadata string, frame *Frame, seqs []*Sequence) (int, string) {
 		prevIDs := decodeIntSlice(metadata)
 		prevIDSet := make(map[int]bool)
@@ -93,62 +110,89 @@ var ToMatrixAggFuncs = map[string]ToMatrixAggFunc{
 			return int(sum / count), fmt.Sprintf("%v,%v", sum, count)
 		}
 	},
+})
+
+// Adds avg_X and max_X aggregation functions for each metric X in FilterFuncs.
+// These keep the running mean and max of the metric over all sequences seen in
+// the cell, e.g. avg_mean_speed or max_heading_change.
+func withMetricAggFuncs(funcs map[string]ToMatrixAggFunc) map[string]ToMatrixAggFunc {
+	for name, f := range FilterFuncs {
+		funcs["avg_" + name] = avgMetricAggFunc(f)
+		funcs["max_" + name] = maxMetricAggFunc(f)
+	}
+	return funcs
 }
 
-func ToCell(p common.Point, gridSize float64) [2]int {
-	return [2]int{
-		int(math.Floor(p.X / gridSize)),
-		int(math.Floor(p.Y / gridSize)),
+// Sorted names of ToMatrixAggFuncs.
+func toMatrixAggFuncNames() []string {
+	var names []string
+	for name := range ToMatrixAggFuncs {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
 }
 
-func GetCellRect(cell [2]int, gridSize float64) common.Rectangle {
-	cellPoint := common.Point{float64(cell[0]), float64(cell[1])}
-	return common.Rectangle{
-		cellPoint.Scale(gridSize),
-		cellPoint.Add(common.Point{1, 1}).Scale(gridSize),
+// Returns the avg_X or max_X aggregation function of a metric in meters with
+// the GSD of the context, or nil if name is not one.
+func (ctx *Context) getMeterAggFunc(name string) ToMatrixAggFunc {
+	if !isMeterMetric(name) {
+		return nil
+	} else if strings.HasPrefix(name, "avg_") {
+		return avgMetricAggFunc(ctx.getFilterFunc(strings.TrimPrefix(name, "avg_")))
+	} else if strings.HasPrefix(name, "max_") {
+		return maxMetricAggFunc(ctx.getFilterFunc(strings.TrimPrefix(name, "max_")))
 	}
+	return nil
 }
 
-func IsCellInFrame(cell [2]int, frame *Frame, gridSize float64) bool {
-	cellRect := GetCellRect(cell, gridSize)
-	for _, p := range cellRect.ToPolygon() {
-		if !frame.Bounds.Contains(p) {
-			return false
+func avgMetricAggFunc(f FilterFunc) ToMatrixAggFunc {
+	return func(cell [2]int, prev int, metadata string, frame *Frame, seqs []*Sequence) (int, string) {
+		metaParts := strings.Split(metadata, ",")
+		var sum, count float64
+		if len(metaParts) == 2 {
+			sum, _ = strconv.ParseFloat(metaParts[0], 64)
+			count, _ = strconv.ParseFloat(metaParts[1], 64)
 		}
+		for _, seq := range seqs {
+			sum += f(seq)
+			count++
+		}
+		if count == 0 {
+			return 0, ""
+		}
+		return int(sum / count), fmt.Sprintf("%v,%v", sum, count)
 	}
-	return true
 }
 
-// Returns map from cells visible in current frame to the distances from
-// those cells to the frame boundaries
-func GetCellsInFrame(frame *Frame) map[[2]int]float64 {
-	frameRect := frame.Bounds.Bounds()
-	startCell := ToCell(frameRect.Min, MatrixGridSize)
-	endCell := ToCell(frameRect.Max, MatrixGridSize)
-	frameCells := make(map[[2]int]float64)
-	processCell := func(cell [2]int) {
-		if !IsCellInFrame(cell, frame, MatrixGridSize) {
-			return
+func maxMetricAggFunc(f FilterFunc) ToMatrixAggFunc {
+	return func(cell [2]int, prev int, metadata string, frame *Frame, seqs []*Sequence) (int, string) {
+		var max float64
+		ok := metadata != ""
+		if ok {
+			max, _ = strconv.ParseFloat(metadata, 64)
 		}
-		cellRect := GetCellRect(cell, MatrixGridSize)
-		var worstDistance float64 = -1
-		for _, cellSegment := range cellRect.ToPolygon().Segments() {
-			for _, frameSegment := range frame.Bounds.Segments() {
-				d := cellSegment.DistanceToSegment(frameSegment)
-				if worstDistance == -1 || d < worstDistance {
-					worstDistance = d
-				}
+		for _, seq := range seqs {
+			if v := f(seq); !ok || v > max {
+				max = v
+				ok = true
 			}
 		}
-		frameCells[cell] = worstDistance
-	}
-	for i := startCell[0]; i <= endCell[0]; i++ {
-		for j := startCell[1]; j <= endCell[1]; j++ {
-			processCell([2]int{i, j})
+		if !ok {
+			return 0, ""
 		}
+		return int(max), fmt.Sprintf("%v", max)
 	}
-	return frameCells
+}
+
+var ToMatrixSchema = OperatorSchema{
+	Output: MatrixKind,
+	Parents: []DataKind{SequenceKind},
+	Operands: append([]OperandSpec{
+		{Name: "func", Type: StringOperand, Default: "count", Allowed: toMatrixAggFuncNames()},
+		{Name: "ignore_zero", Type: BoolOperand, Default: "no"},
+		{Name: "union_seqs", Type: BoolOperand, Default: "no"},
+	}, GridOperands...),
 }
 
 // Converts sequences to matrix using an aggregation function of the form:
@@ -158,12 +202,17 @@ func GetCellsInFrame(frame *Frame) map[[2]int]float64 {
 // Aggregation functions include:
 // * COUNT - count # current sequences
 // * COUNT_SUM - count # current sequences, and add to previous count
+// * COUNT_CONF, COUNT_CONF_SUM - like COUNT and COUNT_SUM, weighted by confidence
+// * AVG_X, MAX_X - running mean or max of sequence metric X (see FilterFuncs)
 func MakeToMatrixOperator(op *Operator, operands map[string]string) {
 	funcName := operands["func"]
 	if funcName == "" {
 		funcName = "count"
 	}
 	aggFunc := ToMatrixAggFuncs[funcName]
+	if f := op.Context.getMeterAggFunc(funcName); f != nil {
+		aggFunc = f
+	}
 	ignoreZero := operands["ignore_zero"] == "yes"
 	unionSeqs := operands["union_seqs"] == "yes"
 
@@ -180,17 +229,107 @@ func MakeToMatrixOperator(op *Operator, operands map[string]string) {
 		videoID int
 		bestFrame bestFrame
 	}
-	cellStatuses := make(map[[2]int]cellStatus)
+	var cellStatuses map[[2]int]cellStatus
 
 	var firstFrameTime time.Time
-	op.InitFunc = func(frame *Frame) {
-		driver.DeleteMatrixAfter(op.Name, frame.Time)
+	op.InitFunc = func(frame *Frame) error {
+		// reset state from a previous execution, LoadState restores it if
+		// there is a checkpoint
+		cellStatuses = make(map[[2]int]cellStatus)
+
+		if isMeterMetric(funcName) {
+			if err := op.Context.requireGSD(); err != nil {
+				return err
+			}
+		}
+		if err := op.Context.Driver.DeleteMatrixAfter(op.Name, frame.Time); err != nil {
+			return err
+		}
 		firstFrameTime = frame.Time
 		op.updateChildRerunTime(firstFrameTime)
+		return nil
+	}
+
+	// cell statuses are checkpointed with frame and sequence IDs
+	// the sequences are reloaded from the parent when restoring
+	type cellCheckpoint struct {
+		Cell [2]int
+		VideoID int
+		FrameID int
+		SequenceIDs []int
+		Distance float64
+	}
+	op.SaveState = func() (string, error) {
+		var cells []cellCheckpoint
+		for cell, status := range cellStatuses {
+			c := cellCheckpoint{
+				Cell: cell,
+				VideoID: status.videoID,
+				FrameID: status.bestFrame.frame.ID,
+				Distance: status.bestFrame.distance,
+			}
+			for id := range status.bestFrame.sequences {
+				c.SequenceIDs = append(c.SequenceIDs, id)
+			}
+			cells = append(cells, c)
+		}
+		bytes, err := json.Marshal(cells)
+		return string(bytes), err
+	}
+	op.LoadState = func(state string) error {
+		var cells []cellCheckpoint
+		if err := json.Unmarshal([]byte(state), &cells); err != nil {
+			return err
+		}
+		frames := make(map[int]*Frame)
+		var minTime time.Time
+		for _, c := range cells {
+			if frames[c.FrameID] != nil {
+				continue
+			}
+			frame, err := op.Context.Driver.GetFrame(c.FrameID)
+			if err != nil {
+				return err
+			} else if frame == nil {
+				return fmt.Errorf("frame %d not found", c.FrameID)
+			}
+			frames[c.FrameID] = frame
+			if minTime.IsZero() || frame.Time.Before(minTime) {
+				minTime = frame.Time
+			}
+		}
+		// sequences seen at the best frames have not terminated before them
+		var parentSeqs map[int]*Sequence
+		if len(cells) > 0 {
+			var err error
+			parentSeqs, err = op.Context.Driver.GetSequencesAfter(op.Parents[0].Name, minTime)
+			if err != nil {
+				return err
+			}
+		}
+		cellStatuses = make(map[[2]int]cellStatus)
+		for _, c := range cells {
+			sequences := make(map[int]*Sequence)
+			for _, id := range c.SequenceIDs {
+				if parentSeqs[id] == nil {
+					return fmt.Errorf("sequence %d not found in %s", id, op.Parents[0].Name)
+				}
+				sequences[id] = parentSeqs[id]
+			}
+			cellStatuses[c.Cell] = cellStatus{
+				videoID: c.VideoID,
+				bestFrame: bestFrame{
+					frame: frames[c.FrameID],
+					sequences: sequences,
+					distance: c.Distance,
+				},
+			}
+		}
+		return nil
 	}
 
 	getRelevantSequences := func(seqs []*Sequence, cell [2]int, seqLocations map[int]*common.Point) map[int]*Sequence {
-		cellRect := GetCellRect(cell, MatrixGridSize)
+		cellRect := GetCellRect(cell, op.Grid)
 		relevantSeqs := make(map[int]*Sequence)
 		for _, seq := range seqs {
 			location := seqLocations[seq.ID]
@@ -205,8 +344,8 @@ func MakeToMatrixOperator(op *Operator, operands map[string]string) {
 		return relevantSeqs
 	}
 
-	op.SeqFunc = func(frame *Frame, seqs []*Sequence) {
-		frameCells := GetCellsInFrame(frame)
+	op.SeqFunc = func(frame *Frame, seqs []*Sequence) error {
+		frameCells := GetCellsInFrame(frame, op.Grid)
 
 		// get location of sequences at this frame
 		seqLocations := make(map[int]*common.Point)
@@ -248,7 +387,7 @@ func MakeToMatrixOperator(op *Operator, operands map[string]string) {
 		}
 
 		if frame.Time.Before(firstFrameTime) {
-			return
+			return nil
 		}
 
 		// run aggregation function on best frames of cells that left
@@ -257,7 +396,10 @@ func MakeToMatrixOperator(op *Operator, operands map[string]string) {
 				continue
 			}
 			fmt.Printf("[%s] frame %d/%d: adding observation at cell %v\n", op.Name, frame.VideoID, frame.Idx, cell)
-			prevData := driver.GetLatestMatrixData(op.Name, cell[0], cell[1])
+			prevData, err := op.Context.Driver.GetLatestMatrixData(op.Name, cell[0], cell[1])
+			if err != nil {
+				return err
+			}
 			var prev int = 0
 			var metadata string
 			if prevData != nil {
@@ -269,9 +411,12 @@ func MakeToMatrixOperator(op *Operator, operands map[string]string) {
 				sequences = append(sequences, seq)
 			}
 			val, metadata := aggFunc(cell, prev, metadata, status.bestFrame.frame, sequences)
-			AddMatrixData(op.Name, cell[0], cell[1], val, metadata, frame.Time)
+			if _, err := op.Context.AddMatrixData(op.Name, cell[0], cell[1], val, metadata, frame.Time); err != nil {
+				return err
+			}
 			delete(cellStatuses, cell)
 		}
+		return nil
 	}
 
 	op.Loader = op.MatrixLoader
@@ -2,6 +2,7 @@ package pipeline
 
 import (
 	"fmt"
+	"sync"
 	"time"
 )
 
@@ -22,27 +23,37 @@ func (pd ParentData) Append(other ParentData) ParentData {
 }
 
 type Operator struct {
+	// Context that the operator reads and writes dataframes in.
+	Context *Context
+
 	Name string
+	// Operator type and operands from the dataframes table.
+	Type string
+	Operands map[string]string
+
+	// Grid of the cells emitted by matrix operators, see GetGrids.
+	Grid Grid
+
 	RerunTime time.Time
 	ChildRerunTime time.Time
 
 	// Returns LoadFunc to feed this operator into a child.
-	Loader func(frames []*Frame) LoadFunc
+	Loader func(frames []*Frame) (LoadFunc, error)
 
 	// Prepare to execute this operator starting from the specified frame.
 	// If this operator has LookBehind, the specified frame is based on the
 	// parent's rerun-time. But Func() will be called on earlier frames.
-	InitFunc func(frame *Frame)
+	InitFunc func(frame *Frame) error
 
 	// Execute the oeprator on this frame.
-	Func func(frame *Frame, parentData ParentData)
+	Func func(frame *Frame, parentData ParentData) error
 
 	// Convenience functions if you don't want to implement Func, for operators
 	// that simply accept detections only or sequences only.
 	// DefaultFunc will pass parentData to these if they are set.
-	DetFunc func(frame *Frame, detections []*Detection)
-	SeqFunc func(frame *Frame, sequences []*Sequence)
-	MatFunc func(frame *Frame, matrixData []*MatrixData)
+	DetFunc func(frame *Frame, detections []*Detection) error
+	SeqFunc func(frame *Frame, sequences []*Sequence) error
+	MatFunc func(frame *Frame, matrixData []*MatrixData) error
 
 	Parents []*Operator
 	Children []*Operator
@@ -52,6 +63,19 @@ type Operator struct {
 	// parent's rerun-time, in case their Func() makes decisions based on previous
 	// frames. This duration is how long the operator wants to see into the past.
 	LookBehind time.Duration
+
+	// Optional functions to serialize the in-memory state that Func builds up
+	// across frames, and to restore it after InitFunc. If set, reruns resume
+	// from a checkpoint instead of replaying LookBehind, see checkpoint.go.
+	SaveState func() (string, error)
+	LoadState func(state string) error
+
+	// Whether InitFunc has been called, so the operator's in-memory state can
+	// accept more frames through ExecuteFrames.
+	initialized bool
+
+	// Time of the last checkpoint saved or restored.
+	checkpointTime time.Time
 }
 
 func (op *Operator) updateChildRerunTime(t time.Time) {
@@ -60,21 +84,24 @@ func (op *Operator) updateChildRerunTime(t time.Time) {
 	}
 }
 
-func (op *Operator) DefaultFunc(frame *Frame, parentData ParentData) {
+func (op *Operator) DefaultFunc(frame *Frame, parentData ParentData) error {
 	// try to pass into DetFunc or SeqFunc
 	if op.DetFunc != nil {
-		op.DetFunc(frame, parentData.Detections[0])
+		return op.DetFunc(frame, parentData.Detections[0])
 	} else if op.SeqFunc != nil {
-		op.SeqFunc(frame, parentData.Sequences[0])
+		return op.SeqFunc(frame, parentData.Sequences[0])
 	} else if op.MatFunc != nil {
-		op.MatFunc(frame, parentData.MatrixData[0])
+		return op.MatFunc(frame, parentData.MatrixData[0])
 	} else {
-		op.Func(frame, parentData)
+		return op.Func(frame, parentData)
 	}
 }
 
-func (op *Operator) DetectionLoader(frames []*Frame) LoadFunc {
-	detections := GetDetectionsAfter(op.Name, frames[0].Time)
+func (op *Operator) DetectionLoader(frames []*Frame) (LoadFunc, error) {
+	detections, err := op.Context.Driver.GetDetectionsAfter(op.Name, frames[0].Time)
+	if err != nil {
+		return nil, err
+	}
 	frameDetections := make(map[int][]*Detection)
 	for _, detection := range detections {
 		frameDetections[detection.FrameID] = append(frameDetections[detection.FrameID], detection)
@@ -86,11 +113,14 @@ func (op *Operator) DetectionLoader(frames []*Frame) LoadFunc {
 		return ParentData{
 			Detections: [][]*Detection{frameDetections[frame.ID]},
 		}
-	}
+	}, nil
 }
 
-func (op *Operator) SequenceLoader(frames []*Frame) LoadFunc {
-	sequences := GetSequencesAfter(op.Name, frames[0].Time)
+func (op *Operator) SequenceLoader(frames []*Frame) (LoadFunc, error) {
+	sequences, err := op.Context.Driver.GetSequencesAfter(op.Name, frames[0].Time)
+	if err != nil {
+		return nil, err
+	}
 	fmt.Printf("[%s] preloaded %d sequences before %v\n", op.Name, len(sequences), frames[0].Time)
 	frameSeqs := make(map[int][]*Sequence)
 	activeSeqs := make(map[int]*Sequence)
@@ -123,12 +153,15 @@ func (op *Operator) SequenceLoader(frames []*Frame) LoadFunc {
 		return ParentData{
 			Sequences: [][]*Sequence{seqList},
 		}
-	}
+	}, nil
 }
 
-func (op *Operator) MatrixLoader(frames []*Frame) LoadFunc {
+func (op *Operator) MatrixLoader(frames []*Frame) (LoadFunc, error) {
 	// send matrix data on the first frame satisfying frame.time >= md.time
-	matrixDatas := driver.GetMatrixDatasAfter(op.Name, frames[0].Time)
+	matrixDatas, err := op.Context.Driver.GetMatrixDatasAfter(op.Name, frames[0].Time)
+	if err != nil {
+		return nil, err
+	}
 	mdMap := make(map[int]*MatrixData)
 	for _, md := range matrixDatas {
 		mdMap[md.ID] = md
@@ -148,38 +181,142 @@ func (op *Operator) MatrixLoader(frames []*Frame) LoadFunc {
 		return ParentData{
 			MatrixData: [][]*MatrixData{mds},
 		}
-	}
+	}, nil
 }
 
+// Number of frames that an operator processes between commits. If execution
+// is interrupted, the operator resumes from the last commit.
+// Zero means to commit only once the operator is done.
+var CommitInterval int = 1000
+
+// Guards RerunTime of operators with several parents, since the parents may
+// finish concurrently.
+var rerunTimeMu sync.Mutex
+
 // Called when operator is done updating based on parents.
 // We reset our rerun time and update children rerun time to be less than the ChildRerunTime.
-func (op *Operator) PropogateRerunTime() {
-	db.Exec("UPDATE dataframes SET rerun_time = NOW() WHERE name = ?", op.Name)
+func (op *Operator) PropogateRerunTime() error {
+	return op.updateRerunTimes(nil)
+}
+
+// Set our rerun time in the dataframes table to t, or to the current time if
+// t is nil, and lower the rerun times of children to our ChildRerunTime.
+// If we are in a transaction, the updates are part of it.
+func (op *Operator) updateRerunTimes(t *time.Time) error {
+	rerunTimeMu.Lock()
 	if op.RerunTime.Before(op.ChildRerunTime) {
 		op.ChildRerunTime = op.RerunTime
 	}
+	childTimes := make(map[string]time.Time)
 	for _, child := range op.Children {
 		if op.ChildRerunTime.Before(child.RerunTime) {
 			child.RerunTime = op.ChildRerunTime
-			db.Exec("UPDATE dataframes SET rerun_time = ? WHERE name = ?", child.RerunTime, child.Name)
+			childTimes[child.Name] = child.RerunTime
+		}
+	}
+	rerunTimeMu.Unlock()
+
+	opDB := op.Context.dbFor(op.Name)
+	var err error
+	if t == nil {
+		_, err = opDB.Exec("UPDATE dataframes SET rerun_time = " + opDB.Dialect().Now() + " WHERE name = ?", op.Name)
+	} else {
+		_, err = opDB.Exec("UPDATE dataframes SET rerun_time = ? WHERE name = ?", *t, op.Name)
+	}
+	if err != nil {
+		return err
+	}
+	for name, childTime := range childTimes {
+		if _, err := opDB.Exec("UPDATE dataframes SET rerun_time = ? WHERE name = ?", childTime, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Call f in a transaction on our dataframe, so that our output and rerun time
+// updates are committed together, or rolled back together if f fails.
+// A panic in f is returned as an error after rolling back, since otherwise the
+// transaction would stay open and block retries (and, on SQLite, all writers).
+func (op *Operator) transaction(f func() error) error {
+	if err := op.Context.Driver.Begin(op.Name); err != nil {
+		return err
+	}
+	if err := tryFunc(f); err != nil {
+		// in-memory state may not match the rolled back dataframe
+		op.initialized = false
+		if rbErr := op.Context.Driver.Rollback(op.Name); rbErr != nil {
+			return fmt.Errorf("%v (rollback failed: %v)", err, rbErr)
 		}
+		return err
 	}
+	return op.Context.Driver.Commit(op.Name)
+}
+
+// Commit output so far, and record in the dataframes table that we should
+// resume from the specified frame if execution is interrupted.
+func (op *Operator) commitBefore(frame *Frame) error {
+	if err := op.updateRerunTimes(&frame.Time); err != nil {
+		return err
+	}
+	if err := op.Context.Driver.Commit(op.Name); err != nil {
+		return err
+	}
+	return op.Context.Driver.Begin(op.Name)
 }
 
 // Feed data from parent operators into this operator.
-func (op *Operator) Execute() {
+func (op *Operator) Execute() error {
+	return op.ExecuteUntil(time.Time{})
+}
+
+// Like Execute, but ignore frames after the end time, unless end is zero.
+func (op *Operator) ExecuteUntil(end time.Time) error {
+	return op.transaction(func() error {
+		return op.executeUntil(end)
+	})
+}
+
+// Execute and record metrics in the operator_metrics table.
+func (op *Operator) executeUntil(end time.Time) error {
+	metrics := &OperatorMetrics{
+		Dataframe: op.Name,
+		StartedAt: time.Now(),
+	}
+	if err := op.executeWithMetrics(end, metrics); err != nil {
+		return err
+	}
+	metrics.WallTime = time.Since(metrics.StartedAt)
+	if !Quiet {
+		fmt.Printf("[metrics] %v\n", metrics)
+	}
+	return metrics.save(op.Context)
+}
+
+func (op *Operator) executeWithMetrics(end time.Time, metrics *OperatorMetrics) error {
 	// rerun time is minimum child-rerun-time of our parents
-	// if we have look-behind, then we add in frames from before rerun time
-	startTime := op.RerunTime
-	if op.LookBehind > 0 {
-		startTime = startTime.Add(-op.LookBehind)
+	// if we have a checkpoint or look-behind, then we add in frames from before rerun time
+	startTime, resume, err := op.getStartTime(op.RerunTime)
+	if err != nil {
+		return err
+	}
+	frames, err := op.Context.Driver.GetFramesStartingFrom(startTime)
+	if err != nil {
+		return err
+	}
+	if !end.IsZero() {
+		for i, frame := range frames {
+			if frame.Time.After(end) {
+				frames = frames[:i]
+				break
+			}
+		}
 	}
-	frames := driver.GetFramesStartingFrom(startTime)
 
 	// might get no frames if there is no work to do!
 	if len(frames) == 0 {
 		fmt.Printf("skip %s because no frames to process\n", op.Name)
-		return
+		return nil
 	}
 
 	// filter out frames where the bounds changes too much from previous frame
@@ -220,27 +357,104 @@ func (op *Operator) Execute() {
 
 	if rerunFrame == nil {
 		fmt.Printf("skip %s because no rerun frame found\n", op.Name)
-		return
+		return nil
 	}
 
+	rowsBefore, err := op.countRows()
+	if err != nil {
+		return err
+	}
 	if op.InitFunc != nil {
-		op.InitFunc(rerunFrame)
+		initStart := time.Now()
+		if err := op.InitFunc(rerunFrame); err != nil {
+			return err
+		}
+		metrics.InitTime = time.Since(initStart)
+	}
+	op.initialized = true
+	if op.SaveState != nil {
+		if err := op.deleteCheckpointsAfter(rerunFrame.Time); err != nil {
+			return err
+		}
+	}
+	op.checkpointTime = time.Time{}
+	if resume != nil {
+		if err := op.LoadState(resume.State); err != nil {
+			return fmt.Errorf("load checkpoint at %v: %v", resume.Time, err)
+		}
+		op.checkpointTime = resume.Time
+	}
+	rowsAfterInit, err := op.countRows()
+	if err != nil {
+		return err
+	}
+	metrics.RowsDeleted = rowsBefore - rowsAfterInit
+
+	if err := op.executeFrames(frames, metrics); err != nil {
+		return err
+	}
+	rowsAfter, err := op.countRows()
+	if err != nil {
+		return err
 	}
+	metrics.RowsEmitted = rowsAfter - rowsAfterInit
 
+	// update rerun times
+	return op.PropogateRerunTime()
+}
+
+// Feed parent data at the specified frames into this operator, without
+// calling InitFunc. This continues from the operator's in-memory state, so
+// the frames must come after any frames that were previously processed.
+func (op *Operator) ExecuteFrames(frames []*Frame) error {
+	return op.transaction(func() error {
+		return op.executeFrames(frames, nil)
+	})
+}
+
+// Frames and parent rows are added to metrics unless it is nil.
+func (op *Operator) executeFrames(frames []*Frame, metrics *OperatorMetrics) error {
 	// collect load funcs from parents
 	var loadFuncs []LoadFunc
 	for _, parent := range op.Parents {
-		loadFuncs = append(loadFuncs, parent.Loader(frames))
+		loadFunc, err := parent.Loader(frames)
+		if err != nil {
+			return fmt.Errorf("load %s: %v", parent.Name, err)
+		}
+		loadFuncs = append(loadFuncs, loadFunc)
 	}
 
-	for _, frame := range frames {
+	var uncommitted int
+	for i, frame := range frames {
+		// checkpoint between frames with different times, so that resuming
+		// from the checkpoint time does not repeat frames
+		if op.SaveState != nil && i > 0 && frames[i-1].Time.Before(frame.Time) && !frame.Time.Before(op.RerunTime) && frame.Time.Sub(op.checkpointTime) >= CheckpointInterval {
+			if err := op.saveCheckpoint(frame.Time); err != nil {
+				return fmt.Errorf("checkpoint: %v", err)
+			}
+		}
+
+		// only commit at frames after the rerun time, since we skip output
+		// for earlier frames that we see because of LookBehind
+		if CommitInterval > 0 && uncommitted >= CommitInterval && !frame.Time.Before(op.RerunTime) {
+			if err := op.commitBefore(frame); err != nil {
+				return err
+			}
+			uncommitted = 0
+		}
+		uncommitted++
+
 		var pd ParentData
 		for _, loadFunc := range loadFuncs {
 			pd = pd.Append(loadFunc(frame))
 		}
-		op.DefaultFunc(frame, pd)
+		if metrics != nil {
+			metrics.Frames++
+			metrics.ParentRows += pd.count()
+		}
+		if err := op.DefaultFunc(frame, pd); err != nil {
+			return fmt.Errorf("frame %d: %v", frame.ID, err)
+		}
 	}
-
-	// update rerun times
-	op.PropogateRerunTime()
+	return nil
 }
@@ -2,6 +2,8 @@ package pipeline
 
 import (
 	"fmt"
+	"runtime"
+	"sort"
 	"strings"
 	"time"
 )
@@ -9,16 +11,44 @@ import (
 const Debug = false
 var Quiet bool = false
 
+// Maximum number of operators that RunAll executes concurrently. Databases
+// with a single writer lower it, see Dialect.MaxWriters.
+var Workers int = runtime.NumCPU()
+
+// Number of times RunAll retries an operator that failed, e.g. because the
+// database connection was lost, and how long it waits between attempts.
+var Retries int = 2
+var RetryDelay time.Duration = 10*time.Second
+
 type Pipeline map[string]*Operator
 
-func RunPipeline() {
-	GetPipeline().RunAll()
+// Functions on the pipeline of DefaultContext.
+
+func RunPipeline() error {
+	return DefaultContext.RunPipeline()
+}
+
+func GetPipeline() (Pipeline, error) {
+	return DefaultContext.GetPipeline()
+}
+
+func BuildPipeline(specs map[string]DataframeSpec) (Pipeline, error) {
+	return DefaultContext.BuildPipeline(specs)
+}
+
+func (ctx *Context) RunPipeline() error {
+	pipeline, err := ctx.GetPipeline()
+	if err != nil {
+		return err
+	}
+	return pipeline.RunAll()
 }
 
 type OperatorFactory func(op *Operator, operands map[string]string)
 var OperatorFactories = map[string]OperatorFactory{
 	"raw_detection": MakeDetectionOperator,
 	"raw_matrix": MakeMatrixOperator,
+	"raw_sequence": MakeSequenceOperator,
 	"obj_track": MakeObjTrackOperator,
 	"filter": MakeFilterOperator,
 	"seq_merge": MakeSeqMergeOperator,
@@ -33,34 +63,58 @@ var OperatorFactories = map[string]OperatorFactory{
 	"err_normalize": MakeNormalizeErrorRate,
 	"error": MakeErrorOperator,
 	"open_parking": MakeOpenParkingOperator,
+	"regrid": MakeRegridOperator,
+	"det_filter": MakeDetFilterOperator,
 }
 
-func GetPipeline() Pipeline {
-	// create pipeline graph
-	rows := db.Query("SELECT name, parents, op_type, operands, rerun_time FROM dataframes")
-	type seqDataframe struct {
-		name string
-		parents []string
-		opType string
-		operands map[string]string
-		rerunTime time.Time
-	}
-	dataframes := make(map[string]seqDataframe)
-	for rows.Next() {
-		var dataframe seqDataframe
-		var parents, operands string
-		rows.Scan(&dataframe.name, &parents, &dataframe.opType, &operands, &dataframe.rerunTime)
-		if parents != "" {
-			dataframe.parents = strings.Split(parents, ",")
-		}
-		if operands != "" {
-			dataframe.operands = make(map[string]string)
-			for _, part := range strings.Split(operands, ",") {
-				kv := strings.Split(part, "=")
-				dataframe.operands[kv[0]] = kv[1]
-			}
-		}
-		dataframes[dataframe.name] = dataframe
+// Operand and parent schema for each entry in OperatorFactories.
+var OperatorSchemas = map[string]OperatorSchema{
+	"raw_detection": RawDetectionSchema,
+	"raw_matrix": RawMatrixSchema,
+	"raw_sequence": RawSequenceSchema,
+	"obj_track": ObjTrackSchema,
+	"filter": FilterSchema,
+	"seq_merge": SeqMergeSchema,
+	"to_matrix": ToMatrixSchema,
+	"intersect": IntersectSchema,
+	"product": ProductSchema,
+	"thin": ThinSchema,
+	"time_shift": TimeShiftSchema,
+	"err_ttl": TTLErrorRateSchema,
+	"err_const": ConstErrorRateSchema,
+	"err_pattern": PatternErrorRateSchema,
+	"err_normalize": NormalizeErrorRateSchema,
+	"error": ErrorSchema,
+	"open_parking": OpenParkingSchema,
+	"regrid": RegridSchema,
+	"det_filter": DetFilterSchema,
+}
+
+func (ctx *Context) GetPipeline() (Pipeline, error) {
+	specs, err := ctx.GetDataframeSpecs()
+	if err != nil {
+		return nil, err
+	}
+	ctx.GSD, err = ctx.LoadGSD(ctx.GeorefName)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.BuildPipeline(specs)
+}
+
+// Create the pipeline graph for the specified dataframes, e.g. to explain the
+// effect of changes before they are applied.
+func (ctx *Context) BuildPipeline(specs map[string]DataframeSpec) (Pipeline, error) {
+	if err := ValidateDataframes(specs); err != nil {
+		return nil, err
+	}
+	grids, err := GetGrids(specs)
+	if err != nil {
+		return nil, err
+	}
+	dataframes := make(map[string]DataframeSpec)
+	for name, spec := range specs {
+		dataframes[name] = spec
 	}
 
 	operators := make(map[string]*Operator)
@@ -84,7 +138,7 @@ func GetPipeline() Pipeline {
 		for name, dataframe := range dataframes {
 			var parents []*Operator
 			haveParents := true
-			for _, parentName := range dataframe.parents {
+			for _, parentName := range dataframe.Parents {
 				if operators[parentName] == nil {
 					haveParents = false
 					break
@@ -95,32 +149,44 @@ func GetPipeline() Pipeline {
 				continue
 			}
 			op := &Operator{
+				Context: ctx,
 				Name: name,
+				Type: dataframe.OpType,
+				Operands: dataframe.Operands,
+				Grid: grids[name],
 				Parents: parents,
-				RerunTime: dataframe.rerunTime,
-				ChildRerunTime: dataframe.rerunTime,
+				RerunTime: dataframe.RerunTime,
+				ChildRerunTime: dataframe.RerunTime,
 			}
 			operators[name] = op
 			for _, parent := range parents {
 				parent.Children = append(parent.Children, op)
 			}
-			factory := OperatorFactories[dataframe.opType]
+			factory := OperatorFactories[dataframe.OpType]
 			if factory == nil {
-				panic(fmt.Errorf("unknown operator type %s", dataframe.opType))
+				return nil, fmt.Errorf("unknown operator type %s", dataframe.OpType)
 			}
-			factory(op, dataframe.operands)
+			factory(op, OperatorSchemas[dataframe.OpType].WithDefaults(dataframe.Operands))
 			delete(dataframes, name)
 		}
 		if len(dataframes) == remaining {
 			// we didn't make any progress on this iteration
-			panic(fmt.Errorf("got orphans when loading pipeline graph: %v", dataframes))
+			return nil, fmt.Errorf("got orphans when loading pipeline graph: %v", dataframes)
 		}
 	}
 
 	if !Quiet {
 		fmt.Printf("created pipeline with %d operators\n", len(operators))
 	}
-	return Pipeline(operators)
+	return Pipeline(operators), nil
+}
+
+// Returns the context of the pipeline's operators.
+func (pipeline Pipeline) Context() *Context {
+	for _, op := range pipeline {
+		return op.Context
+	}
+	return DefaultContext
 }
 
 /*op := operators["error_rate"]
@@ -130,38 +196,155 @@ for _, parent := range op.Parents {
 op.Execute()
 return*/
 
-func (pipeline Pipeline) RunAll() {
-	// execute operators one at a time
-	done := make(map[string]bool)
-	for _, op := range pipeline {
-		if len(op.Parents) > 0 {
-			continue
+func (pipeline Pipeline) RunAll() error {
+	return pipeline.RunParallel(Workers)
+}
+
+// Execute operators in dependency order, running up to workers operators at
+// a time. An operator is started once all of its parents are done, so
+// independent branches of the graph execute concurrently.
+// If an operator still fails after retrying, it is reported and its
+// descendants are skipped, but other branches continue.
+func (pipeline Pipeline) RunParallel(workers int) error {
+	return pipeline.schedule(workers, func(op *Operator) error {
+		if len(op.Parents) == 0 {
+			return op.PropogateRerunTime()
+		}
+		if !Quiet {
+			fmt.Printf("[main] executing operator %s\n", op.Name)
+		}
+		return op.Execute()
+	})
+}
+
+// Operators that failed during a run, and operators that were skipped
+// because one of their ancestors failed.
+type RunErrors struct {
+	Failed map[string]error
+	Skipped []string
+}
+
+func (errs RunErrors) Error() string {
+	var names []string
+	for name := range errs.Failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var strs []string
+	for _, name := range names {
+		strs = append(strs, fmt.Sprintf("%s: %v", name, errs.Failed[name]))
+	}
+	s := fmt.Sprintf("%d operators failed:\n  %s", len(errs.Failed), strings.Join(strs, "\n  "))
+	if len(errs.Skipped) > 0 {
+		s += fmt.Sprintf("\nskipped: %s", strings.Join(errs.Skipped, ", "))
+	}
+	return s
+}
+
+// Call f, converting a panic into an error.
+func tryFunc(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return f()
+}
+
+// Call f on the operator, converting a panic into an error.
+func tryOperator(op *Operator, f func(op *Operator) error) error {
+	return tryFunc(func() error {
+		return f(op)
+	})
+}
+
+// Call f on the operator until it succeeds or we run out of retries.
+func retryOperator(op *Operator, f func(op *Operator) error) error {
+	var err error
+	for attempt := 0; attempt <= Retries; attempt++ {
+		if attempt > 0 {
+			fmt.Printf("[main] retrying operator %s in %v (attempt %d/%d): %v\n", op.Name, RetryDelay, attempt, Retries, err)
+			time.Sleep(RetryDelay)
+		}
+		err = tryOperator(op, f)
+		if err == nil {
+			return nil
 		}
-		op.PropogateRerunTime()
-		done[op.Name] = true
 	}
-	for len(done) < len(pipeline) {
-		for _, op := range pipeline {
-			if done[op.Name] {
-				continue
-			}
+	return err
+}
 
-			parentsDone := true
-			for _, parent := range op.Parents {
-				if !done[parent.Name] {
-					parentsDone = false
-					break
-				}
+// Call f on every operator, after f has returned for all of its parents.
+// Operators whose parent failed are skipped. Returns RunErrors if any
+// operator failed.
+func (pipeline Pipeline) schedule(workers int, f func(op *Operator) error) error {
+	workers = pipeline.Context().maxWorkers(workers)
+	if workers < 1 {
+		workers = 1
+	}
+
+	// number of parents of each operator that are not done yet
+	pending := make(map[string]int)
+	var ready []*Operator
+	for _, op := range pipeline {
+		pending[op.Name] = len(op.Parents)
+		if len(op.Parents) == 0 {
+			ready = append(ready, op)
+		}
+	}
+	// operators with a failed or skipped parent
+	blocked := make(map[string]bool)
+	finish := func(op *Operator, ok bool) {
+		for _, child := range op.Children {
+			if !ok {
+				blocked[child.Name] = true
 			}
-			if !parentsDone {
-				continue
+			pending[child.Name]--
+			if pending[child.Name] == 0 {
+				ready = append(ready, child)
 			}
+		}
+	}
 
-			if !Quiet {
-				fmt.Printf("[main] executing operator %s\n", op.Name)
+	type result struct {
+		op *Operator
+		err error
+	}
+	errs := RunErrors{Failed: make(map[string]error)}
+	doneCh := make(chan result)
+	running := 0
+	for len(ready) > 0 || running > 0 {
+		sort.Slice(ready, func(i, j int) bool {
+			return ready[i].Name < ready[j].Name
+		})
+		for len(ready) > 0 && running < workers {
+			op := ready[0]
+			ready = ready[1:]
+			if blocked[op.Name] {
+				fmt.Printf("[main] skipping operator %s because a parent failed\n", op.Name)
+				errs.Skipped = append(errs.Skipped, op.Name)
+				finish(op, false)
+				continue
 			}
-			op.Execute()
-			done[op.Name] = true
+			running++
+			go func() {
+				doneCh <- result{op, retryOperator(op, f)}
+			}()
 		}
+		if running == 0 {
+			continue
+		}
+		res := <-doneCh
+		running--
+		if res.err != nil {
+			fmt.Printf("[main] operator %s failed: %v\n", res.op.Name, res.err)
+			errs.Failed[res.op.Name] = res.err
+		}
+		finish(res.op, res.err == nil)
+	}
+
+	if len(errs.Failed) > 0 {
+		return errs
 	}
+	return nil
 }
@@ -0,0 +1,258 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Textual query language.
+
+A query is a list of assignments, one per line:
+
+	car_traj = obj_track(cars, mode=iou)
+	tmp1 = filter(car_traj, length > 5)
+	parked_counts = to_matrix(parked_cars, ignore_zero=yes, func=count_sum)
+
+Each assignment defines a dataframe. The function name is the op_type, bare
+names are parent dataframes, key=value arguments are operands, and a
+comparison like "length > 5" is shorthand for left=length,op=>,right=5.
+Longer filter expressions like "length > 5 AND displacement < 75" are
+shorthand for the expr operand.
+Values containing spaces or commas must be double-quoted.
+Lines starting with # are comments.
+*/
+
+// Max length of dataframes.name column.
+const MaxDataframeNameLength int = 16
+
+type QueryError struct {
+	Line int
+	Msg string
+}
+
+func (e QueryError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+type queryToken struct {
+	// one of "ident", "string", "cmp", or the punctuation itself
+	kind string
+	text string
+}
+
+var queryComparisons = []string{"<=", ">=", "==", "!=", "<", ">"}
+
+func isQueryIdentChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-'
+}
+
+func tokenizeQueryLine(line string) ([]queryToken, error) {
+	var tokens []queryToken
+	i := 0
+	for i < len(line) {
+		c := line[i]
+		if c == ' ' || c == '\t' {
+			i++
+			continue
+		}
+		if c == '"' {
+			end := strings.IndexByte(line[i+1:], '"')
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, queryToken{"string", line[i+1:i+1+end]})
+			i += end + 2
+			continue
+		}
+		matchedCmp := false
+		for _, cmp := range queryComparisons {
+			if strings.HasPrefix(line[i:], cmp) {
+				tokens = append(tokens, queryToken{"cmp", cmp})
+				i += len(cmp)
+				matchedCmp = true
+				break
+			}
+		}
+		if matchedCmp {
+			continue
+		}
+		if c == '=' || c == '(' || c == ')' || c == ',' {
+			tokens = append(tokens, queryToken{string(c), string(c)})
+			i++
+			continue
+		}
+		if !isQueryIdentChar(c) {
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+		start := i
+		for i < len(line) && isQueryIdentChar(line[i]) {
+			i++
+		}
+		tokens = append(tokens, queryToken{"ident", line[start:i]})
+	}
+	return tokens, nil
+}
+
+// Returns whether an argument looks like a filter expression. Single
+// comparisons are checked first, since they map to left, op and right.
+func isFilterExpr(arg []queryToken) bool {
+	hasCmp := false
+	for _, token := range arg {
+		if token.kind == "=" || token.kind == "string" {
+			return false
+		} else if token.kind == "cmp" {
+			hasCmp = true
+		}
+	}
+	return hasCmp
+}
+
+// Parse one assignment into a spec, without resolving names.
+func parseQueryStatement(tokens []queryToken) (DataframeSpec, error) {
+	spec := DataframeSpec{Operands: make(map[string]string)}
+	if len(tokens) < 5 || tokens[0].kind != "ident" || tokens[1].kind != "=" || tokens[2].kind != "ident" || tokens[3].kind != "(" {
+		return spec, fmt.Errorf("expected assignment of the form: name = op(args...)")
+	}
+	if tokens[len(tokens)-1].kind != ")" {
+		return spec, fmt.Errorf("expected ) at end of line")
+	}
+	spec.Name = tokens[0].text
+	spec.OpType = tokens[2].text
+
+	// split arguments on commas outside parentheses
+	var args [][]queryToken
+	var cur []queryToken
+	depth := 0
+	for _, token := range tokens[4:len(tokens)-1] {
+		if token.kind == "," && depth == 0 {
+			args = append(args, cur)
+			cur = nil
+			continue
+		} else if token.kind == "(" {
+			depth++
+		} else if token.kind == ")" {
+			depth--
+			if depth < 0 {
+				return spec, fmt.Errorf("unexpected ) in arguments")
+			}
+		}
+		cur = append(cur, token)
+	}
+	if depth > 0 {
+		return spec, fmt.Errorf("expected ) in arguments")
+	}
+	if len(cur) > 0 || len(args) > 0 {
+		args = append(args, cur)
+	}
+
+	isValue := func(token queryToken) bool {
+		return token.kind == "ident" || token.kind == "string"
+	}
+	setOperand := func(k string, v string) error {
+		if _, ok := spec.Operands[k]; ok {
+			return fmt.Errorf("operand %s given more than once", k)
+		}
+		spec.Operands[k] = v
+		return nil
+	}
+
+	for _, arg := range args {
+		if len(arg) == 1 && arg[0].kind == "ident" {
+			spec.Parents = append(spec.Parents, arg[0].text)
+		} else if len(arg) == 3 && arg[0].kind == "ident" && arg[1].kind == "=" && isValue(arg[2]) {
+			if err := setOperand(arg[0].text, arg[2].text); err != nil {
+				return spec, err
+			}
+		} else if len(arg) == 3 && arg[0].kind == "ident" && arg[1].kind == "cmp" && isValue(arg[2]) {
+			for _, kv := range [][2]string{{"left", arg[0].text}, {"op", arg[1].text}, {"right", arg[2].text}} {
+				if err := setOperand(kv[0], kv[1]); err != nil {
+					return spec, err
+				}
+			}
+		} else if isFilterExpr(arg) {
+			if err := setOperand("expr", joinFilterTokens(arg)); err != nil {
+				return spec, err
+			}
+		} else if len(arg) == 0 {
+			return spec, fmt.Errorf("empty argument")
+		} else {
+			var strs []string
+			for _, token := range arg {
+				strs = append(strs, token.text)
+			}
+			return spec, fmt.Errorf("cannot parse argument %q", strings.Join(strs, " "))
+		}
+	}
+
+	for k, v := range spec.Operands {
+		if strings.ContainsAny(k + v, ",") {
+			return spec, fmt.Errorf("operand %s=%s cannot contain a comma", k, v)
+		}
+	}
+	if len(spec.Operands) == 0 {
+		spec.Operands = nil
+	}
+	return spec, nil
+}
+
+// Parse a query, resolve names against the statements in the query and the
+// existing dataframes, and check each statement against OperatorSchemas.
+// Returns the specs in the order they appear in the query.
+func CompileQuery(src string, existing map[string]DataframeSpec) ([]DataframeSpec, error) {
+	var specs []DataframeSpec
+	defined := make(map[string]int)
+	known := make(map[string]DataframeSpec)
+	for name, spec := range existing {
+		known[name] = spec
+	}
+	for lineIdx, line := range strings.Split(src, "\n") {
+		lineNum := lineIdx + 1
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens, err := tokenizeQueryLine(line)
+		if err != nil {
+			return nil, QueryError{lineNum, err.Error()}
+		}
+		spec, err := parseQueryStatement(tokens)
+		if err != nil {
+			return nil, QueryError{lineNum, err.Error()}
+		}
+
+		if len(spec.Name) > MaxDataframeNameLength {
+			return nil, QueryError{lineNum, fmt.Sprintf("dataframe name %s is longer than %d characters", spec.Name, MaxDataframeNameLength)}
+		}
+		if prevLine, ok := defined[spec.Name]; ok {
+			return nil, QueryError{lineNum, fmt.Sprintf("dataframe %s already defined on line %d", spec.Name, prevLine)}
+		}
+		if OperatorFactories[spec.OpType] == nil {
+			return nil, QueryError{lineNum, fmt.Sprintf("unknown operator %s", spec.OpType)}
+		}
+		for _, parent := range spec.Parents {
+			if parent == spec.Name {
+				return nil, QueryError{lineNum, fmt.Sprintf("dataframe %s cannot be its own parent", spec.Name)}
+			}
+			if _, ok := defined[parent]; ok {
+				continue
+			} else if _, ok := existing[parent]; ok {
+				continue
+			}
+			return nil, QueryError{lineNum, fmt.Sprintf("unknown dataframe %s", parent)}
+		}
+
+		defined[spec.Name] = lineNum
+		specs = append(specs, spec)
+		known[spec.Name] = spec
+		if errs := validateDataframe(spec, known); len(errs) > 0 {
+			var strs []string
+			for _, err := range errs {
+				strs = append(strs, err.Error())
+			}
+			return nil, QueryError{lineNum, strings.Join(strs, "; ")}
+		}
+	}
+
+	return specs, nil
+}
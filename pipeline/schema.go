@@ -0,0 +1,272 @@
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kind of data that an operator emits to its children.
+type DataKind string
+const (
+	DetectionKind DataKind = "detections"
+	SequenceKind DataKind = "sequences"
+	MatrixKind DataKind = "matrix"
+)
+
+// Operand types.
+// Bool operands are "yes" or "no", region operands are four integers
+// "sx sy ex ey" as parsed by GetErrorRateCells, and point operands are two
+// numbers "x y" as parsed by ParsePoint. Filter operands are expressions as
+// parsed by ParseFilterExpr, and length operands are distances like "2m" or
+// "40px" as parsed by ParseLength.
+const (
+	StringOperand = "string"
+	IntOperand = "int"
+	FloatOperand = "float"
+	BoolOperand = "bool"
+	RegionOperand = "region"
+	PointOperand = "point"
+	FilterOperand = "filter"
+	LengthOperand = "length"
+)
+
+type OperandSpec struct {
+	Name string
+	Type string
+	Default string
+	Required bool
+
+	// If set, the operand must be one of these values.
+	Allowed []string
+}
+
+type OperatorSchema struct {
+	Output DataKind
+
+	// Accepted kind of each parent, in order.
+	Parents []DataKind
+
+	Operands []OperandSpec
+
+	// Optional check of the operands together, called if each operand is
+	// valid on its own.
+	Check func(operands map[string]string) error
+
+	// Optional check of the operands against the grid of a matrix operator
+	// and the grids of its matrix parents, see GetGrids.
+	CheckGrids func(operands map[string]string, grid Grid, parentGrids []Grid) error
+}
+
+func (spec OperandSpec) check(v string) error {
+	if len(spec.Allowed) > 0 {
+		ok := false
+		for _, allowed := range spec.Allowed {
+			if v == allowed {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("operand %s=%s must be one of %s", spec.Name, v, strings.Join(spec.Allowed, ", "))
+		}
+	}
+	var err error
+	switch spec.Type {
+	case IntOperand:
+		_, err = strconv.Atoi(v)
+	case FloatOperand:
+		_, err = strconv.ParseFloat(v, 64)
+	case BoolOperand:
+		if v != "yes" && v != "no" {
+			err = fmt.Errorf("expected yes or no")
+		}
+	case RegionOperand:
+		parts := strings.Fields(v)
+		if v != "" && len(parts) != 4 {
+			err = fmt.Errorf("expected four integers")
+		}
+		for _, part := range parts {
+			if _, perr := strconv.Atoi(part); perr != nil {
+				err = perr
+			}
+		}
+	case PointOperand:
+		_, err = ParsePoint(v)
+	case FilterOperand:
+		_, err = ParseFilterExpr(v)
+	case LengthOperand:
+		_, err = ParseLength(v)
+	}
+	if err != nil {
+		return fmt.Errorf("operand %s=%s is not a valid %s: %v", spec.Name, v, spec.Type, err)
+	}
+	return nil
+}
+
+// Check operands against the schema.
+func (schema OperatorSchema) CheckOperands(operands map[string]string) []error {
+	var errs []error
+	known := make(map[string]bool)
+	for _, spec := range schema.Operands {
+		known[spec.Name] = true
+		v, ok := operands[spec.Name]
+		if !ok {
+			if spec.Required {
+				errs = append(errs, fmt.Errorf("missing required operand %s", spec.Name))
+			}
+			continue
+		}
+		if err := spec.check(v); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 && schema.Check != nil {
+		if err := schema.Check(operands); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	var unknown []string
+	for k := range operands {
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	for _, k := range unknown {
+		var names []string
+		for _, spec := range schema.Operands {
+			names = append(names, spec.Name)
+		}
+		if len(names) == 0 {
+			errs = append(errs, fmt.Errorf("unknown operand %s; operator takes no operands", k))
+		} else {
+			errs = append(errs, fmt.Errorf("unknown operand %s; expected one of %s", k, strings.Join(names, ", ")))
+		}
+	}
+	return errs
+}
+
+// Returns a copy of operands with defaults filled in.
+func (schema OperatorSchema) WithDefaults(operands map[string]string) map[string]string {
+	m := make(map[string]string)
+	for _, spec := range schema.Operands {
+		if spec.Default != "" {
+			m[spec.Name] = spec.Default
+		}
+	}
+	for k, v := range operands {
+		m[k] = v
+	}
+	return m
+}
+
+type ValidationErrors []error
+
+func (errs ValidationErrors) Error() string {
+	var strs []string
+	for _, err := range errs {
+		strs = append(strs, err.Error())
+	}
+	return fmt.Sprintf("pipeline has %d errors:\n  %s", len(errs), strings.Join(strs, "\n  "))
+}
+
+// Check operator type, operands, and parents of one dataframe.
+func validateDataframe(spec DataframeSpec, specs map[string]DataframeSpec) []error {
+	schema, ok := OperatorSchemas[spec.OpType]
+	if !ok {
+		return []error{fmt.Errorf("unknown operator type")}
+	}
+	errs := schema.CheckOperands(spec.Operands)
+	if len(spec.Parents) != len(schema.Parents) {
+		errs = append(errs, fmt.Errorf("expected %d parents, got %d", len(schema.Parents), len(spec.Parents)))
+		return errs
+	}
+	for i, parentName := range spec.Parents {
+		parent, ok := specs[parentName]
+		if !ok {
+			errs = append(errs, fmt.Errorf("parent %s does not exist", parentName))
+			continue
+		}
+		parentSchema, ok := OperatorSchemas[parent.OpType]
+		if !ok {
+			continue
+		}
+		if parentSchema.Output != schema.Parents[i] {
+			errs = append(errs, fmt.Errorf("parent %d (%s) emits %s, expected %s", i+1, parentName, parentSchema.Output, schema.Parents[i]))
+		}
+	}
+	return errs
+}
+
+// Validate operator types, operands, and parents of the dataframes.
+// Returns nil if the dataframes form a valid pipeline.
+func ValidateDataframes(specs map[string]DataframeSpec) error {
+	var names []string
+	for name := range specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs ValidationErrors
+	for _, name := range names {
+		for _, err := range validateDataframe(specs[name], specs) {
+			errs = append(errs, fmt.Errorf("dataframe %s (%s): %v", name, specs[name].OpType, err))
+		}
+	}
+
+	// check for cycles, which would otherwise show up as orphans in GetPipeline
+	state := make(map[string]int)
+	var visit func(name string) bool
+	visit = func(name string) bool {
+		if state[name] == 1 {
+			return false
+		} else if state[name] == 2 {
+			return true
+		}
+		state[name] = 1
+		for _, parent := range specs[name].Parents {
+			if _, ok := specs[parent]; ok && !visit(parent) {
+				return false
+			}
+		}
+		state[name] = 2
+		return true
+	}
+	for _, name := range names {
+		if state[name] == 0 && !visit(name) {
+			errs = append(errs, fmt.Errorf("dataframe %s depends on a cycle", name))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	// matrix parents of an operator must have the same grid
+	grids, err := GetGrids(specs)
+	if err != nil {
+		return ValidationErrors{err}
+	}
+	for _, name := range names {
+		spec := specs[name]
+		schema := OperatorSchemas[spec.OpType]
+		if schema.CheckGrids == nil {
+			continue
+		}
+		var parentGrids []Grid
+		for i, kind := range schema.Parents {
+			if kind == MatrixKind {
+				parentGrids = append(parentGrids, grids[spec.Parents[i]])
+			}
+		}
+		if err := schema.CheckGrids(schema.WithDefaults(spec.Operands), grids[name], parentGrids); err != nil {
+			errs = append(errs, fmt.Errorf("dataframe %s (%s): %v", name, spec.OpType, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
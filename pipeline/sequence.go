@@ -20,32 +20,40 @@ type Sequence struct {
 	Terminated *time.Time
 	dataframe string
 	metadata *[]string
+
+	// Driver that stores the sequence, which the methods below update.
+	driver Driver
 }
 
-func NewSequence(dataframe string, t time.Time) *Sequence {
-	return driver.AddSequence(dataframe, t)
+// Adds a sequence to the dataframe in DefaultContext.
+func NewSequence(dataframe string, t time.Time) (*Sequence, error) {
+	return DefaultContext.Driver.AddSequence(dataframe, t)
 }
 
-func (seq *Sequence) Terminate(t time.Time) {
-	driver.TerminateSequence(seq, t)
+func (seq *Sequence) Terminate(t time.Time) error {
+	return seq.driver.TerminateSequence(seq, t)
 }
 
-func (seq *Sequence) AddMember(detection *Detection, t time.Time) {
-	driver.AddSequenceMember(seq, detection, t)
+func (seq *Sequence) AddMember(detection *Detection, t time.Time) error {
+	return seq.driver.AddSequenceMember(seq, detection, t)
 }
 
-func (seq *Sequence) GetMetadata() []string {
+func (seq *Sequence) GetMetadata() ([]string, error) {
 	if seq.metadata == nil {
-		metadata := driver.GetSequenceMetadata(seq)
+		metadata, err := seq.driver.GetSequenceMetadata(seq)
+		if err != nil {
+			return nil, err
+		}
 		seq.metadata = &metadata
 	}
-	return *seq.metadata
+	return *seq.metadata, nil
 }
 
-func (seq *Sequence) AddMetadata(metadata string, t time.Time) {
-	driver.AddSequenceMetadata(seq, metadata, t)
+func (seq *Sequence) AddMetadata(metadata string, t time.Time) error {
+	return seq.driver.AddSequenceMetadata(seq, metadata, t)
 }
 
+
 // Returns location of this sequence at specified time,
 // or nil if the time is before first member or after last member.
 // To compute location, we take center-point of rectangle bound of
@@ -82,14 +90,16 @@ func (seq *Sequence) LocationAt(t time.Time) *common.Point {
 	return &location
 }
 
-func GetUnterminatedSequences(dataframe string) map[int]*Sequence {
-	return driver.GetUnterminatedSequences(dataframe)
+// Functions on the dataframes of DefaultContext.
+
+func GetUnterminatedSequences(dataframe string) (map[int]*Sequence, error) {
+	return DefaultContext.Driver.GetUnterminatedSequences(dataframe)
 }
 
-func GetSequencesAfter(dataframe string, t time.Time) map[int]*Sequence {
-	return driver.GetSequencesAfter(dataframe, t)
+func GetSequencesAfter(dataframe string, t time.Time) (map[int]*Sequence, error) {
+	return DefaultContext.Driver.GetSequencesAfter(dataframe, t)
 }
 
-func GetSequences(dataframe string) map[int]*Sequence {
-	return driver.GetSequences(dataframe)
+func GetSequences(dataframe string) (map[int]*Sequence, error) {
+	return DefaultContext.Driver.GetSequences(dataframe)
 }
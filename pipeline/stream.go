@@ -0,0 +1,185 @@
+package pipeline
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+Streaming execution.
+
+A Stream keeps a pipeline in memory and pushes frames through the operator
+graph as they arrive, instead of recomputing from the rerun times on every run.
+
+Start() catches up like RunAll. After that, each Step() finds frames that are
+newer than the latest processed frame (the watermark) and feeds only those
+frames into the operators through ExecuteFrames, so in-memory state like the
+active sequences in obj_track or the cell statuses in to_matrix carries over
+between batches.
+
+If a parent modified data from before the batch (for example, obj_track
+extending a sequence that started earlier), then the child's rerun time drops
+below the batch start, and the child falls back to a regular execution from
+that rerun time. Operators rebuild their in-memory state in InitFunc, so
+nothing from the earlier batches carries over into the re-execution.
+
+Frames are picked up in time order once they are aligned (have bounds).
+Frames that arrive with a time before the watermark are not picked up; lower
+the rerun times and restart the stream to process them.
+
+After every step, rerun times in the dataframes table are set to the
+watermark, so a restarted stream (or a batch run) resumes from there.
+
+Each operator's work in a step is committed in one transaction together with
+its checkpoint. If an operator fails during a step, its changes are rolled
+back, the watermark is not advanced, and every operator re-executes from the
+batch start on the next step.
+*/
+
+type Stream struct {
+	Pipeline Pipeline
+	Workers int
+
+	// Time of the latest frame that has been processed.
+	Watermark time.Time
+}
+
+func NewStream(pipeline Pipeline) *Stream {
+	return &Stream{
+		Pipeline: pipeline,
+		Workers: Workers,
+	}
+}
+
+// Process frames from the rerun times in the dataframes table up to the
+// latest frame.
+func (s *Stream) Start() error {
+	latest, err := s.Pipeline.Context().Driver.GetPredecessorFrames(time.Now().Add(9999*time.Hour), 1)
+	if err != nil {
+		return err
+	} else if len(latest) == 0 {
+		return nil
+	}
+	watermark := latest[0].Time
+	err = s.Pipeline.schedule(s.Workers, func(op *Operator) error {
+		return op.transaction(func() error {
+			if len(op.Parents) == 0 {
+				if err := op.PropogateRerunTime(); err != nil {
+					return err
+				}
+			} else {
+				if err := op.executeUntil(watermark); err != nil {
+					return err
+				}
+			}
+			return op.checkpoint(watermark)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	s.Watermark = watermark
+	return nil
+}
+
+// Returns aligned frames after the watermark, in time order.
+func (s *Stream) getNewFrames() ([]*Frame, error) {
+	allFrames, err := s.Pipeline.Context().Driver.GetFramesStartingFrom(s.Watermark)
+	if err != nil {
+		return nil, err
+	}
+	var frames []*Frame
+	for _, frame := range allFrames {
+		if !frame.Time.After(s.Watermark) {
+			continue
+		}
+		if len(frame.Bounds) == 0 {
+			// wait for this frame to be aligned before processing later frames
+			break
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+// Process frames that arrived since the previous step.
+// Returns the number of new frames.
+func (s *Stream) Step() (int, error) {
+	frames, err := s.getNewFrames()
+	if err != nil {
+		return 0, err
+	} else if len(frames) == 0 {
+		return 0, nil
+	}
+	start := frames[0].Time
+	end := frames[len(frames)-1].Time
+
+	for _, op := range s.Pipeline {
+		op.RerunTime = start
+		op.ChildRerunTime = start
+	}
+
+	err = s.Pipeline.schedule(s.Workers, func(op *Operator) error {
+		return op.transaction(func() error {
+			if len(op.Parents) == 0 {
+				// nothing to execute, but children need our rerun time
+			} else if !op.initialized || op.RerunTime.Before(start) {
+				if !Quiet {
+					fmt.Printf("[stream] re-executing operator %s from %v\n", op.Name, op.RerunTime)
+				}
+				if err := op.executeUntil(end); err != nil {
+					return err
+				}
+			} else {
+				if err := op.executeFrames(frames, nil); err != nil {
+					return err
+				}
+			}
+			if err := op.PropogateRerunTime(); err != nil {
+				return err
+			}
+			return op.checkpoint(end)
+		})
+	})
+	if err != nil {
+		// in-memory state may be partially updated, so redo the batch
+		for _, op := range s.Pipeline {
+			op.initialized = false
+		}
+		return 0, err
+	}
+
+	s.Watermark = end
+	if !Quiet {
+		fmt.Printf("[stream] processed %d frames up to %v\n", len(frames), end)
+	}
+	return len(frames), nil
+}
+
+// Start the stream and then poll for new frames forever.
+// Errors are reported and the failed work is retried after pollInterval.
+func (s *Stream) Run(pollInterval time.Duration) {
+	for {
+		err := s.Start()
+		if err == nil {
+			break
+		}
+		fmt.Printf("[stream] start failed: %v\n", err)
+		time.Sleep(pollInterval)
+	}
+	for {
+		count, err := s.Step()
+		if err != nil {
+			fmt.Printf("[stream] step failed: %v\n", err)
+		}
+		if count == 0 {
+			time.Sleep(pollInterval)
+		}
+	}
+}
+
+// Record that the operator is up to date through the specified time.
+func (op *Operator) checkpoint(t time.Time) error {
+	_, err := op.Context.dbFor(op.Name).Exec("UPDATE dataframes SET rerun_time = ? WHERE name = ?", t, op.Name)
+	return err
+}
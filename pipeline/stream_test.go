@@ -0,0 +1,97 @@
+package pipeline
+
+import (
+	"github.com/mitroadmaps/gomapinfer/common"
+
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Returns a context with a new SQLite database (see schema-sqlite.sql) and an
+// InMemoryDriver.
+func newTestContext(t *testing.T) (*Context, *InMemoryDriver) {
+	schema, err := ioutil.ReadFile("../schema-sqlite.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := OpenDatabase(SQLiteDialect{}, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.db.Close()
+	})
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	driver := NewInMemoryDriver()
+	return NewContext(db, driver), driver
+}
+
+// Add a frame with one detection in the same place as the other frames, so
+// that obj_track extends the same sequence.
+func addTestFrame(t *testing.T, driver *InMemoryDriver, idx int, frameTime time.Time) {
+	poly := common.Polygon{{0, 0}, {100, 0}, {100, 100}, {0, 100}}
+	frame, err := driver.AddFrame(0, idx, frameTime, poly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	detection := &Detection{
+		Time: frame.Time,
+		FramePolygon: poly,
+		Polygon: poly,
+		FrameID: frame.ID,
+		Confidence: 1,
+	}
+	if err := driver.AddDetection("dets", detection); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A step that extends a sequence from an earlier batch lowers the filter's
+// rerun time below the batch start, so the filter re-executes. The re-executed
+// filter must emit the sequence again after undoing it.
+func TestStreamReexecute(t *testing.T) {
+	Quiet = true
+	ctx, driver := newTestContext(t)
+	specs := []DataframeSpec{
+		{Name: "dets", OpType: "raw_detection"},
+		{Name: "tracks", Parents: []string{"dets"}, OpType: "obj_track"},
+		{Name: "long", Parents: []string{"tracks"}, OpType: "filter", Operands: map[string]string{"expr": "length >= 2"}},
+	}
+	if err := ctx.ApplyDataframeChanges(DiffDataframes(nil, specs)); err != nil {
+		t.Fatal(err)
+	}
+	pipeline, err := ctx.GetPipeline()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	addTestFrame(t, driver, 0, base)
+	addTestFrame(t, driver, 1, base.Add(time.Second))
+	stream := NewStream(pipeline)
+	stream.Workers = 1
+	if err := stream.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if seqs, _ := driver.GetSequences("long"); len(seqs) != 1 {
+		t.Fatalf("expected 1 sequence after start, got %d", len(seqs))
+	}
+
+	addTestFrame(t, driver, 2, base.Add(2*time.Second))
+	count, err := stream.Step()
+	if err != nil {
+		t.Fatal(err)
+	} else if count != 1 {
+		t.Fatalf("expected 1 new frame, got %d", count)
+	}
+	if seqs, _ := driver.GetSequences("tracks"); len(seqs) != 1 {
+		t.Fatalf("expected obj_track to extend its sequence, got %d sequences", len(seqs))
+	}
+	if seqs, _ := driver.GetSequences("long"); len(seqs) != 1 {
+		t.Fatalf("expected 1 sequence after re-executing, got %d", len(seqs))
+	}
+}
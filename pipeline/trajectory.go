@@ -0,0 +1,198 @@
+package pipeline
+
+import (
+	"github.com/mitroadmaps/gomapinfer/common"
+
+	"math"
+)
+
+// Trajectory metrics of sequences, used by filter and to_matrix.
+// Positions are centers of detection bounds, in orthoimage pixels, and times
+// are in seconds.
+
+// Speed in pixels/sec below which an object is considered stationary.
+var StationarySpeed float64 = 10
+
+// Speed in meters/sec below which an object is considered stationary, for the
+// stationary_mps metric, which does not depend on the orthoimage resolution.
+var StationarySpeedMPS float64 = 0.5
+
+func (seq *Sequence) points() []common.Point {
+	points := make([]common.Point, len(seq.Members))
+	for i, member := range seq.Members {
+		points[i] = member.Detection.Polygon.Bounds().Center()
+	}
+	return points
+}
+
+// Calls f on each pair of consecutive members, with the vector between their
+// centers and the time between them.
+func (seq *Sequence) forEachStep(f func(v common.Point, dt float64)) {
+	points := seq.points()
+	for i := 1; i < len(points); i++ {
+		dt := seq.Members[i].Detection.Time.Sub(seq.Members[i-1].Detection.Time).Seconds()
+		f(points[i].Sub(points[i-1]), dt)
+	}
+}
+
+// Distance from the first member to the last member.
+func (seq *Sequence) Displacement() float64 {
+	points := seq.points()
+	return points[0].Distance(points[len(points)-1])
+}
+
+// Total distance travelled between consecutive members.
+func (seq *Sequence) PathLength() float64 {
+	var length float64
+	seq.forEachStep(func(v common.Point, dt float64) {
+		length += v.Magnitude()
+	})
+	return length
+}
+
+func (seq *Sequence) duration() float64 {
+	start := seq.Members[0].Detection.Time
+	end := seq.Members[len(seq.Members)-1].Detection.Time
+	return end.Sub(start).Seconds()
+}
+
+// Path length over duration, or 0 if the sequence has no duration.
+func (seq *Sequence) MeanSpeed() float64 {
+	duration := seq.duration()
+	if duration <= 0 {
+		return 0
+	}
+	return seq.PathLength() / duration
+}
+
+// Maximum speed between consecutive members.
+func (seq *Sequence) MaxSpeed() float64 {
+	var maxSpeed float64
+	seq.forEachStep(func(v common.Point, dt float64) {
+		if dt > 0 && v.Magnitude() / dt > maxSpeed {
+			maxSpeed = v.Magnitude() / dt
+		}
+	})
+	return maxSpeed
+}
+
+// Mean direction of travel in degrees from the positive x axis towards the
+// positive y axis in [0, 360). Image y points down, so this is clockwise as
+// seen on the orthoimage, e.g. 90 is moving down the image. This is the
+// direction of the sum of the steps, i.e. from the first member to the last
+// member.
+func (seq *Sequence) MeanHeading() float64 {
+	points := seq.points()
+	v := points[len(points)-1].Sub(points[0])
+	if v.Magnitude() == 0 {
+		return 0
+	}
+	heading := math.Atan2(v.Y, v.X) * 180 / math.Pi
+	if heading < 0 {
+		heading += 360
+	}
+	return heading
+}
+
+// Total absolute change in direction between consecutive steps, in degrees.
+// Steps where the object does not move are skipped.
+func (seq *Sequence) HeadingChange() float64 {
+	var total float64
+	var prev common.Point
+	seq.forEachStep(func(v common.Point, dt float64) {
+		if v.Magnitude() == 0 {
+			return
+		}
+		if prev.Magnitude() > 0 {
+			angle := math.Atan2(v.Y, v.X) - math.Atan2(prev.Y, prev.X)
+			for angle > math.Pi {
+				angle -= 2*math.Pi
+			}
+			for angle < -math.Pi {
+				angle += 2*math.Pi
+			}
+			total += math.Abs(angle) * 180 / math.Pi
+		}
+		prev = v
+	})
+	return total
+}
+
+// Mean area of the bounding boxes of the detections.
+func (seq *Sequence) BoxArea() float64 {
+	var sum float64
+	for _, member := range seq.Members {
+		sum += member.Detection.Polygon.Bounds().Area()
+	}
+	return sum / float64(len(seq.Members))
+}
+
+// Mean ratio of the long side to the short side of the bounding boxes of the
+// detections. Degenerate boxes are skipped.
+func (seq *Sequence) BoxAspectRatio() float64 {
+	var sum, count float64
+	for _, member := range seq.Members {
+		lengths := member.Detection.Polygon.Bounds().Lengths()
+		short, long := math.Min(lengths.X, lengths.Y), math.Max(lengths.X, lengths.Y)
+		if short <= 0 {
+			continue
+		}
+		sum += long / short
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / count
+}
+
+// Maximum time between consecutive members.
+func (seq *Sequence) MaxGap() float64 {
+	var maxGap float64
+	seq.forEachStep(func(v common.Point, dt float64) {
+		if dt > maxGap {
+			maxGap = dt
+		}
+	})
+	return maxGap
+}
+
+// Displacement over path length, as a percentage. A sequence that moves in a
+// straight line has straightness 100, and one that does not move has 0.
+func (seq *Sequence) Straightness() float64 {
+	length := seq.PathLength()
+	if length == 0 {
+		return 0
+	}
+	return 100 * seq.Displacement() / length
+}
+
+// Percentage of the duration where the speed between consecutive members is
+// below StationarySpeed. A sequence with no duration has 0.
+func (seq *Sequence) StationaryPercent() float64 {
+	return seq.stationaryPercent(StationarySpeed)
+}
+
+// Like StationaryPercent, with a threshold speed in pixels/sec.
+func (seq *Sequence) stationaryPercent(speed float64) float64 {
+	duration := seq.duration()
+	if duration <= 0 {
+		return 0
+	}
+	var stationary float64
+	seq.forEachStep(func(v common.Point, dt float64) {
+		if dt > 0 && v.Magnitude() / dt < speed {
+			stationary += dt
+		}
+	})
+	return 100 * stationary / duration
+}
+
+// Mean detector confidence of the detections, in [0, 1].
+func (seq *Sequence) MeanConfidence() float64 {
+	var sum float64
+	for _, member := range seq.Members {
+		sum += member.Detection.Confidence
+	}
+	return sum / float64(len(seq.Members))
+}
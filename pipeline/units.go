@@ -0,0 +1,132 @@
+package pipeline
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+Physical units.
+
+Detections are in orthoimage pixels, so distances in pixels depend on the
+resolution of the orthoimage. Metrics and thresholds can instead be given in
+meters, which are converted with the ground sample distance (GSD) of the
+area: the size of an orthoimage pixel on the ground. The GSD is set in the
+areas table, or else computed from the area's georef.
+
+Each Context has its own GSD, which GetPipeline loads for the context's
+GeorefName, so pipelines of different contexts can be for different areas.
+*/
+
+// Returns the GSD of the area, or 0 if it has neither a GSD setting nor a
+// georef.
+func LoadGSD(area string) (float64, error) {
+	return DefaultContext.LoadGSD(area)
+}
+
+func (ctx *Context) LoadGSD(area string) (float64, error) {
+	rows, err := ctx.DB.Query("SELECT gsd FROM areas WHERE name = ?", area)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		var gsd float64
+		err := rows.Scan(&gsd)
+		return gsd, err
+	} else if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	georef, err := ctx.GetGeoref(area)
+	if err != nil || georef == nil {
+		return 0, err
+	}
+	return georef.MetersPerPixel(), nil
+}
+
+func SetGSD(area string, gsd float64) error {
+	return DefaultContext.SetGSD(area, gsd)
+}
+
+func (ctx *Context) SetGSD(area string, gsd float64) error {
+	_, err := ctx.DB.Exec(ctx.DB.Dialect().Replace("areas", "name", "gsd"), area, gsd)
+	return err
+}
+
+func (ctx *Context) requireGSD() error {
+	if ctx.GSD <= 0 {
+		return fmt.Errorf("meters need the ground sample distance of area %s, see set-gsd.go", ctx.GeorefName)
+	}
+	return nil
+}
+
+// A distance in meters or orthoimage pixels.
+type Length struct {
+	Val float64
+	Meters bool
+}
+
+// Parses a distance like "2.5m", "40px", or "40" (pixels).
+func ParseLength(s string) (Length, error) {
+	var l Length
+	if strings.HasSuffix(s, "px") {
+		s = strings.TrimSuffix(s, "px")
+	} else if strings.HasSuffix(s, "m") {
+		s = strings.TrimSuffix(s, "m")
+		l.Meters = true
+	}
+	var err error
+	l.Val, err = strconv.ParseFloat(s, 64)
+	return l, err
+}
+
+func (l Length) String() string {
+	if l.Meters {
+		return fmt.Sprintf("%vm", l.Val)
+	}
+	return fmt.Sprintf("%vpx", l.Val)
+}
+
+// Returns the length in orthoimage pixels, converting meters with the GSD of
+// the context.
+func (l Length) Pixels(ctx *Context) (float64, error) {
+	if !l.Meters {
+		return l.Val, nil
+	} else if err := ctx.requireGSD(); err != nil {
+		return 0, err
+	}
+	return l.Val / ctx.GSD, nil
+}
+
+// Returns whether a metric name is in meters, i.e. ends with _m, _m2 or _mps.
+func isMeterMetric(name string) bool {
+	return strings.HasSuffix(name, "_m") || strings.HasSuffix(name, "_m2") || strings.HasSuffix(name, "_mps")
+}
+
+// A metric in meters, given the GSD.
+type meterMetric func(seq *Sequence, gsd float64) float64
+
+// Converts a metric in pixels (or pixels squared for power 2) to meters.
+func inMeters(f FilterFunc, power float64) meterMetric {
+	return func(seq *Sequence, gsd float64) float64 {
+		return f(seq) * math.Pow(gsd, power)
+	}
+}
+
+// Returns the metric with the GSD of the context.
+func (ctx *Context) withGSD(m meterMetric) FilterFunc {
+	return func(seq *Sequence) float64 {
+		return m(seq, ctx.GSD)
+	}
+}
+
+// Returns the metric in FilterFuncs, or nil if there is none. Metrics in
+// meters use the GSD of the context.
+func (ctx *Context) getFilterFunc(name string) FilterFunc {
+	if m, ok := meterFilterFuncs[name]; ok {
+		return ctx.withGSD(m)
+	}
+	return FilterFuncs[name]
+}
@@ -61,14 +61,54 @@ func (drone DroneStatus) IsActive(base [2]int) bool {
 }
 
 type Router struct {
+	// Context of the dataframe, or pipeline.DefaultContext if nil.
+	Context *pipeline.Context
 	Dataframe string
 	Base [2]int
+
+	// Grid of the drone cells, Base and routes. If set and the dataframe has a
+	// different grid, each dataframe cell is mapped to the cell containing its
+	// center, keeping the highest value, so the dataframe should be at least
+	// as fine as this grid. Otherwise dataframe cells are used as-is.
+	Grid pipeline.Grid
+}
+
+// Load the dataframe in the router's grid.
+func (r Router) loadMatrix() (map[[2]int]*pipeline.MatrixData, error) {
+	ctx := r.Context
+	if ctx == nil {
+		ctx = pipeline.DefaultContext
+	}
+	matrix, err := ctx.LoadMatrix(r.Dataframe)
+	if err != nil || r.Grid == (pipeline.Grid{}) {
+		return matrix, err
+	}
+	grid, err := ctx.GetDataframeGrid(r.Dataframe)
+	if err != nil {
+		return nil, err
+	} else if grid == r.Grid {
+		return matrix, nil
+	}
+	routerMatrix := make(map[[2]int]*pipeline.MatrixData)
+	for cell, md := range matrix {
+		routerCell := pipeline.ToCell(pipeline.GetCellRect(cell, grid).Center(), r.Grid)
+		if routerMatrix[routerCell] != nil && routerMatrix[routerCell].Val >= md.Val {
+			continue
+		}
+		routerMD := *md
+		routerMD.I, routerMD.J = routerCell[0], routerCell[1]
+		routerMatrix[routerCell] = &routerMD
+	}
+	return routerMatrix, nil
 }
 
 var idx int = 0
 
 func (r Router) GetRoutes(ignoreCells map[[2]int]bool, drones []DroneStatus) [][][2]int {
-	matrix := pipeline.LoadMatrix(r.Dataframe)
+	matrix, err := r.loadMatrix()
+	if err != nil {
+		panic(err)
+	}
 	cells := make(map[[2]int]int)
 	var countNonzero int = 0
 	for cell, md := range matrix {
@@ -134,7 +174,7 @@ func ReadJSON(fname string) ([]DroneStatus, map[[2]int]int, [2]int) {
 
 func Evaluate(fname string) {
 	drones, cells, base := ReadJSON(fname)
-	r := Router{"fake", base}
+	r := Router{nil, "fake", base, pipeline.Grid{}}
 	routes := r.getRoutesPython(drones, cells)
 	fmt.Println(drones)
 	fmt.Println(cells)
@@ -242,7 +282,10 @@ func (r Router) GetRoutes3(drones []DroneStatus) [][][2]int {
 	// 2) divide by a custom factor
 	// 3) find that many top-priority cells
 	// 4) repeatedly assign those cells to closest drones
-	matrix := pipeline.LoadMatrix(r.Dataframe)
+	matrix, err := r.loadMatrix()
+	if err != nil {
+		panic(err)
+	}
 	var batterySum int = 0
 	for _, drone := range drones {
 		if drone.IsActive(r.Base) {
@@ -327,7 +370,10 @@ func (r Router) GetRoutes2(drones []DroneStatus) [][][2]int {
 	// 1) find (# drones) highest priority cells
 	// 2) for each cell, assign to route of closest drone that hasn't been scheduled yet
 	// 3) repeat
-	matrix := pipeline.LoadMatrix(r.Dataframe)
+	matrix, err := r.loadMatrix()
+	if err != nil {
+		panic(err)
+	}
 	k := 20
 	routes := make([][][2]int, len(drones))
 	for i, drone := range drones {
@@ -3,15 +3,43 @@ package main
 import (
 	"./pipeline"
 
+	"encoding/json"
+	"fmt"
 	"os"
+	"time"
 )
 
 func main() {
-	if len(os.Args) >= 2 {
+	ops, err := pipeline.GetPipeline()
+	if err != nil {
+		panic(err)
+	}
+	if len(os.Args) >= 2 && (os.Args[1] == "--explain" || os.Args[1] == "--explain-json") {
+		// show what the next run would do, without executing anything
+		plan, err := ops.Explain()
+		if err != nil {
+			panic(err)
+		}
+		if os.Args[1] == "--explain" {
+			fmt.Println(plan)
+		} else {
+			bytes, err := json.MarshalIndent(plan, "", "\t")
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println(string(bytes))
+		}
+	} else if len(os.Args) >= 2 && os.Args[1] == "--daemon" {
+		// keep the pipeline in memory and process frames as they arrive
+		pipeline.NewStream(ops).Run(5*time.Second)
+	} else if len(os.Args) >= 2 {
 		name := os.Args[1]
-		ops := pipeline.GetPipeline()
-		ops[name].Execute()
-	} else {
-		pipeline.RunPipeline()
+		if err := ops[name].Execute(); err != nil {
+			panic(err)
+		}
+	} else if err := ops.RunAll(); err != nil {
+		// other operators have finished, so only the failed ones need to rerun
+		fmt.Println(err)
+		os.Exit(1)
 	}
 }
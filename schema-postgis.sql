@@ -0,0 +1,148 @@
+-- Schema for PostgreSQL with PostGIS, see PostGISDriver.
+-- Detection polygons and frame bounds are geometry in orthoimage pixels. The
+-- Go code reads and writes them as text "x1,y1 x2,y2 ..." like in schema.sql,
+-- converted with skyquery_polygon_text and skyquery_polygon (see
+-- PostgresDialect). Polygons with fewer than three points are stored as NULL.
+-- Frame polygons of detections stay text, like in schema.sql.
+CREATE EXTENSION IF NOT EXISTS postgis;
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+CREATE FUNCTION skyquery_polygon(s TEXT) RETURNS geometry AS $$
+	SELECT CASE
+		WHEN COALESCE(array_length(string_to_array(s, ' '), 1), 0) < 3 THEN NULL
+		ELSE ST_GeomFromText(
+			'POLYGON((' || replace(replace(replace(s, ' ', ';'), ',', ' '), ';', ', ') ||
+			', ' || replace(split_part(s, ' ', 1), ',', ' ') || '))'
+		)
+	END
+$$ LANGUAGE SQL IMMUTABLE;
+
+CREATE FUNCTION skyquery_polygon_text(g geometry) RETURNS TEXT AS $$
+	SELECT string_agg(ST_X(p.geom) || ',' || ST_Y(p.geom), ' ' ORDER BY p.path[1])
+	FROM ST_DumpPoints(ST_ExteriorRing(g)) AS p
+	-- skip the point that closes the ring
+	WHERE p.path[1] < ST_NPoints(ST_ExteriorRing(g))
+$$ LANGUAGE SQL IMMUTABLE;
+
+CREATE TABLE videos (
+	id SERIAL PRIMARY KEY,
+	filename VARCHAR(255) NOT NULL,
+	processed SMALLINT NOT NULL DEFAULT 0,
+	start_location VARCHAR(2048) NOT NULL,
+	start_time TIMESTAMP NOT NULL,
+	preprocessed SMALLINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE video_frames (
+	id SERIAL PRIMARY KEY,
+	video_id INT DEFAULT NULL,
+	idx INT NOT NULL,
+	time TIMESTAMP NOT NULL,
+	homography VARCHAR(2048) DEFAULT NULL,
+	bounds geometry(Polygon) DEFAULT NULL,
+	enabled SMALLINT DEFAULT 1
+);
+CREATE INDEX video_frames_video_id ON video_frames (video_id);
+CREATE INDEX video_frames_enabled_time ON video_frames (time) WHERE enabled = 1;
+CREATE INDEX video_frames_time_bounds ON video_frames USING GIST (time, bounds);
+
+CREATE TABLE dataframes (
+	name VARCHAR(16) NOT NULL PRIMARY KEY,
+	parents VARCHAR(255) NOT NULL,
+	op_type VARCHAR(16) NOT NULL,
+	operands VARCHAR(2048) NOT NULL,
+	seq INT NOT NULL DEFAULT 0,
+	rerun_time TIMESTAMP NOT NULL DEFAULT '1971-01-01 00:00:00',
+	last_duration DOUBLE PRECISION DEFAULT NULL
+);
+
+CREATE TABLE detections (
+	id SERIAL PRIMARY KEY,
+	dataframe VARCHAR(16) NOT NULL,
+	time TIMESTAMP NOT NULL,
+	frame_polygon TEXT NOT NULL,
+	polygon geometry(Polygon) DEFAULT NULL,
+	frame_id INT NOT NULL,
+	class VARCHAR(64) NOT NULL DEFAULT '',
+	confidence DOUBLE PRECISION NOT NULL DEFAULT 1
+);
+CREATE INDEX detections_frame_id ON detections (frame_id);
+CREATE INDEX detections_dataframe_time ON detections (dataframe, time);
+CREATE INDEX detections_dataframe_time_polygon ON detections USING GIST (dataframe, time, polygon);
+
+CREATE TABLE sequences (
+	id SERIAL PRIMARY KEY,
+	dataframe VARCHAR(16) NOT NULL,
+	time TIMESTAMP NOT NULL,
+	terminated_at TIMESTAMP NULL DEFAULT NULL
+);
+CREATE INDEX sequences_dataframe_time ON sequences (dataframe, time);
+CREATE INDEX sequences_dataframe_terminated_at ON sequences (dataframe, terminated_at);
+
+CREATE TABLE sequence_metadata (
+	id SERIAL PRIMARY KEY,
+	sequence_id INT NOT NULL,
+	time TIMESTAMP NOT NULL,
+	metadata VARCHAR(2048) NOT NULL DEFAULT ''
+);
+CREATE INDEX sequence_metadata_sequence_id ON sequence_metadata (sequence_id);
+
+CREATE TABLE sequence_members (
+	id SERIAL PRIMARY KEY,
+	sequence_id INT NOT NULL,
+	detection_id INT NOT NULL,
+	time TIMESTAMP NOT NULL
+);
+CREATE INDEX sequence_members_sequence_id ON sequence_members (sequence_id, time);
+CREATE INDEX sequence_members_detection_id ON sequence_members (detection_id);
+
+CREATE TABLE matrix_data (
+	id SERIAL PRIMARY KEY,
+	dataframe VARCHAR(16) NOT NULL,
+	time TIMESTAMP NOT NULL,
+	i INT NOT NULL,
+	j INT NOT NULL,
+	val INT NOT NULL,
+	metadata VARCHAR(2048) NOT NULL DEFAULT ''
+);
+CREATE INDEX matrix_data_dataframe ON matrix_data (dataframe, time);
+CREATE INDEX matrix_data_cell ON matrix_data (i, j);
+
+CREATE TABLE operator_metrics (
+	id SERIAL PRIMARY KEY,
+	dataframe VARCHAR(16) NOT NULL,
+	started_at TIMESTAMP NOT NULL,
+	wall_time DOUBLE PRECISION NOT NULL,
+	init_time DOUBLE PRECISION NOT NULL,
+	frames INT NOT NULL,
+	parent_rows INT NOT NULL,
+	rows_emitted INT NOT NULL,
+	rows_deleted INT NOT NULL
+);
+CREATE INDEX operator_metrics_dataframe ON operator_metrics (dataframe);
+
+CREATE TABLE operator_checkpoints (
+	id SERIAL PRIMARY KEY,
+	dataframe VARCHAR(16) NOT NULL,
+	time TIMESTAMP NOT NULL,
+	state TEXT NOT NULL
+);
+CREATE INDEX operator_checkpoints_dataframe_time ON operator_checkpoints (dataframe, time);
+
+CREATE TABLE pending_routes (
+	id SERIAL PRIMARY KEY,
+	drone_id INT NOT NULL,
+	i INT NOT NULL,
+	j INT NOT NULL
+);
+
+CREATE TABLE georefs (
+	name VARCHAR(64) NOT NULL PRIMARY KEY,
+	crs VARCHAR(32) NOT NULL,
+	transform VARCHAR(256) NOT NULL
+);
+
+CREATE TABLE areas (
+	name VARCHAR(64) NOT NULL PRIMARY KEY,
+	gsd DOUBLE PRECISION NOT NULL
+);
@@ -0,0 +1,117 @@
+CREATE TABLE videos (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	filename VARCHAR(255) NOT NULL,
+	processed TINYINT(1) NOT NULL DEFAULT 0,
+	start_location VARCHAR(2048) NOT NULL,
+	start_time TIMESTAMP NOT NULL,
+	preprocessed TINYINT(1) NOT NULL DEFAULT 0
+);
+
+CREATE TABLE video_frames (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	video_id INT DEFAULT NULL,
+	idx INT NOT NULL,
+	time TIMESTAMP NOT NULL,
+	homography VARCHAR(2048) DEFAULT NULL,
+	bounds VARCHAR(2048) DEFAULT NULL,
+	enabled TINYINT(1) DEFAULT 1
+);
+CREATE INDEX video_frames_video_id ON video_frames (video_id);
+
+CREATE TABLE dataframes (
+	name VARCHAR(16) NOT NULL PRIMARY KEY,
+	parents VARCHAR(255) NOT NULL,
+	op_type VARCHAR(16) NOT NULL,
+	operands VARCHAR(2048) NOT NULL,
+	seq INT NOT NULL DEFAULT 0,
+	rerun_time TIMESTAMP NOT NULL DEFAULT '1971-01-01 00:00:00',
+	last_duration DOUBLE DEFAULT NULL
+);
+
+CREATE TABLE detections (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	dataframe VARCHAR(16) NOT NULL,
+	time TIMESTAMP NOT NULL,
+	frame_polygon VARCHAR(2048) NOT NULL,
+	polygon VARCHAR(2048) DEFAULT NULL,
+	frame_id INT NOT NULL,
+	class VARCHAR(64) NOT NULL DEFAULT '',
+	confidence DOUBLE NOT NULL DEFAULT 1
+);
+CREATE INDEX detections_frame_id ON detections (frame_id);
+CREATE INDEX detections_dataframe ON detections (dataframe);
+
+CREATE TABLE sequences (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	dataframe VARCHAR(16) NOT NULL,
+	time TIMESTAMP NOT NULL,
+	terminated_at TIMESTAMP NULL DEFAULT NULL
+);
+CREATE INDEX sequences_dataframe ON sequences (dataframe);
+
+CREATE TABLE sequence_metadata (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	sequence_id INT NOT NULL,
+	time TIMESTAMP NOT NULL,
+	metadata VARCHAR(2048) NOT NULL DEFAULT ''
+);
+CREATE INDEX sequence_metadata_sequence_id ON sequence_metadata (sequence_id);
+
+CREATE TABLE sequence_members (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	sequence_id INT NOT NULL,
+	detection_id INT NOT NULL,
+	time TIMESTAMP NOT NULL
+);
+CREATE INDEX sequence_members_sequence_id ON sequence_members (sequence_id);
+
+CREATE TABLE matrix_data (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	dataframe VARCHAR(16) NOT NULL,
+	time TIMESTAMP NOT NULL,
+	i INT NOT NULL,
+	j INT NOT NULL,
+	val INT NOT NULL,
+	metadata VARCHAR(2048) NOT NULL DEFAULT ''
+);
+CREATE INDEX matrix_data_dataframe ON matrix_data (dataframe);
+CREATE INDEX matrix_data_cell ON matrix_data (i, j);
+
+CREATE TABLE operator_metrics (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	dataframe VARCHAR(16) NOT NULL,
+	started_at TIMESTAMP NOT NULL,
+	wall_time DOUBLE NOT NULL,
+	init_time DOUBLE NOT NULL,
+	frames INT NOT NULL,
+	parent_rows INT NOT NULL,
+	rows_emitted INT NOT NULL,
+	rows_deleted INT NOT NULL
+);
+CREATE INDEX operator_metrics_dataframe ON operator_metrics (dataframe);
+
+CREATE TABLE operator_checkpoints (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	dataframe VARCHAR(16) NOT NULL,
+	time TIMESTAMP NOT NULL,
+	state TEXT NOT NULL
+);
+CREATE INDEX operator_checkpoints_dataframe_time ON operator_checkpoints (dataframe, time);
+
+CREATE TABLE pending_routes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	drone_id INT NOT NULL,
+	i INT NOT NULL,
+	j INT NOT NULL
+);
+
+CREATE TABLE georefs (
+	name VARCHAR(64) NOT NULL PRIMARY KEY,
+	crs VARCHAR(32) NOT NULL,
+	transform VARCHAR(256) NOT NULL
+);
+
+CREATE TABLE areas (
+	name VARCHAR(64) NOT NULL PRIMARY KEY,
+	gsd DOUBLE NOT NULL
+);
@@ -24,7 +24,8 @@ CREATE TABLE dataframes (
 	op_type VARCHAR(16) NOT NULL,
 	operands VARCHAR(2048) NOT NULL,
 	seq INT NOT NULL DEFAULT 0,
-	rerun_time TIMESTAMP NOT NULL DEFAULT '1971-01-01 00:00:00'
+	rerun_time TIMESTAMP NOT NULL DEFAULT '1971-01-01 00:00:00',
+	last_duration DOUBLE DEFAULT NULL
 );
 
 CREATE TABLE detections (
@@ -33,7 +34,9 @@ CREATE TABLE detections (
 	time TIMESTAMP NOT NULL,
 	frame_polygon VARCHAR(2048) NOT NULL,
 	polygon VARCHAR(2048) DEFAULT NULL,
-	frame_id INT NOT NULL
+	frame_id INT NOT NULL,
+	class VARCHAR(64) NOT NULL DEFAULT '',
+	confidence DOUBLE NOT NULL DEFAULT 1
 );
 CREATE INDEX frame_id ON detections (frame_id);
 CREATE INDEX dataframe ON detections (dataframe);
@@ -74,9 +77,41 @@ CREATE TABLE matrix_data (
 CREATE INDEX dataframe ON matrix_data (dataframe);
 CREATE INDEX cell ON matrix_data (i, j);
 
+CREATE TABLE operator_metrics (
+	id INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+	dataframe VARCHAR(16) NOT NULL,
+	started_at TIMESTAMP NOT NULL,
+	wall_time DOUBLE NOT NULL,
+	init_time DOUBLE NOT NULL,
+	frames INT NOT NULL,
+	parent_rows INT NOT NULL,
+	rows_emitted INT NOT NULL,
+	rows_deleted INT NOT NULL
+);
+CREATE INDEX dataframe ON operator_metrics (dataframe);
+
+CREATE TABLE operator_checkpoints (
+	id INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+	dataframe VARCHAR(16) NOT NULL,
+	time TIMESTAMP NOT NULL,
+	state MEDIUMTEXT NOT NULL
+);
+CREATE INDEX dataframe_time ON operator_checkpoints (dataframe, time);
+
 CREATE TABLE pending_routes (
 	id INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
 	drone_id INT NOT NULL,
 	i INT NOT NULL,
 	j INT NOT NULL
 );
+
+CREATE TABLE georefs (
+	name VARCHAR(64) NOT NULL PRIMARY KEY,
+	crs VARCHAR(32) NOT NULL,
+	transform VARCHAR(256) NOT NULL
+);
+
+CREATE TABLE areas (
+	name VARCHAR(64) NOT NULL PRIMARY KEY,
+	gsd DOUBLE NOT NULL
+);
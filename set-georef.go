@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/mitroadmaps/gomapinfer/common"
+	"./pipeline"
+
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+func main() {
+	if len(os.Args) == 2 {
+		georef, err := pipeline.GetGeoref(os.Args[1])
+		if err != nil {
+			panic(err)
+		} else if georef == nil {
+			fmt.Printf("no georef %s\n", os.Args[1])
+			os.Exit(1)
+		}
+		fmt.Println(georef)
+		lonLat := georef.ToLonLat(common.Point{0, 0})
+		fmt.Printf("top-left pixel at lon %v lat %v, %v meters per pixel\n", lonLat.X, lonLat.Y, georef.MetersPerPixel())
+		return
+	}
+	if len(os.Args) != 4 {
+		fmt.Fprintf(os.Stderr, "usage: %s NAME [CRS GEOTRANSFORM|WORLDFILE]\n", os.Args[0])
+		os.Exit(2)
+	}
+	name, crs, transform := os.Args[1], os.Args[2], os.Args[3]
+	var georef *pipeline.Georef
+	var err error
+	if bytes, rerr := ioutil.ReadFile(transform); rerr == nil {
+		georef, err = pipeline.ParseWorldFile(string(bytes), crs)
+	} else {
+		georef, err = pipeline.ParseGeoTransform(transform, crs)
+	}
+	if err != nil {
+		panic(err)
+	}
+	if err := pipeline.SetGeoref(name, georef); err != nil {
+		panic(err)
+	}
+	fmt.Println(georef)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"./pipeline"
+
+	"fmt"
+	"os"
+	"strconv"
+)
+
+func main() {
+	if len(os.Args) == 2 {
+		gsd, err := pipeline.LoadGSD(os.Args[1])
+		if err != nil {
+			panic(err)
+		} else if gsd == 0 {
+			fmt.Printf("no GSD or georef for %s\n", os.Args[1])
+			os.Exit(1)
+		}
+		fmt.Printf("%v meters per pixel\n", gsd)
+		return
+	}
+	if len(os.Args) != 3 {
+		fmt.Fprintf(os.Stderr, "usage: %s NAME [METERS_PER_PIXEL]\n", os.Args[0])
+		os.Exit(2)
+	}
+	gsd, err := strconv.ParseFloat(os.Args[2], 64)
+	if err != nil {
+		panic(err)
+	} else if gsd <= 0 {
+		fmt.Fprintf(os.Stderr, "GSD must be positive\n")
+		os.Exit(2)
+	}
+	if err := pipeline.SetGSD(os.Args[1], gsd); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"./pipeline"
+
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Print where the pipeline spends its time: total wall time by operator type
+// over the last HOURS hours (24 by default), and the most recent execution of
+// each dataframe. Pass a dataframe name instead to list its recent executions.
+func main() {
+	ops, err := pipeline.GetPipeline()
+	if err != nil {
+		panic(err)
+	}
+	if len(os.Args) >= 2 && ops[os.Args[1]] != nil {
+		metrics, err := pipeline.GetMetrics(os.Args[1], 20)
+		if err != nil {
+			panic(err)
+		}
+		for _, m := range metrics {
+			fmt.Printf("%s  %v\n", m.StartedAt.Format("2006-01-02 15:04:05"), m)
+		}
+		return
+	}
+
+	hours := 24
+	if len(os.Args) >= 2 {
+		hours, err = strconv.Atoi(os.Args[1])
+		if err != nil {
+			fmt.Println("usage: go run show-metrics.go [HOURS|DATAFRAME]")
+			os.Exit(1)
+		}
+	}
+	types, err := ops.GetTypeMetrics(time.Now().Add(-time.Duration(hours)*time.Hour))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("by operator type, last %d hours:\n", hours)
+	for _, t := range types {
+		fmt.Printf("  %-16s %v in %d executions, %d frames\n", t.Type, t.WallTime, t.Executions, t.Frames)
+	}
+
+	latest, err := pipeline.GetLatestMetrics()
+	if err != nil {
+		panic(err)
+	}
+	var names []string
+	for name := range latest {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Println("last execution of each dataframe:")
+	for _, name := range names {
+		fmt.Printf("  %v\n", latest[name])
+	}
+}
@@ -17,6 +17,8 @@ import (
 var RecordInterval time.Duration = 15*time.Minute
 
 type Predictor struct {
+	ctx *pipeline.Context
+	// Observations are read from the context's InMemoryDriver directly.
 	driver *pipeline.InMemoryDriver
 	dataframe string
 
@@ -32,9 +34,10 @@ type  PrevSample struct {
 	val int
 }
 
-func NewPredictor(driver *pipeline.InMemoryDriver, dataframe string) *Predictor {
+func NewPredictor(ctx *pipeline.Context, dataframe string) *Predictor {
 	return &Predictor{
-		driver: driver,
+		ctx: ctx,
+		driver: ctx.Driver.(*pipeline.InMemoryDriver),
 		dataframe: dataframe,
 		cyclicSamples: make(map[[2]int]map[int][]int),
 		prevSamples: make(map[[2]int]*PrevSample),
@@ -103,14 +106,17 @@ func (p *Predictor) Predict() {
 
 	// create mds with predictions for cells that weren't visited on this interval
 	// also increment error rate of all cells
-	matrix := pipeline.LoadMatrix(p.dataframe)
+	matrix, err := p.ctx.LoadMatrix(p.dataframe)
+	if err != nil {
+		panic(err)
+	}
 	for cell, _ := range matrix {
 		// error rate
 		stddev := getStddev(p.cyclicSamples[cell][cycle], p.max, 0)
 		if len(p.cyclicSamples[cell][cycle]) < 3 {
 			stddev += 5
 		}
-		pipeline.AddMatrixData("error_rate", cell[0], cell[1], int(stddev*100), "", p.lastSeenObsTime)
+		p.ctx.AddMatrixData("error_rate", cell[0], cell[1], int(stddev*100), "", p.lastSeenObsTime)
 
 		// predictions
 		if curCells[cell] {
@@ -130,14 +136,14 @@ func (p *Predictor) Predict() {
 		if val < 0 {
 			val = 0
 		}
-		pipeline.AddMatrixData("sd_counts", cell[0], cell[1], val, "", p.lastSeenObsTime)
+		p.ctx.AddMatrixData("sd_counts", cell[0], cell[1], val, "", p.lastSeenObsTime)
 	}
 
 	p.interval++
 }
 
 func main() {
-	/*cellRect := pipeline.GetCellRect([2]int{-7, -13}, simulator.GridSize)
+	/*cellRect := pipeline.GetCellRect([2]int{-7, -13}, simulator.Grid)
 	framePoly := common.Polygon{
 		common.Point{-1817.6, -3353.6},
 		common.Point{-1817.6, -3046.4},
@@ -153,7 +159,8 @@ func main() {
 	dbname := os.Args[2]
 	pipeline.SetDBName(dbname)
 	db := pipeline.NewDatabase()
-	driver := pipeline.GetDriver().(*pipeline.InMemoryDriver)
+	driver := pipeline.NewInMemoryDriver()
+	ctx := pipeline.NewContext(db, driver)
 
 	//db.Exec("DELETE FROM matrix_data")
 	//db.Exec("DELETE FROM video_frames")
@@ -164,7 +171,7 @@ func main() {
 		common.Point{-6000, -12500},
 		common.Point{6000, -500},
 	}
-	sd := simulator.LoadSanDiego(start, end, rect)
+	sd := simulator.LoadSanDiego(simulator.SDGeoref(), start, end, rect)
 	fmt.Printf("bounds: %v\n", sd.Bounds())
 
 	maxes := sd.GetMaxes()
@@ -176,24 +183,26 @@ func main() {
 	}
 	fmt.Println(len(uniques))
 	return*/
-	minCell := pipeline.ToCell(rect.Min, simulator.GridSize)
-	maxCell := pipeline.ToCell(rect.Max, simulator.GridSize)
+	minCell := pipeline.ToCell(rect.Min, simulator.Grid)
+	maxCell := pipeline.ToCell(rect.Max, simulator.Grid)
 	var cells [][2]int
 	for x := minCell[0]; x <= maxCell[0]; x++ {
 		for y := minCell[1]; y <= maxCell[1]; y++ {
 			cell := [2]int{x, y}
 			cells = append(cells, cell)
-			pipeline.AddMatrixData("error", x, y, 999999, "", start.Add(-time.Hour))
-			pipeline.AddMatrixData("maxes", x, y, maxes[cell], "", start.Add(-time.Hour))
+			ctx.AddMatrixData("error", x, y, 999999, "", start.Add(-time.Hour))
+			ctx.AddMatrixData("maxes", x, y, maxes[cell], "", start.Add(-time.Hour))
 		}
 	}
 
-	base := pipeline.ToCell(rect.Center(), simulator.GridSize)
+	base := pipeline.ToCell(rect.Center(), simulator.Grid)
 	router := router.Router{
+		Context: ctx,
 		Dataframe: "error",
 		Base: base,
 	}
 	s := &simulator.Simulation{
+		Context: ctx,
 		DataSources: map[string]simulator.DataSource{
 			"sd_counts": sd.GetCount,
 			"sd_new": sd.GetNew,
@@ -205,7 +214,7 @@ func main() {
 	for i := 0; i < 4; i++ {
 		s.AddDrone()
 	}
-	predictor := NewPredictor(driver, "sd_counts")
+	predictor := NewPredictor(ctx, "sd_counts")
 	for s.Time.Before(end) {
 		//db.Exec("DELETE FROM matrix_data WHERE dataframe != 'sd_counts' AND time < ? AND val != '999999'", s.Time.Add(-2*time.Minute))
 		// delete old matrix data
@@ -222,11 +231,15 @@ func main() {
 			}
 		}
 
-		db.Exec("UPDATE dataframes SET rerun_time = ?", s.Time)
+		if _, err := db.Exec("UPDATE dataframes SET rerun_time = ?", s.Time); err != nil {
+			panic(err)
+		}
 		fmt.Println(s.Time)
 		s.Run(int(15*time.Minute/simulator.TimeStep))
 		predictor.Predict()
-		pipeline.RunPipeline()
+		if err := ctx.RunPipeline(); err != nil {
+			panic(err)
+		}
 	}
 	fmt.Printf("%v\n", s.Drones[0].Route)
 
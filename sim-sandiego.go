@@ -18,8 +18,20 @@ import (
 
 var RecordInterval time.Duration = 15*time.Minute
 
+// Simulated time between snapshots of the in-memory dataframes.
+var SnapshotInterval time.Duration = 24*time.Hour
+
+// State of the simulation that is saved next to each snapshot, since the
+// snapshot only holds the dataframes.
+type SnapshotState struct {
+	Time time.Time
+	Drones []*simulator.Drone
+	// Transactions already counted by sd_new.
+	Seen map[string]bool
+}
+
 func main() {
-	/*cellRect := pipeline.GetCellRect([2]int{-7, -13}, simulator.GridSize)
+	/*cellRect := pipeline.GetCellRect([2]int{-7, -13}, simulator.Grid)
 	framePoly := common.Polygon{
 		common.Point{-1817.6, -3353.6},
 		common.Point{-1817.6, -3046.4},
@@ -31,12 +43,34 @@ func main() {
 	}
 	return*/
 
+	// usage: sim-sandiego PREFIX DBNAME [resume]
+	// With resume, continue from PREFIX_snapshot.json.gz instead of starting over.
 	prefix := os.Args[1]
 	dbname := os.Args[2]
+	resume := len(os.Args) > 3 && os.Args[3] == "resume"
 	pipeline.Quiet = true
 	pipeline.SetDBName(dbname)
 	db := pipeline.NewDatabase()
-	driver := pipeline.GetDriver().(*pipeline.InMemoryDriver)
+	snapshotName := prefix + "_snapshot.json.gz"
+	stateName := prefix + "_snapshot_state.json"
+	driver := pipeline.NewInMemoryDriver()
+	var state SnapshotState
+	if resume {
+		var err error
+		driver, err = pipeline.LoadInMemorySnapshot(snapshotName)
+		if err != nil {
+			panic(err)
+		}
+		bytes, err := ioutil.ReadFile(stateName)
+		if err != nil {
+			panic(err)
+		}
+		if err := json.Unmarshal(bytes, &state); err != nil {
+			panic(err)
+		}
+		fmt.Printf("resuming from %v\n", state.Time)
+	}
+	ctx := pipeline.NewContext(db, driver)
 
 	//db.Exec("DELETE FROM matrix_data")
 	//db.Exec("DELETE FROM video_frames")
@@ -48,7 +82,10 @@ func main() {
 		common.Point{-6000, -12500},
 		common.Point{6000, -500},
 	}
-	sd := simulator.LoadSanDiego(start, end, rect)
+	sd := simulator.LoadSanDiego(simulator.SDGeoref(), start, end, rect)
+	if resume {
+		sd.Seen = state.Seen
+	}
 	fmt.Printf("bounds: %v\n", sd.Bounds())
 
 	maxes := sd.GetMaxes3()
@@ -60,24 +97,30 @@ func main() {
 	}
 	fmt.Println(len(uniques))
 	return*/
-	minCell := pipeline.ToCell(rect.Min, simulator.GridSize)
-	maxCell := pipeline.ToCell(rect.Max, simulator.GridSize)
+	minCell := pipeline.ToCell(rect.Min, simulator.Grid)
+	maxCell := pipeline.ToCell(rect.Max, simulator.Grid)
 	var cells [][2]int
 	for x := minCell[0]; x <= maxCell[0]; x++ {
 		for y := minCell[1]; y <= maxCell[1]; y++ {
 			cell := [2]int{x, y}
 			cells = append(cells, cell)
-			pipeline.AddMatrixData("error", x, y, 99999999999, "", start.Add(-time.Hour))
-			pipeline.AddMatrixData("maxes", x, y, maxes[cell], "", start.Add(-time.Hour))
+			if resume {
+				// already in the snapshot
+				continue
+			}
+			ctx.AddMatrixData("error", x, y, 99999999999, "", start.Add(-time.Hour))
+			ctx.AddMatrixData("maxes", x, y, maxes[cell], "", start.Add(-time.Hour))
 		}
 	}
 
-	base := pipeline.ToCell(rect.Center(), simulator.GridSize)
+	base := pipeline.ToCell(rect.Center(), simulator.Grid)
 	router := router.Router{
+		Context: ctx,
 		Dataframe: "error",
 		Base: base,
 	}
 	s := &simulator.Simulation{
+		Context: ctx,
 		DataSources: map[string]simulator.DataSource{
 			"sd_counts": sd.GetCount,
 			"sd_new": sd.GetNew,
@@ -86,10 +129,34 @@ func main() {
 		Router: router,
 		Base: base,
 	}
-	for i := 0; i < 1; i++ {
-		s.AddDrone()
+	predictor := simulator.NewPredictor2(ctx, "sd_counts", 96, maxes)
+	lastSnapshot := start
+	if resume {
+		s.Time = state.Time
+		s.Drones = state.Drones
+		predictor.Replay(start, state.Time, 15*time.Minute)
+		lastSnapshot = state.Time
+	} else {
+		for i := 0; i < 1; i++ {
+			s.AddDrone()
+		}
+	}
+	saveSnapshot := func() {
+		if err := driver.SaveSnapshot(snapshotName); err != nil {
+			panic(err)
+		}
+		bytes, err := json.Marshal(SnapshotState{
+			Time: s.Time,
+			Drones: s.Drones,
+			Seen: sd.Seen,
+		})
+		if err != nil {
+			panic(err)
+		}
+		if err := ioutil.WriteFile(stateName, bytes, 0644); err != nil {
+			panic(err)
+		}
 	}
-	predictor := simulator.NewPredictor2(driver, "sd_counts", 96, maxes)
 	for s.Time.Before(end) {
 		//db.Exec("DELETE FROM matrix_data WHERE dataframe != 'sd_counts' AND time < ? AND val != '99999999999'", s.Time.Add(-2*time.Minute))
 		// delete old matrix data
@@ -107,15 +174,17 @@ func main() {
 		}
 
 		preTime := s.Time
-		db.Exec("UPDATE dataframes SET rerun_time = ?", preTime)
+		if _, err := db.Exec("UPDATE dataframes SET rerun_time = ?", preTime); err != nil {
+			panic(err)
+		}
 		fmt.Println(preTime)
 		s.Run(int(15*time.Minute/simulator.TimeStep))
 		predictions := predictor.Predict()
 
 		if true { // direct case
 			for cell, prediction := range predictions {
-				pipeline.AddMatrixData("error", cell[0], cell[1], int(prediction.Stddev*100), "", preTime)
-				pipeline.AddMatrixData("predictions", cell[0], cell[1], int(prediction.Val), "", preTime)
+				ctx.AddMatrixData("error", cell[0], cell[1], int(prediction.Stddev*100), "", preTime)
+				ctx.AddMatrixData("predictions", cell[0], cell[1], int(prediction.Val), "", preTime)
 
 				/*var val int
 				if prediction.Val < 0.5 {
@@ -123,7 +192,7 @@ func main() {
 				} else {
 					val = 1
 				}
-				pipeline.AddMatrixData("predictions", cell[0], cell[1], val, "", preTime)*/
+				ctx.AddMatrixData("predictions", cell[0], cell[1], val, "", preTime)*/
 			}
 		} else { // thresholded case
 			for cell, prediction := range predictions {
@@ -146,14 +215,20 @@ func main() {
 					val = 0
 				}
 				//fmt.Printf("val=%v, stddev=%v, p=%v, out-stddev=%v, out-value=%v\n", prediction.Val, prediction.Stddev, pOpen, stddev, val)
-				pipeline.AddMatrixData("error", cell[0], cell[1], int(stddev*100), "", preTime)
-				//pipeline.AddMatrixData("error", cell[0], cell[1], int(prediction.Stddev*100), "", preTime)
-				pipeline.AddMatrixData("predictions", cell[0], cell[1], val, "", preTime)
+				ctx.AddMatrixData("error", cell[0], cell[1], int(stddev*100), "", preTime)
+				//ctx.AddMatrixData("error", cell[0], cell[1], int(prediction.Stddev*100), "", preTime)
+				ctx.AddMatrixData("predictions", cell[0], cell[1], val, "", preTime)
 			}
 		}
 
-		//pipeline.RunPipeline()
+		//ctx.RunPipeline()
+
+		if s.Time.Sub(lastSnapshot) >= SnapshotInterval {
+			saveSnapshot()
+			lastSnapshot = s.Time
+		}
 	}
+	saveSnapshot()
 	fmt.Printf("%v\n", s.Drones[0].Route)
 
 	saveMap := func(fname string, m map[string]map[int]int) {
@@ -225,11 +300,4 @@ func main() {
 	//actualOpen := countsToOpen(actualCounts)
 	saveMap(prefix + "_open.json", predOpen)
 	//saveMap("gt_open_8week50.json", actualOpen)
-
-	/*dbDriver := pipeline.NewDatabaseDriver(db)
-	for _, md := range driver.DFs["sd_counts"].MatrixData {
-		var mdCopy pipeline.MatrixData
-		mdCopy = *md
-		dbDriver.AddMatrixData("sd_counts", &mdCopy)
-	}*/
 }
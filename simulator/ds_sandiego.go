@@ -2,7 +2,6 @@ package simulator
 
 import (
 	"github.com/mitroadmaps/gomapinfer/common"
-	"github.com/mitroadmaps/gomapinfer/googlemaps"
 	"../pipeline"
 
 	"bufio"
@@ -16,9 +15,19 @@ import (
 	"time"
 )
 
+// Longitude and latitude of pixel (0, 0) in SDGeoref.
 var SDOrigin = common.Point{-117.15, 32.7}
 var SDTimeGridSize time.Duration = time.Hour
 
+// Returns the georef of the San Diego simulations, in UTM zone 11N with 0.5m
+// pixels from SDOrigin, which is about the scale of zoom 18 web mercator tiles.
+func SDGeoref() *pipeline.Georef {
+	georef, err := pipeline.NewUTMGeoref(SDOrigin, 0.5)
+	if err != nil {
+		panic(err)
+	}
+	return georef
+}
 type SDTransaction struct {
 	TransactionID string
 	Start time.Time
@@ -38,7 +47,8 @@ type SanDiego struct {
 	Seen map[string]bool
 }
 
-func getSDMeterLocations() map[string]common.Point {
+// Returns the pixel of each parking meter pole under the georef.
+func getSDMeterLocations(georef *pipeline.Georef) map[string]common.Point {
 	bytes, err := ioutil.ReadFile("/data/discover-datasets/2019mar22-sandiego/meters.csv")
 	if err != nil {
 		panic(err)
@@ -61,16 +71,18 @@ func getSDMeterLocations() map[string]common.Point {
 			fmt.Printf("skip meter %v at %v\n", pole, p)
 			continue
 		}
-		meters[pole] = googlemaps.LonLatToPixel(p, SDOrigin, 18)
+		meters[pole] = georef.FromLonLat(p)
 	}
 	return meters
 }
 
-func LoadSanDiego(start time.Time, end time.Time, rect common.Rectangle) SanDiego {
+// Load transactions between start and end at meters in rect, which is in
+// pixels of the georef, e.g. SDGeoref().
+func LoadSanDiego(georef *pipeline.Georef, start time.Time, end time.Time, rect common.Rectangle) SanDiego {
 	fmt.Printf("reading san-diego transactions from %v to %v, in %v\n", start, end, rect)
 	var transactions []*SDTransaction
 	lastByPole := make(map[string]*SDTransaction)
-	meters := getSDMeterLocations()
+	meters := getSDMeterLocations(georef)
 	for pole, location := range meters {
 		if !rect.Contains(location) {
 			delete(meters, pole)
@@ -134,7 +146,7 @@ func LoadSanDiego(start time.Time, end time.Time, rect common.Rectangle) SanDieg
 
 	grid := make(map[[3]int][]*SDTransaction)
 	for _, transaction := range transactions {
-		spaceCell := pipeline.ToCell(transaction.Point, GridSize)
+		spaceCell := pipeline.ToCell(transaction.Point, Grid)
 		startTimeCell := int(transaction.Start.Unix() / int64(SDTimeGridSize/time.Second))
 		endTimeCell := int(transaction.End.Unix() / int64(SDTimeGridSize/time.Second))
 		for timeCell := startTimeCell; timeCell <= endTimeCell; timeCell++ {
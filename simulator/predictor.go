@@ -10,6 +10,8 @@ import (
 )
 
 type Predictor struct {
+	ctx *pipeline.Context
+	// Observations are read from the context's InMemoryDriver directly.
 	driver *pipeline.InMemoryDriver
 	dataframe string
 
@@ -36,9 +38,10 @@ type Prediction struct {
 	Stddev float64
 }
 
-func NewPredictor(driver *pipeline.InMemoryDriver, dataframe string, period int, maxes map[[2]int]int) *Predictor {
+func NewPredictor(ctx *pipeline.Context, dataframe string, period int, maxes map[[2]int]int) *Predictor {
 	return &Predictor{
-		driver: driver,
+		ctx: ctx,
+		driver: ctx.Driver.(*pipeline.InMemoryDriver),
 		dataframe: dataframe,
 		period: period,
 		cyclicSamples: make(map[[2]int]map[int][]float64),
@@ -114,7 +117,10 @@ func getStddev(a []float64, max int, extras int) float64 {
 	p.lastSeenObsTime = latestTime
 
 	// increment standard deviations
-	matrix := pipeline.LoadMatrix(p.dataframe)
+	matrix, err := p.ctx.LoadMatrix(p.dataframe)
+	if err != nil {
+		panic(err)
+	}
 	for cell, _ := range matrix {
 		stddev := getStddev(p.cyclicSamples[cell][cycle], p.max, 0)
 		if len(p.cyclicSamples[cell][cycle]) < 3 {
@@ -223,7 +229,10 @@ func (p *Predictor) Predict() map[[2]int]Prediction {
 	p.lastSeenObsTime = latestTime
 
 	// increment standard deviations
-	matrix := pipeline.LoadMatrix(p.dataframe)
+	matrix, err := p.ctx.LoadMatrix(p.dataframe)
+	if err != nil {
+		panic(err)
+	}
 	for cell, _ := range matrix {
 		if p.maxes[cell] == 0 {
 			p.stddevs[cell] = 0
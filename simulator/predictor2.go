@@ -10,6 +10,8 @@ import (
 )
 
 type Predictor2 struct {
+	ctx *pipeline.Context
+	// Observations are read from the context's InMemoryDriver directly.
 	driver *pipeline.InMemoryDriver
 	dataframe string
 
@@ -27,11 +29,15 @@ type Predictor2 struct {
 	prevSamples map[[2]int][]PrevSample
 	stddevs map[[2]int]float64
 	maxes map[[2]int]int
+
+	// If set, Predict only processes observations before this time, see Replay.
+	until time.Time
 }
 
-func NewPredictor2(driver *pipeline.InMemoryDriver, dataframe string, period int, maxes map[[2]int]int) *Predictor2 {
+func NewPredictor2(ctx *pipeline.Context, dataframe string, period int, maxes map[[2]int]int) *Predictor2 {
 	return &Predictor2{
-		driver: driver,
+		ctx: ctx,
+		driver: ctx.Driver.(*pipeline.InMemoryDriver),
 		dataframe: dataframe,
 		period: period,
 		cyclicSamples: make(map[[2]int]map[[2]int][]float64),
@@ -41,6 +47,17 @@ func NewPredictor2(driver *pipeline.InMemoryDriver, dataframe string, period int
 	}
 }
 
+// Rebuild the samples from the observations already in the dataframe, as if
+// Predict was called after every interval from start until end. This is used
+// when resuming a simulation from a snapshot of its driver.
+func (p *Predictor2) Replay(start time.Time, end time.Time, interval time.Duration) {
+	for t := start.Add(interval); !t.After(end); t = t.Add(interval) {
+		p.until = t
+		p.Predict()
+	}
+	p.until = time.Time{}
+}
+
 // new predictor that models the rates rather than the values
 func (p *Predictor2) Predict() map[[2]int]Prediction {
 	cycle := p.interval % p.period
@@ -67,6 +84,9 @@ func (p *Predictor2) Predict() map[[2]int]Prediction {
 		if !md.Time.After(p.lastSeenObsTime) {
 			continue
 		}
+		if !p.until.IsZero() && !md.Time.Before(p.until) {
+			continue
+		}
 		if md.Time.After(latestTime) {
 			latestTime = md.Time
 		}
@@ -128,7 +148,16 @@ func (p *Predictor2) Predict() map[[2]int]Prediction {
 	p.lastSeenObsTime = latestTime
 
 	// update standard deviations
-	matrix := pipeline.LoadMatrix(p.dataframe)
+	var matrix map[[2]int]*pipeline.MatrixData
+	var err error
+	if p.until.IsZero() {
+		matrix, err = p.ctx.LoadMatrix(p.dataframe)
+	} else {
+		matrix, err = p.driver.LoadMatrixBefore(p.dataframe, p.until)
+	}
+	if err != nil {
+		panic(err)
+	}
 	for cell, _ := range matrix {
 		if p.maxes[cell] == 0 {
 			p.stddevs[cell] = 0
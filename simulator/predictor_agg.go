@@ -9,6 +9,8 @@ import (
 )
 
 type Predictor3 struct {
+	ctx *pipeline.Context
+	// Observations are read from the context's InMemoryDriver directly.
 	driver *pipeline.InMemoryDriver
 	dataframe string
 
@@ -27,9 +29,10 @@ type Predictor3 struct {
 	stddevs map[[2]int]float64
 }
 
-func NewPredictor3(driver *pipeline.InMemoryDriver, dataframe string, period int) *Predictor3 {
+func NewPredictor3(ctx *pipeline.Context, dataframe string, period int) *Predictor3 {
 	return &Predictor3{
-		driver: driver,
+		ctx: ctx,
+		driver: ctx.Driver.(*pipeline.InMemoryDriver),
 		dataframe: dataframe,
 		period: period,
 		cyclicSamples: make(map[[2]int]map[[2]int][]float64),
@@ -112,7 +115,10 @@ func (p *Predictor3) Predict() map[[2]int]Prediction {
 	p.lastSeenObsTime = latestTime
 
 	// update standard deviations
-	matrix := pipeline.LoadMatrix(p.dataframe)
+	matrix, err := p.ctx.LoadMatrix(p.dataframe)
+	if err != nil {
+		panic(err)
+	}
 	badCells := make(map[[2]int]bool)
 	for cell, _ := range matrix {
 		prevSample := p.prevSamples[cell][len(p.prevSamples[cell]) - 1]
@@ -13,6 +13,8 @@ import (
 )
 
 type Predictor4 struct {
+	ctx *pipeline.Context
+	// Observations are read from the context's InMemoryDriver directly.
 	driver *pipeline.InMemoryDriver
 	dataframe string
 
@@ -30,9 +32,10 @@ type Predictor4 struct {
 	stddevs map[[2]int]float64
 }
 
-func NewPredictor4(driver *pipeline.InMemoryDriver, dataframe string, period int) *Predictor4 {
+func NewPredictor4(ctx *pipeline.Context, dataframe string, period int) *Predictor4 {
 	return &Predictor4{
-		driver: driver,
+		ctx: ctx,
+		driver: ctx.Driver.(*pipeline.InMemoryDriver),
 		dataframe: dataframe,
 		period: period,
 		samples: make(map[[2]int][]float64),
@@ -109,7 +112,10 @@ func (p *Predictor4) Predict() map[[2]int]Prediction {
 	}
 
 	// update standard deviations
-	matrix := pipeline.LoadMatrix(p.dataframe)
+	matrix, err := p.ctx.LoadMatrix(p.dataframe)
+	if err != nil {
+		panic(err)
+	}
 	for cell, _ := range matrix {
 		if len(p.samples[cell]) >= p.interval - 1 {
 			p.stddevs[cell] = 0
@@ -19,6 +19,9 @@ import (
 const TimeStep time.Duration = 60*time.Second //30*time.Second
 const GridSize float64 = 512 //256
 
+// Grid of the drone locations, and of the matrix data that drones observe.
+var Grid = pipeline.Grid{Size: GridSize}
+
 // Fully charged battery level.
 const DefaultBattery int = 60 //80
 
@@ -91,9 +94,15 @@ func (drone *Drone) Step(s *Simulation) {
 	// collect observation
 	for dataframe, ds := range s.DataSources {
 		val := ds(drone.Location, s.Time)
-		cellBounds := pipeline.GetCellRect(drone.Location, GridSize).AddTol(GridSize/10)
-		frame := pipeline.GetDriver().AddFrame(0, s.Time, cellBounds.ToPolygon())
-		md := pipeline.AddMatrixData(dataframe, drone.Location[0], drone.Location[1], val, "", s.Time)
+		cellBounds := pipeline.GetCellRect(drone.Location, Grid).AddTol(GridSize/10)
+		frame, err := s.Context.Driver.AddFrame(0, 0, s.Time, cellBounds.ToPolygon())
+		if err != nil {
+			panic(err)
+		}
+		md, err := s.Context.AddMatrixData(dataframe, drone.Location[0], drone.Location[1], val, "", s.Time)
+		if err != nil {
+			panic(err)
+		}
 		if false {
 			fmt.Printf("[drone] insert a frame at %v (frame_id=%d, md_id=%d)\n", drone.Location, frame.ID, md.ID)
 		}
@@ -101,6 +110,9 @@ func (drone *Drone) Step(s *Simulation) {
 }
 
 type Simulation struct {
+	// Context that frames and observations are added to.
+	Context *pipeline.Context
+
 	// Map fram dataframe name to DataSource function.
 	DataSources map[string]DataSource
 